@@ -0,0 +1,237 @@
+package cephapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NpoolDevOps/fbc-devops-peer/api/systemapi"
+	"golang.org/x/xerrors"
+)
+
+const (
+	HealthOk   = "HEALTH_OK"
+	HealthWarn = "HEALTH_WARN"
+	HealthErr  = "HEALTH_ERR"
+)
+
+// cephTimeout bounds each ceph command, an unreachable monitor otherwise
+// blocks the command forever
+var cephTimeout = 30 * time.Second
+
+// OsdDataDir holds one ceph-<id> directory per osd hosted on this node
+var OsdDataDir = "/var/lib/ceph/osd"
+
+type HealthCheck struct {
+	Severity string `json:"severity"`
+	Summary  struct {
+		Message string `json:"message"`
+	} `json:"summary"`
+}
+
+type OsdMap struct {
+	Epoch     uint64 `json:"epoch"`
+	NumOsds   uint64 `json:"num_osds"`
+	NumUpOsds uint64 `json:"num_up_osds"`
+	NumInOsds uint64 `json:"num_in_osds"`
+}
+
+type PgState struct {
+	StateName string `json:"state_name"`
+	Count     uint64 `json:"count"`
+}
+
+type PgMap struct {
+	PgsByState []PgState `json:"pgs_by_state"`
+	NumPgs     uint64    `json:"num_pgs"`
+	NumPools   uint64    `json:"num_pools"`
+	BytesUsed  uint64    `json:"bytes_used"`
+	BytesAvail uint64    `json:"bytes_avail"`
+	BytesTotal uint64    `json:"bytes_total"`
+}
+
+// Status is the output of ceph -s -f json
+type Status struct {
+	Fsid   string `json:"fsid"`
+	Health struct {
+		Status string                 `json:"status"`
+		Checks map[string]HealthCheck `json:"checks"`
+	} `json:"health"`
+	OsdMap OsdMap `json:"-"`
+	PgMap  PgMap  `json:"pgmap"`
+}
+
+type PoolStats struct {
+	Stored      uint64  `json:"stored"`
+	Objects     uint64  `json:"objects"`
+	BytesUsed   uint64  `json:"bytes_used"`
+	PercentUsed float64 `json:"percent_used"`
+	MaxAvail    uint64  `json:"max_avail"`
+}
+
+type Pool struct {
+	Name  string    `json:"name"`
+	Id    int64     `json:"id"`
+	Stats PoolStats `json:"stats"`
+}
+
+// Df is the output of ceph df -f json
+type Df struct {
+	Stats struct {
+		TotalBytes      uint64 `json:"total_bytes"`
+		TotalAvailBytes uint64 `json:"total_avail_bytes"`
+		TotalUsedBytes  uint64 `json:"total_used_bytes"`
+	} `json:"stats"`
+	Pools []Pool `json:"pools"`
+}
+
+type OsdDfNode struct {
+	Id          int64   `json:"id"`
+	Name        string  `json:"name"`
+	DeviceClass string  `json:"device_class"`
+	Kb          uint64  `json:"kb"`
+	KbUsed      uint64  `json:"kb_used"`
+	KbAvail     uint64  `json:"kb_avail"`
+	Utilization float64 `json:"utilization"`
+	Pgs         uint64  `json:"pgs"`
+	Status      string  `json:"status"`
+}
+
+// OsdDf is the output of ceph osd df -f json
+type OsdDf struct {
+	Nodes []OsdDfNode `json:"nodes"`
+}
+
+// DaemonStatus is the output of ceph daemon osd.<id> status, it is read
+// from the local admin socket so it works without cluster keyring
+type DaemonStatus struct {
+	ClusterFsid string `json:"cluster_fsid"`
+	OsdFsid     string `json:"osd_fsid"`
+	Whoami      int64  `json:"whoami"`
+	State       string `json:"state"`
+	NumPgs      uint64 `json:"num_pgs"`
+}
+
+func ParseStatus(b []byte) (*Status, error) {
+	status := &Status{}
+	err := json.Unmarshal(b, status)
+	if err != nil {
+		return nil, xerrors.Errorf("fail to parse ceph status: %v", err)
+	}
+
+	// before pacific the osdmap is nested in another osdmap
+	osdMap := struct {
+		OsdMap json.RawMessage `json:"osdmap"`
+	}{}
+	json.Unmarshal(b, &osdMap)
+	nested := struct {
+		OsdMap *OsdMap `json:"osdmap"`
+	}{}
+	if json.Unmarshal(osdMap.OsdMap, &nested) == nil && nested.OsdMap != nil {
+		status.OsdMap = *nested.OsdMap
+	} else {
+		json.Unmarshal(osdMap.OsdMap, &status.OsdMap)
+	}
+
+	if status.Health.Status == "" {
+		return nil, xerrors.Errorf("no health in ceph status")
+	}
+	return status, nil
+}
+
+func ParseDf(b []byte) (*Df, error) {
+	df := &Df{}
+	err := json.Unmarshal(b, df)
+	if err != nil {
+		return nil, xerrors.Errorf("fail to parse ceph df: %v", err)
+	}
+	return df, nil
+}
+
+func ParseOsdDf(b []byte) (*OsdDf, error) {
+	df := &OsdDf{}
+	err := json.Unmarshal(b, df)
+	if err != nil {
+		return nil, xerrors.Errorf("fail to parse ceph osd df: %v", err)
+	}
+	return df, nil
+}
+
+func ParseDaemonStatus(b []byte) (*DaemonStatus, error) {
+	status := &DaemonStatus{}
+	err := json.Unmarshal(b, status)
+	if err != nil {
+		return nil, xerrors.Errorf("fail to parse osd daemon status: %v", err)
+	}
+	return status, nil
+}
+
+func cephCommand(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, cephTimeout)
+	defer cancel()
+	return systemapi.RunCommand(exec.CommandContext(ctx, "ceph", args...))
+}
+
+func ceph(ctx context.Context, args ...string) ([]byte, error) {
+	seconds := int(cephTimeout.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return cephCommand(ctx, append(args, "--connect-timeout", fmt.Sprintf("%v", seconds), "-f", "json")...)
+}
+
+func GetStatus(ctx context.Context) (*Status, error) {
+	out, err := ceph(ctx, "-s")
+	if err != nil {
+		return nil, xerrors.Errorf("fail to run ceph -s: %v", err)
+	}
+	return ParseStatus(out)
+}
+
+func GetDf(ctx context.Context) (*Df, error) {
+	out, err := ceph(ctx, "df")
+	if err != nil {
+		return nil, xerrors.Errorf("fail to run ceph df: %v", err)
+	}
+	return ParseDf(out)
+}
+
+func GetOsdDf(ctx context.Context) (*OsdDf, error) {
+	out, err := ceph(ctx, "osd", "df")
+	if err != nil {
+		return nil, xerrors.Errorf("fail to run ceph osd df: %v", err)
+	}
+	return ParseOsdDf(out)
+}
+
+// GetDaemonStatus asks the local admin socket, which needs no monitor
+func GetDaemonStatus(ctx context.Context, id int64) (*DaemonStatus, error) {
+	out, err := cephCommand(ctx, "daemon", "osd."+strconv.FormatInt(id, 10), "status")
+	if err != nil {
+		return nil, xerrors.Errorf("fail to get status of osd.%v: %v", id, err)
+	}
+	return ParseDaemonStatus(out)
+}
+
+// GetLocalOsds returns the ids of osds with data directory on this node
+func GetLocalOsds() ([]int64, error) {
+	dirs, err := filepath.Glob(filepath.Join(OsdDataDir, "*-*"))
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	for _, dir := range dirs {
+		s := strings.Split(filepath.Base(dir), "-")
+		id, err := strconv.ParseInt(s[len(s)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
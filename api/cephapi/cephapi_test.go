@@ -0,0 +1,142 @@
+package cephapi
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseStatus(t *testing.T) {
+	status, err := ParseStatus(readFixture(t, "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Health.Status != HealthWarn {
+		t.Errorf("health should be %v, got %v", HealthWarn, status.Health.Status)
+	}
+	if check, ok := status.Health.Checks["OSD_DOWN"]; !ok || check.Summary.Message != "1 osds down" {
+		t.Errorf("OSD_DOWN check is not parsed: %v", status.Health.Checks)
+	}
+	if status.OsdMap != (OsdMap{Epoch: 321, NumOsds: 12, NumUpOsds: 11, NumInOsds: 12}) {
+		t.Errorf("unexpected osdmap %+v", status.OsdMap)
+	}
+	if status.PgMap.NumPgs != 256 || len(status.PgMap.PgsByState) != 2 {
+		t.Errorf("unexpected pgmap %+v", status.PgMap)
+	}
+}
+
+func TestParseStatusNestedOsdMap(t *testing.T) {
+	status, err := ParseStatus(readFixture(t, "status_nautilus.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Health.Status != HealthOk {
+		t.Errorf("health should be %v, got %v", HealthOk, status.Health.Status)
+	}
+	if status.OsdMap != (OsdMap{Epoch: 120, NumOsds: 6, NumUpOsds: 6, NumInOsds: 6}) {
+		t.Errorf("unexpected osdmap %+v", status.OsdMap)
+	}
+}
+
+func TestParseStatusInvalid(t *testing.T) {
+	if _, err := ParseStatus([]byte(`{"fsid": "x"}`)); err == nil {
+		t.Errorf("status without health should fail")
+	}
+	if _, err := ParseStatus([]byte(`no cluster connection`)); err == nil {
+		t.Errorf("non json status should fail")
+	}
+}
+
+func TestParseDf(t *testing.T) {
+	df, err := ParseDf(readFixture(t, "df.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if df.Stats.TotalBytes != 131941395333120 || df.Stats.TotalUsedBytes != 13194139533312 {
+		t.Errorf("unexpected df stats %+v", df.Stats)
+	}
+	if len(df.Pools) != 2 || df.Pools[1].Name != "sectors" || df.Pools[1].Stats.Stored != 4398045462528 {
+		t.Errorf("unexpected pools %+v", df.Pools)
+	}
+}
+
+func TestParseOsdDf(t *testing.T) {
+	df, err := ParseOsdDf(readFixture(t, "osd_df.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(df.Nodes) != 2 {
+		t.Fatalf("should have 2 osds, got %v", len(df.Nodes))
+	}
+	if df.Nodes[0].Name != "osd.0" || df.Nodes[0].Kb != 11718746112 || df.Nodes[0].Utilization != 10.0 {
+		t.Errorf("unexpected osd %+v", df.Nodes[0])
+	}
+	if df.Nodes[1].Status != "down" {
+		t.Errorf("osd.1 should be down, got %v", df.Nodes[1].Status)
+	}
+}
+
+func TestParseDaemonStatus(t *testing.T) {
+	status, err := ParseDaemonStatus(readFixture(t, "daemon_status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Whoami != 0 || status.State != "active" || status.NumPgs != 64 {
+		t.Errorf("unexpected daemon status %+v", status)
+	}
+}
+
+func TestGetLocalOsds(t *testing.T) {
+	old := OsdDataDir
+	OsdDataDir = filepath.Join("testdata", "osd")
+	defer func() { OsdDataDir = old }()
+
+	ids, err := GetLocalOsds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{0, 1}) {
+		t.Errorf("local osds should be [0 1], got %v", ids)
+	}
+}
+
+func TestCephTimeout(t *testing.T) {
+	bin := t.TempDir()
+	argsFile := filepath.Join(bin, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\nexec sleep 10\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "ceph"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	old := cephTimeout
+	cephTimeout = 200 * time.Millisecond
+	defer func() { cephTimeout = old }()
+
+	start := time.Now()
+	_, err := GetStatus(context.Background())
+	if err == nil {
+		t.Fatalf("hung ceph should fail")
+	}
+	if elapsed := time.Since(start); 5*time.Second < elapsed {
+		t.Errorf("hung ceph returned after %v", elapsed)
+	}
+
+	args, _ := ioutil.ReadFile(argsFile)
+	if !strings.Contains(string(args), "--connect-timeout") {
+		t.Errorf("ceph is run without connect timeout: %v", string(args))
+	}
+}
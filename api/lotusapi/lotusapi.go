@@ -4,12 +4,16 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/EntropyPool/entropy-logger"
 	"github.com/NpoolDevOps/fbc-devops-peer/api/lotusbase"
+	"github.com/NpoolDevOps/fbc-devops-peer/parser"
 	"github.com/NpoolDevOps/fbc-devops-peer/version"
 	"github.com/filecoin-project/go-state-types/dline"
 	"github.com/filecoin-project/lotus/api"
@@ -25,12 +29,49 @@ type SyncState struct {
 	NetPeers     int
 }
 
-func lotusRpcUrl(host string) string {
-	return fmt.Sprintf("http://%v:1234/rpc/v0", host)
+// ApiVersion selects /rpc/v0 or /rpc/v1 of the fullnode when the api info
+// does not name the path, it is set from the configuration
+var ApiVersion = "v0"
+
+// endpoint takes the port and token of FULLNODE_API_INFO when it points to
+// host, other hosts are reached at the default port without token
+func endpoint(host string) *lotusbase.Endpoint {
+	ep, err := lotusbase.ParseApiInfo(os.Getenv(parser.FullnodeEnvKey), ApiVersion)
+	if err == nil {
+		epHost := ep.Hostname()
+		if host == "" || epHost == host {
+			return ep
+		}
+		// lotus listening on all interfaces writes the unspecified address
+		if ip := net.ParseIP(epHost); ip != nil && ip.IsUnspecified() {
+			u, _ := url.Parse(ep.Url)
+			u.Host = net.JoinHostPort(host, u.Port())
+			ep.Url = u.String()
+			return ep
+		}
+	}
+	return &lotusbase.Endpoint{
+		Url: fmt.Sprintf("http://%v:1234/rpc/%v", host, ApiVersion),
+	}
+}
+
+func request(host string, params interface{}, method string) ([]byte, error) {
+	ep := endpoint(host)
+	return lotusbase.RequestWithToken(ep.Url, params, method, ep.Token)
+}
+
+// requestWithBearerToken prefers the token of the caller, wallet methods
+// may need more permission than the api info grants
+func requestWithBearerToken(host string, params interface{}, method string, bearerToken string) (interface{}, error) {
+	ep := endpoint(host)
+	if bearerToken == "" && ep.Token != "" {
+		bearerToken = "Bearer " + ep.Token
+	}
+	return lotusbase.RequestWithBearerToken(ep.Url, params, method, bearerToken)
 }
 
 func ChainHeadHeight(host string) (int64, error) {
-	bh, err := lotusbase.Request(lotusRpcUrl(host), []string{}, "Filecoin.ChainHead")
+	bh, err := request(host, []string{}, "Filecoin.ChainHead")
 	if err != nil {
 		log.Errorf(log.Fields{}, "lotusapi request error: %v", err)
 		return -1, err
@@ -46,7 +87,7 @@ func ChainHeadHeight(host string) (int64, error) {
 }
 
 func stateHeightDiff(state api.SyncState, host string) (int64, error) {
-	bh, err := lotusbase.Request(lotusRpcUrl(host), []string{}, "Filecoin.ChainHead")
+	bh, err := request(host, []string{}, "Filecoin.ChainHead")
 	if err != nil {
 		return -1, err
 	}
@@ -109,7 +150,7 @@ func stateSyncElapsed(state api.SyncState) (time.Duration, bool) {
 }
 
 func ChainSyncState(host string) (*SyncState, error) {
-	bs, err := lotusbase.Request(lotusRpcUrl(host), []string{}, "Filecoin.SyncState")
+	bs, err := request(host, []string{}, "Filecoin.SyncState")
 	if err != nil {
 		return nil, err
 	}
@@ -136,7 +177,7 @@ func ChainSyncState(host string) (*SyncState, error) {
 }
 
 func ClientNetPeers(host string) (int, error) {
-	bs, err := lotusbase.Request(lotusRpcUrl(host), []string{}, "Filecoin.NetPeers")
+	bs, err := request(host, []string{}, "Filecoin.NetPeers")
 	if err != nil {
 		return -1, err
 	}
@@ -148,7 +189,7 @@ func ClientNetPeers(host string) (int, error) {
 }
 
 func ClientVersion(host string) (version.Version, error) {
-	bs, err := lotusbase.Request(lotusRpcUrl(host), []string{}, "Filecoin.Version")
+	bs, err := request(host, []string{}, "Filecoin.Version")
 	if err != nil {
 		return version.Version{}, err
 	}
@@ -163,7 +204,7 @@ func ClientVersion(host string) (version.Version, error) {
 }
 
 func TipSetByHeight(host string, height uint64) ([]string, error) {
-	bs, err := lotusbase.Request(lotusRpcUrl(host), []interface{}{height, nil}, "Filecoin.ChainGetTipSetByHeight")
+	bs, err := request(host, []interface{}{height, nil}, "Filecoin.ChainGetTipSetByHeight")
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +228,7 @@ func TipSetByHeight(host string, height uint64) ([]string, error) {
 }
 
 func ChainBaseFee(host string) (float64, error) {
-	bh, err := lotusbase.Request(lotusRpcUrl(host), []string{}, "Filecoin.ChainHead")
+	bh, err := request(host, []string{}, "Filecoin.ChainHead")
 	if err != nil {
 		return -1, err
 	}
@@ -216,7 +257,7 @@ type Deadlines struct {
 }
 
 func ProvingDeadlines(host string, minerId string) (*Deadlines, error) {
-	bh, err := lotusbase.Request(lotusRpcUrl(host), []interface{}{minerId, nil}, "Filecoin.StateMinerDeadlines")
+	bh, err := request(host, []interface{}{minerId, nil}, "Filecoin.StateMinerDeadlines")
 	if err != nil {
 		log.Errorf(log.Fields{}, "state miner deadlines fail: %v", err)
 		return nil, err
@@ -225,7 +266,7 @@ func ProvingDeadlines(host string, minerId string) (*Deadlines, error) {
 	deadlines := []api.Deadline{}
 	json.Unmarshal(bh, &deadlines)
 
-	bh, err = lotusbase.Request(lotusRpcUrl(host), []interface{}{minerId, nil}, "Filecoin.StateMinerProvingDeadline")
+	bh, err = request(host, []interface{}{minerId, nil}, "Filecoin.StateMinerProvingDeadline")
 	if err != nil {
 		log.Errorf(log.Fields{}, "state miner proving deadline fail: %v", err)
 		return nil, err
@@ -239,7 +280,7 @@ func ProvingDeadlines(host string, minerId string) (*Deadlines, error) {
 	}
 
 	for dlIdx, deadline := range deadlines {
-		bh, err = lotusbase.Request(lotusRpcUrl(host), []interface{}{minerId, dlIdx, nil}, "Filecoin.StateMinerPartitions")
+		bh, err = request(host, []interface{}{minerId, dlIdx, nil}, "Filecoin.StateMinerPartitions")
 		if err != nil {
 			log.Errorf(log.Fields{}, "state miner deadline partition fail: %v", err)
 			return nil, err
@@ -289,7 +330,7 @@ func ImportWallet(host string, privateKey string, bearerToken string) (string, e
 		return "", err
 	}
 
-	addr, err := lotusbase.RequestWithBearerToken(lotusRpcUrl(host), []interface{}{ki}, "Filecoin.WalletImport", bearerToken)
+	addr, err := requestWithBearerToken(host, []interface{}{ki}, "Filecoin.WalletImport", bearerToken)
 	if err != nil {
 		log.Errorf(log.Fields{}, "import wallet fail: %v", err)
 		return "", err
@@ -299,7 +340,7 @@ func ImportWallet(host string, privateKey string, bearerToken string) (string, e
 }
 
 func WalletExists(host string, address string, bearerToken string) (bool, error) {
-	exists, err := lotusbase.RequestWithBearerToken(lotusRpcUrl(host), []interface{}{address}, "Filecoin.WalletHas", bearerToken)
+	exists, err := requestWithBearerToken(host, []interface{}{address}, "Filecoin.WalletHas", bearerToken)
 	if err != nil {
 		log.Errorf(log.Fields{}, "check wallet exists fail: %v", err)
 		return false, err
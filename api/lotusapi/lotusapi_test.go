@@ -3,6 +3,8 @@ package lotusapi
 import (
 	"fmt"
 	"testing"
+
+	"github.com/NpoolDevOps/fbc-devops-peer/api/lotusbase"
 )
 
 func TestLotusapi(t *testing.T) {
@@ -13,3 +15,23 @@ func TestLotusapi(t *testing.T) {
 	}
 	fmt.Println("height is", height)
 }
+
+func TestEndpoint(t *testing.T) {
+	t.Setenv("FULLNODE_API_INFO", "token:/ip4/10.0.0.1/tcp/1235/http")
+	for host, expected := range map[string]lotusbase.Endpoint{
+		"10.0.0.1": {Url: "http://10.0.0.1:1235/rpc/v0", Token: "token"},
+		"10.0.0.9": {Url: "http://10.0.0.9:1234/rpc/v0"},
+	} {
+		if ep := endpoint(host); *ep != expected {
+			t.Errorf("endpoint of %v is %+v, expected %+v", host, *ep, expected)
+		}
+	}
+
+	t.Setenv("FULLNODE_API_INFO", "token:/ip4/0.0.0.0/tcp/1235/http")
+	ApiVersion = "v1"
+	defer func() { ApiVersion = "v0" }()
+	expected := lotusbase.Endpoint{Url: "http://10.0.0.1:1235/rpc/v1", Token: "token"}
+	if ep := endpoint("10.0.0.1"); *ep != expected {
+		t.Errorf("endpoint of unspecified address is %+v, expected %+v", *ep, expected)
+	}
+}
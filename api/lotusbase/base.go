@@ -2,15 +2,105 @@ package lotusbase
 
 import (
 	"encoding/json"
+	"fmt"
 	log "github.com/EntropyPool/entropy-logger"
 	"github.com/NpoolRD/http-daemon"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"golang.org/x/xerrors"
+	"net/url"
+	"strings"
 	"sync"
 )
 
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
 type RpcResult struct {
 	Jsonrpc string      `json:"jsonrpc"`
 	Result  interface{} `json:"result"`
+	Error   *RpcError   `json:"error"`
+}
+
+// Endpoint is a json-rpc url with the token authorizing it
+type Endpoint struct {
+	Url   string
+	Token string
+}
+
+// Hostname is the host of the endpoint without port
+func (ep *Endpoint) Hostname() string {
+	u, err := url.Parse(ep.Url)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// ParseApiInfo parses TOKEN:ADDRESS of the *_API_INFO environment, the token
+// is optional and the address is a multiaddr or an url like lotus accepts,
+// version is used when the address does not name the rpc path
+func ParseApiInfo(info string, version string) (*Endpoint, error) {
+	info = strings.TrimSpace(info)
+	token := ""
+	if s := strings.SplitN(info, ":", 2); len(s) == 2 && !strings.HasPrefix(info, "/") && !strings.HasPrefix(s[1], "//") {
+		token = s[0]
+		info = s[1]
+	}
+
+	if !strings.HasPrefix(info, "/") {
+		return parseApiUrl(info, token, version)
+	}
+
+	addr, err := ma.NewMultiaddr(info)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid api address %v: %v", info, err)
+	}
+	_, hostport, err := manet.DialArgs(addr)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid api address %v: %v", info, err)
+	}
+
+	scheme := "http"
+	if _, err := addr.ValueForProtocol(ma.P_HTTPS); err == nil {
+		scheme = "https"
+	}
+	if _, err := addr.ValueForProtocol(ma.P_WSS); err == nil {
+		scheme = "https"
+	}
+
+	return &Endpoint{
+		Url:   fmt.Sprintf("%v://%v/rpc/%v", scheme, hostport, version),
+		Token: token,
+	}, nil
+}
+
+func parseApiUrl(info string, token string, version string) (*Endpoint, error) {
+	u, err := url.Parse(info)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid api address %v: %v", info, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, xerrors.Errorf("invalid api address %v", info)
+	}
+	if u.Host == "" {
+		return nil, xerrors.Errorf("invalid api address %v", info)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/rpc/" + version
+	}
+	return &Endpoint{
+		Url:   u.String(),
+		Token: token,
+	}, nil
 }
 
 type RpcParam struct {
@@ -43,7 +133,15 @@ func NewRpcParam(method string, params interface{}) *RpcParam {
 }
 
 func Request(url string, params interface{}, method string) ([]byte, error) {
-	ret, err := RequestWithBearerToken(url, params, method, "")
+	return RequestWithToken(url, params, method, "")
+}
+
+// RequestWithToken authorizes the request with the api token of lotus
+func RequestWithToken(url string, params interface{}, method string, token string) ([]byte, error) {
+	if token != "" {
+		token = "Bearer " + token
+	}
+	ret, err := RequestWithBearerToken(url, params, method, token)
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +173,9 @@ func RequestWithBearerToken(url string, params interface{}, method string, token
 	if err != nil {
 		return nil, err
 	}
+	if result.Error != nil {
+		return nil, xerrors.Errorf("%v to %v: %v", method, url, result.Error.Message)
+	}
 
 	if result.Result == nil {
 		return []byte{}, nil
@@ -0,0 +1,31 @@
+package lotusbase
+
+import (
+	"testing"
+)
+
+func TestParseApiInfo(t *testing.T) {
+	for info, expected := range map[string]Endpoint{
+		"token:/ip4/10.0.0.2/tcp/2345/http":   {Url: "http://10.0.0.2:2345/rpc/v0", Token: "token"},
+		"/ip4/10.0.0.1/tcp/1234/http\n":       {Url: "http://10.0.0.1:1234/rpc/v0"},
+		"token:/dns4/lotus.local/tcp/1234/ws": {Url: "http://lotus.local:1234/rpc/v0", Token: "token"},
+		"token:/ip6/fd00::1/tcp/1234/https":   {Url: "https://[fd00::1]:1234/rpc/v0", Token: "token"},
+		"token:ws://10.0.0.1:1234/rpc/v1":     {Url: "http://10.0.0.1:1234/rpc/v1", Token: "token"},
+		"https://lotus.local":                 {Url: "https://lotus.local/rpc/v0"},
+	} {
+		ep, err := ParseApiInfo(info, "v0")
+		if err != nil {
+			t.Errorf("fail to parse %v: %v", info, err)
+			continue
+		}
+		if *ep != expected {
+			t.Errorf("%v parsed to %+v, expected %+v", info, *ep, expected)
+		}
+	}
+
+	for _, info := range []string{"", "token", "token:10.0.0.1:1234", "token:ftp://10.0.0.1"} {
+		if _, err := ParseApiInfo(info, "v0"); err == nil {
+			t.Errorf("%v should be invalid", info)
+		}
+	}
+}
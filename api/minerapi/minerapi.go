@@ -33,6 +33,15 @@ type MinerInfo struct {
 	State map[string]uint64
 }
 
+// field guards against lines shorter than the layout of the lotus version
+// the parser was written for
+func field(items []string, i int) string {
+	if i < len(items) {
+		return items[i]
+	}
+	return ""
+}
+
 func parseBalance(line string) float64 {
 	balance := strings.Split(line, ":")[1]
 	balance = strings.TrimSpace(balance)
@@ -41,100 +50,111 @@ func parseBalance(line string) float64 {
 	return b
 }
 
-func GetMinerInfo(ch chan MinerInfo, sectors bool) {
+// GetMinerInfo queries the miner and its fullnode over json-rpc, lotus-miner
+// info is only run when the rpc fails
+func GetMinerInfo(ch chan MinerInfo, eps Endpoints, sectors bool) {
 	go func() {
-		inSectorState := false
-		hideSector := "--hide-sectors-info=true"
-		if sectors {
-			hideSector = "--hide-sectors-info=false"
+		info, err := getMinerInfoByRpc(eps, sectors)
+		if err == nil {
+			ch <- *info
+			return
 		}
+		log.Errorf(log.Fields{}, "fail to get miner info by rpc: %v", err)
+		ch <- getMinerInfoByCli(sectors)
+	}()
+}
 
-		info := MinerInfo{
-			State: map[string]uint64{},
-		}
+func getMinerInfoByCli(sectors bool) MinerInfo {
+	inSectorState := false
+	hideSector := "--hide-sectors-info=true"
+	if sectors {
+		hideSector = "--hide-sectors-info=false"
+	}
+
+	info := MinerInfo{
+		State: map[string]uint64{},
+	}
+
+	out, err := systemapi.RunCommand(exec.Command("/usr/local/bin/lotus-miner", "info", hideSector))
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to run lotus-miner info: %v", err)
+		return info
+	}
+	br := bufio.NewReader(bytes.NewReader(out))
 
-		out, err := systemapi.RunCommand(exec.Command("/usr/local/bin/lotus-miner", "info", hideSector))
+	for {
+		line, _, err := br.ReadLine()
 		if err != nil {
-			log.Errorf(log.Fields{}, "fail to run lotus-miner info: %v", err)
-			ch <- info
-			return
+			break
 		}
-		br := bufio.NewReader(bytes.NewReader(out))
-
-		for {
-			line, _, err := br.ReadLine()
-			if err != nil {
-				break
-			}
 
-			lineStr := strings.TrimSpace(string(line))
-			if lineStr == "" {
-				continue
-			}
+		lineStr := strings.TrimSpace(string(line))
+		if lineStr == "" {
+			continue
+		}
 
-			if strings.Contains(lineStr, "Miner: ") {
-				info.MinerId = strings.Split(lineStr, " ")[1]
-				sectorSize := strings.Split(lineStr, "(")[1]
-				sectorSize = strings.Split(sectorSize, ")")[0]
-				sectorSize = strings.TrimSpace(strings.Split(sectorSize, "GiB sectors")[0])
-				info.SectorSize, _ = strconv.ParseFloat(sectorSize, 64)
-			}
-			if strings.Contains(lineStr, "Power: ") {
-				info.Power, _ = strconv.ParseFloat(strings.Split(lineStr, " ")[1], 64)
-				info.Power = convertTiB(info.Power, lineStr)
-			}
-			if strings.Contains(lineStr, "Raw: ") {
-				info.Raw, _ = strconv.ParseFloat(strings.Split(lineStr, " ")[1], 64)
-				info.Raw = convertTiB(info.Raw, lineStr)
-			}
-			if strings.Contains(lineStr, "Committed: ") {
-				info.Committed, _ = strconv.ParseFloat(strings.Split(lineStr, " ")[1], 64)
-				info.Committed = convertTiB(info.Committed, lineStr)
-			}
-			if !inSectorState {
-				if strings.Contains(lineStr, "Proving: ") {
-					info.Proving, _ = strconv.ParseFloat(strings.Split(lineStr, " ")[1], 64)
-					info.Proving = convertTiB(info.Proving, lineStr)
-					if strings.Contains(lineStr, "Faulty, ") {
-						faulty := strings.Split(lineStr, "(")[1]
-						info.Faulty, _ = strconv.ParseFloat(strings.Split(faulty, " ")[0], 64)
-						info.Faulty = convertTiB(info.Faulty, lineStr)
-					}
+		if strings.Contains(lineStr, "Miner: ") {
+			info.MinerId = field(strings.Split(lineStr, " "), 1)
+			sectorSize := field(strings.Split(lineStr, "("), 1)
+			sectorSize = strings.Split(sectorSize, ")")[0]
+			sectorSize = strings.TrimSpace(strings.Split(sectorSize, "GiB sectors")[0])
+			info.SectorSize, _ = strconv.ParseFloat(sectorSize, 64)
+		}
+		if strings.Contains(lineStr, "Power: ") {
+			info.Power, _ = strconv.ParseFloat(field(strings.Split(lineStr, " "), 1), 64)
+			info.Power = convertTiB(info.Power, lineStr)
+		}
+		if strings.Contains(lineStr, "Raw: ") {
+			info.Raw, _ = strconv.ParseFloat(field(strings.Split(lineStr, " "), 1), 64)
+			info.Raw = convertTiB(info.Raw, lineStr)
+		}
+		if strings.Contains(lineStr, "Committed: ") {
+			info.Committed, _ = strconv.ParseFloat(field(strings.Split(lineStr, " "), 1), 64)
+			info.Committed = convertTiB(info.Committed, lineStr)
+		}
+		if !inSectorState {
+			if strings.Contains(lineStr, "Proving: ") {
+				info.Proving, _ = strconv.ParseFloat(field(strings.Split(lineStr, " "), 1), 64)
+				info.Proving = convertTiB(info.Proving, lineStr)
+				if strings.Contains(lineStr, "Faulty, ") {
+					faulty := field(strings.Split(lineStr, "("), 1)
+					info.Faulty, _ = strconv.ParseFloat(strings.Split(faulty, " ")[0], 64)
+					info.Faulty = convertTiB(info.Faulty, lineStr)
 				}
 			}
-			if strings.Contains(lineStr, "Miner Balance: ") {
-				info.MinerBalance = parseBalance(lineStr)
-			}
-			if strings.Contains(lineStr, "PreCommit: ") {
-				info.PrecommitDeposit = parseBalance(lineStr)
-			}
-			if strings.Contains(lineStr, "Pledge: ") {
-				info.InitialPledge = parseBalance(lineStr)
-			}
-			if strings.Contains(lineStr, "Vesting: ") {
-				info.Vesting = parseBalance(lineStr)
-			}
-			if strings.Contains(lineStr, "Available: ") && info.Available == 0 {
-				info.Available = parseBalance(lineStr)
-			}
-			if strings.Contains(lineStr, "Worker Balance: ") {
-				info.WorkerBalance = parseBalance(lineStr)
-			}
-			if strings.Contains(lineStr, "Control: ") {
-				info.ControlBalance = parseBalance(lineStr)
-			}
-			if inSectorState {
-				state := strings.Split(lineStr, ": ")[0]
-				count := strings.Split(lineStr, " ")[1]
-				info.State[state], _ = strconv.ParseUint(count, 10, 64)
-			}
-			if strings.Contains(lineStr, "Sectors:") {
-				inSectorState = true
-			}
 		}
+		if strings.Contains(lineStr, "Miner Balance: ") {
+			info.MinerBalance = parseBalance(lineStr)
+		}
+		if strings.Contains(lineStr, "PreCommit: ") {
+			info.PrecommitDeposit = parseBalance(lineStr)
+		}
+		if strings.Contains(lineStr, "Pledge: ") {
+			info.InitialPledge = parseBalance(lineStr)
+		}
+		if strings.Contains(lineStr, "Vesting: ") {
+			info.Vesting = parseBalance(lineStr)
+		}
+		if strings.Contains(lineStr, "Available: ") && info.Available == 0 {
+			info.Available = parseBalance(lineStr)
+		}
+		if strings.Contains(lineStr, "Worker Balance: ") {
+			info.WorkerBalance = parseBalance(lineStr)
+		}
+		if strings.Contains(lineStr, "Control: ") {
+			info.ControlBalance = parseBalance(lineStr)
+		}
+		if inSectorState {
+			state := strings.Split(lineStr, ": ")[0]
+			count := field(strings.Split(lineStr, " "), 1)
+			info.State[state], _ = strconv.ParseUint(count, 10, 64)
+		}
+		if strings.Contains(lineStr, "Sectors:") {
+			inSectorState = true
+		}
+	}
 
-		ch <- info
-	}()
+	return info
 }
 
 type SealingJob struct {
@@ -148,67 +168,79 @@ type SealingJobs struct {
 	Jobs map[string]map[string]SealingJob
 }
 
-func GetSealingJobs(ch chan SealingJobs) {
+func GetSealingJobs(ch chan SealingJobs, eps Endpoints) {
 	go func() {
-		info := SealingJobs{
-			Jobs: map[string]map[string]SealingJob{},
-		}
-
-		out, err := systemapi.RunCommand(exec.Command("/usr/local/bin/lotus-miner", "sealing", "jobs"))
-		if err != nil {
-			log.Errorf(log.Fields{}, "fail to run lotus-miner sealing jobs: %v", err)
-			ch <- info
+		info, err := getSealingJobsByRpc(eps)
+		if err == nil {
+			ch <- *info
 			return
 		}
+		log.Errorf(log.Fields{}, "fail to get sealing jobs by rpc: %v", err)
+		ch <- getSealingJobsByCli()
+	}()
+}
 
-		br := bufio.NewReader(bytes.NewReader(out))
+func getSealingJobsByCli() SealingJobs {
+	info := SealingJobs{
+		Jobs: map[string]map[string]SealingJob{},
+	}
 
-		titleLine := true
+	out, err := systemapi.RunCommand(exec.Command("/usr/local/bin/lotus-miner", "sealing", "jobs"))
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to run lotus-miner sealing jobs: %v", err)
+		return info
+	}
 
-		for {
-			line, _, err := br.ReadLine()
-			if err != nil {
-				break
-			}
+	br := bufio.NewReader(bytes.NewReader(out))
 
-			if titleLine {
-				titleLine = false
-				continue
-			}
+	titleLine := true
 
-			lineStr := strings.TrimSpace(string(line))
-			items := strings.Fields(lineStr)
-			if _, ok := info.Jobs[items[4]]; !ok {
-				info.Jobs[items[4]] = map[string]SealingJob{}
-			}
-			jobs := info.Jobs[items[4]]
-			if _, ok := jobs[items[3]]; !ok {
-				jobs[items[3]] = SealingJob{}
-			}
-			job := jobs[items[3]]
+	for {
+		line, _, err := br.ReadLine()
+		if err != nil {
+			break
+		}
+
+		if titleLine {
+			titleLine = false
+			continue
+		}
 
-			elapsedDuration, _ := time.ParseDuration(items[6])
-			elapsed := uint64(elapsedDuration.Seconds())
+		lineStr := strings.TrimSpace(string(line))
+		items := strings.Fields(lineStr)
+		if len(items) < 7 {
+			continue
+		}
+		if _, ok := info.Jobs[items[4]]; !ok {
+			info.Jobs[items[4]] = map[string]SealingJob{}
+		}
+		jobs := info.Jobs[items[4]]
+		if _, ok := jobs[items[3]]; !ok {
+			jobs[items[3]] = SealingJob{}
+		}
+		job := jobs[items[3]]
 
-			switch items[5] {
-			case "running":
-				job.Running += 1
-				if job.MaxRunning < elapsed {
-					job.MaxRunning = elapsed
-				}
-			default:
-				job.Assigned += 1
-				if job.MaxWaiting < elapsed {
-					job.MaxWaiting = elapsed
-				}
-			}
+		elapsedDuration, _ := time.ParseDuration(items[6])
+		elapsed := uint64(elapsedDuration.Seconds())
 
-			jobs[items[3]] = job
-			info.Jobs[items[4]] = jobs
+		switch items[5] {
+		case "running":
+			job.Running += 1
+			if job.MaxRunning < elapsed {
+				job.MaxRunning = elapsed
+			}
+		default:
+			job.Assigned += 1
+			if job.MaxWaiting < elapsed {
+				job.MaxWaiting = elapsed
+			}
 		}
 
-		ch <- info
-	}()
+		jobs[items[3]] = job
+		info.Jobs[items[4]] = jobs
+	}
+
+	return info
 }
 
 type WorkerInfo struct {
@@ -217,75 +249,91 @@ type WorkerInfo struct {
 	RejectTask  int
 }
 
+// WorkerInfos of the miner, Maintaining is the M flag of the patched
+// lotus-miner cli, WorkerStats of the api does not carry it
 type WorkerInfos struct {
-	Infos map[string]WorkerInfo
+	Infos          map[string]WorkerInfo
+	HasMaintaining bool
 }
 
-func GetWorkerInfos(ch chan WorkerInfos) {
+func GetWorkerInfos(ch chan WorkerInfos, eps Endpoints) {
 	go func() {
-		info := WorkerInfos{
-			Infos: map[string]WorkerInfo{},
+		info, err := getWorkerInfosByRpc(eps)
+		if err == nil {
+			ch <- *info
+			return
 		}
+		log.Errorf(log.Fields{}, "fail to get worker infos by rpc: %v", err)
+		ch <- getWorkerInfosByCli()
+	}()
+}
+
+func getWorkerInfosByCli() WorkerInfos {
+	info := WorkerInfos{
+		Infos:          map[string]WorkerInfo{},
+		HasMaintaining: true,
+	}
+
+	out, err := systemapi.RunCommand(exec.Command("/usr/local/bin/lotus-miner", "sealing", "workers"))
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to run lotus-miner sealing workers: %v", err)
+		return info
+	}
+
+	br := bufio.NewReader(bytes.NewReader(out))
+	curWorker := ""
 
-		out, err := systemapi.RunCommand(exec.Command("/usr/local/bin/lotus-miner", "sealing", "workers"))
+	for {
+		line, _, err := br.ReadLine()
 		if err != nil {
-			log.Errorf(log.Fields{}, "fail to run lotus-miner sealing workers: %v", err)
-			ch <- info
-			return
+			break
 		}
 
-		br := bufio.NewReader(bytes.NewReader(out))
-		curWorker := ""
+		lineStr := string(line)
+		status := ""
 
-		for {
-			line, _, err := br.ReadLine()
-			if err != nil {
-				break
+		if strings.HasPrefix(lineStr, "Worker ") {
+			hostStr := lineStr
+			if s := strings.SplitN(lineStr, ", host ", 2); len(s) == 2 {
+				hostStr = s[1]
 			}
-
-			lineStr := string(line)
-			status := ""
-
-			if strings.HasPrefix(lineStr, "Worker ") {
-				hostStr := strings.Split(lineStr, ", host ")[1]
-				hostStrs := strings.Split(hostStr, "/")
-				if len(hostStrs) < 2 {
-					curWorker = hostStrs[0]
-				} else {
-					hostStrs = strings.Split(hostStrs[1], " ")
-					curWorker = hostStrs[0]
-					status = strings.Replace(hostStrs[1], "(", "", -1)
-					status = strings.Replace(status, "(", "", -1)
-				}
+			hostStrs := strings.Split(hostStr, "/")
+			if len(hostStrs) < 2 {
+				curWorker = hostStrs[0]
+			} else {
+				hostStrs = strings.Split(hostStrs[1], " ")
+				curWorker = hostStrs[0]
+				status = strings.Replace(field(hostStrs, 1), "(", "", -1)
+				status = strings.Replace(status, "(", "", -1)
 			}
+		}
 
-			if _, ok := info.Infos[curWorker]; !ok && curWorker != "localhost" {
-				maintaining := 0
-				if strings.Contains(status, "M") {
-					maintaining = 1
-				}
-				rejectTask := 0
-				if strings.Contains(status, "R") {
-					rejectTask = 1
-				}
-				info.Infos[curWorker] = WorkerInfo{
-					Maintaining: maintaining,
-					RejectTask:  rejectTask,
-				}
+		if _, ok := info.Infos[curWorker]; !ok && curWorker != "localhost" {
+			maintaining := 0
+			if strings.Contains(status, "M") {
+				maintaining = 1
 			}
-
-			workerInfo := info.Infos[curWorker]
-
-			if strings.Contains(lineStr, "GPU: ") && curWorker != "localhost" {
-				workerInfo.GPUs += 1
+			rejectTask := 0
+			if strings.Contains(status, "R") {
+				rejectTask = 1
 			}
-			if curWorker != "localhost" {
-				info.Infos[curWorker] = workerInfo
+			info.Infos[curWorker] = WorkerInfo{
+				Maintaining: maintaining,
+				RejectTask:  rejectTask,
 			}
 		}
 
-		ch <- info
-	}()
+		workerInfo := info.Infos[curWorker]
+
+		if strings.Contains(lineStr, "GPU: ") && curWorker != "localhost" {
+			workerInfo.GPUs += 1
+		}
+		if curWorker != "localhost" {
+			info.Infos[curWorker] = workerInfo
+		}
+	}
+
+	return info
 }
 
 func convertTiB(value float64, line string) float64 {
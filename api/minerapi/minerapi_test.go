@@ -0,0 +1,120 @@
+package minerapi
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/NpoolDevOps/fbc-devops-peer/api/lotusbase"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var testEndpoints = Endpoints{
+	Miner:    &lotusbase.Endpoint{Url: "http://10.0.0.2:2345/rpc/v0", Token: "admin"},
+	Fullnode: &lotusbase.Endpoint{Url: "http://10.0.0.1:1234/rpc/v0", Token: "read"},
+}
+
+// useFixtures answers every method from testdata/<method>.json and records
+// the token each method was called with
+func useFixtures(t *testing.T) map[string]string {
+	tokens := map[string]string{}
+	rpcRequest = func(url string, params interface{}, method string, token string) ([]byte, error) {
+		method = strings.TrimPrefix(method, "Filecoin.")
+		tokens[method] = token
+		return ioutil.ReadFile(filepath.Join("testdata", method+".json"))
+	}
+	t.Cleanup(func() { rpcRequest = lotusbase.RequestWithToken })
+	return tokens
+}
+
+func TestMinerInfoByRpc(t *testing.T) {
+	tokens := useFixtures(t)
+
+	info, err := getMinerInfoByRpc(testEndpoints, true)
+	if err != nil {
+		t.Fatalf("fail to get miner info: %v", err)
+	}
+
+	expected := MinerInfo{
+		MinerId:          "f01234",
+		SectorSize:       32,
+		Power:            10,
+		Raw:              1,
+		Committed:        1.25,
+		Proving:          1,
+		Faulty:           0.0625,
+		MinerBalance:     100,
+		InitialPledge:    30,
+		PrecommitDeposit: 1,
+		Vesting:          20,
+		Available:        49,
+		WorkerBalance:    5,
+		ControlBalance:   10,
+		State:            map[string]uint64{"Proving": 30, "PreCommit1": 3},
+	}
+	if !reflect.DeepEqual(*info, expected) {
+		t.Errorf("miner info %+v, expected %+v", *info, expected)
+	}
+
+	if tokens["SectorsSummary"] != "admin" || tokens["StateMinerPower"] != "read" {
+		t.Errorf("methods are called with wrong tokens: %v", tokens)
+	}
+}
+
+func TestMinerInfoByRpcWithoutFullnode(t *testing.T) {
+	useFixtures(t)
+
+	_, err := getMinerInfoByRpc(Endpoints{Miner: testEndpoints.Miner}, false)
+	if err == nil {
+		t.Errorf("miner info should fail without fullnode endpoint")
+	}
+}
+
+func TestSealingJobsByRpc(t *testing.T) {
+	useFixtures(t)
+
+	jobs, err := getSealingJobsByRpc(testEndpoints)
+	if err != nil {
+		t.Fatalf("fail to get sealing jobs: %v", err)
+	}
+
+	pc1 := jobs.Jobs["PC1"]["worker-1"]
+	if pc1.Running != 1 || pc1.Assigned != 1 || pc1.MaxRunning == 0 || pc1.MaxWaiting == 0 {
+		t.Errorf("unexpected PC1 jobs of worker-1: %+v", pc1)
+	}
+	c2 := jobs.Jobs["C2"]["worker-2"]
+	if c2.Running != 0 || c2.Assigned != 1 {
+		t.Errorf("unexpected C2 jobs of worker-2: %+v", c2)
+	}
+	if len(jobs.Jobs) != 2 {
+		t.Errorf("unexpected tasks: %v", jobs.Jobs)
+	}
+}
+
+func TestWorkerInfosByRpc(t *testing.T) {
+	useFixtures(t)
+
+	infos, err := getWorkerInfosByRpc(testEndpoints)
+	if err != nil {
+		t.Fatalf("fail to get worker infos: %v", err)
+	}
+
+	expected := map[string]WorkerInfo{
+		"worker-1": {GPUs: 1},
+		"worker-2": {RejectTask: 1},
+	}
+	if !reflect.DeepEqual(infos.Infos, expected) {
+		t.Errorf("worker infos %v, expected %v", infos.Infos, expected)
+	}
+	if infos.HasMaintaining {
+		t.Errorf("worker infos by rpc cannot know maintaining workers")
+	}
+}
+
+func TestToTiBNil(t *testing.T) {
+	if toTiB(types.BigInt{}) != 0 || toFIL(types.BigInt{}) != 0 {
+		t.Errorf("nil big int should convert to 0")
+	}
+}
@@ -0,0 +1,235 @@
+package minerapi
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/NpoolDevOps/fbc-devops-peer/api/lotusbase"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"golang.org/x/xerrors"
+)
+
+// Endpoints of the miner and the fullnode it syncs from, worker and job
+// queries need the admin token of the miner
+type Endpoints struct {
+	Miner    *lotusbase.Endpoint
+	Fullnode *lotusbase.Endpoint
+}
+
+// rpcRequest is replaced by tests to answer from fixtures
+var rpcRequest = lotusbase.RequestWithToken
+
+func call(ep *lotusbase.Endpoint, method string, params []interface{}, result interface{}) error {
+	if ep == nil {
+		return xerrors.Errorf("no endpoint for %v", method)
+	}
+	b, err := rpcRequest(ep.Url, params, "Filecoin."+method, ep.Token)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, result)
+	if err != nil {
+		return xerrors.Errorf("fail to parse %v result: %v", method, err)
+	}
+	return nil
+}
+
+func toTiB(bytes types.BigInt) float64 {
+	if bytes.Int == nil {
+		return 0
+	}
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(bytes.Int), big.NewFloat(1<<40)).Float64()
+	return f
+}
+
+func toFIL(atto types.BigInt) float64 {
+	if atto.Int == nil {
+		return 0
+	}
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(atto.Int), big.NewFloat(1e18)).Float64()
+	return f
+}
+
+// minerState holds the fields of the miner actor state used by lotus-miner
+// info, InitialPledgeRequirement is the name before actors v2
+type minerState struct {
+	PreCommitDeposits        types.BigInt
+	LockedFunds              types.BigInt
+	InitialPledge            types.BigInt
+	InitialPledgeRequirement types.BigInt
+}
+
+func getMinerInfoByRpc(eps Endpoints, sectors bool) (*MinerInfo, error) {
+	info := MinerInfo{
+		State: map[string]uint64{},
+	}
+
+	var addr string
+	if err := call(eps.Miner, "ActorAddress", []interface{}{}, &addr); err != nil {
+		return nil, err
+	}
+	info.MinerId = addr
+
+	var sectorSize uint64
+	if err := call(eps.Miner, "ActorSectorSize", []interface{}{addr}, &sectorSize); err != nil {
+		return nil, err
+	}
+	info.SectorSize = float64(sectorSize) / (1 << 30)
+
+	power := api.MinerPower{}
+	if err := call(eps.Fullnode, "StateMinerPower", []interface{}{addr, nil}, &power); err != nil {
+		return nil, err
+	}
+	info.Power = toTiB(power.MinerPower.QualityAdjPower)
+	info.Raw = toTiB(power.MinerPower.RawBytePower)
+
+	counts := api.MinerSectors{}
+	if err := call(eps.Fullnode, "StateMinerSectorCount", []interface{}{addr, nil}, &counts); err != nil {
+		return nil, err
+	}
+	info.Committed = toTiB(types.NewInt(counts.Live * sectorSize))
+	info.Proving = toTiB(types.NewInt((counts.Active + counts.Faulty) * sectorSize))
+	info.Faulty = toTiB(types.NewInt(counts.Faulty * sectorSize))
+
+	actor := types.Actor{}
+	if err := call(eps.Fullnode, "StateGetActor", []interface{}{addr, nil}, &actor); err != nil {
+		return nil, err
+	}
+	info.MinerBalance = toFIL(actor.Balance)
+
+	state := struct {
+		State minerState
+	}{}
+	if err := call(eps.Fullnode, "StateReadState", []interface{}{addr, nil}, &state); err != nil {
+		return nil, err
+	}
+	info.PrecommitDeposit = toFIL(state.State.PreCommitDeposits)
+	info.Vesting = toFIL(state.State.LockedFunds)
+	info.InitialPledge = toFIL(state.State.InitialPledge)
+	if state.State.InitialPledge.Int == nil {
+		info.InitialPledge = toFIL(state.State.InitialPledgeRequirement)
+	}
+
+	var available types.BigInt
+	if err := call(eps.Fullnode, "StateMinerAvailableBalance", []interface{}{addr, nil}, &available); err != nil {
+		return nil, err
+	}
+	info.Available = toFIL(available)
+
+	mi := struct {
+		Worker           string
+		ControlAddresses []string
+	}{}
+	if err := call(eps.Fullnode, "StateMinerInfo", []interface{}{addr, nil}, &mi); err != nil {
+		return nil, err
+	}
+	var balance types.BigInt
+	if err := call(eps.Fullnode, "WalletBalance", []interface{}{mi.Worker}, &balance); err != nil {
+		return nil, err
+	}
+	info.WorkerBalance = toFIL(balance)
+	for _, control := range mi.ControlAddresses {
+		var balance types.BigInt
+		if err := call(eps.Fullnode, "WalletBalance", []interface{}{control}, &balance); err != nil {
+			return nil, err
+		}
+		info.ControlBalance += toFIL(balance)
+	}
+
+	if sectors {
+		summary := map[string]int{}
+		if err := call(eps.Miner, "SectorsSummary", []interface{}{}, &summary); err != nil {
+			return nil, err
+		}
+		for state, count := range summary {
+			info.State[state] = uint64(count)
+		}
+	}
+
+	return &info, nil
+}
+
+func getWorkerStats(eps Endpoints) (map[string]storiface.WorkerStats, error) {
+	stats := map[string]storiface.WorkerStats{}
+	err := call(eps.Miner, "WorkerStats", []interface{}{}, &stats)
+	return stats, err
+}
+
+func getSealingJobsByRpc(eps Endpoints) (*SealingJobs, error) {
+	jobs := map[string][]storiface.WorkerJob{}
+	if err := call(eps.Miner, "WorkerJobs", []interface{}{}, &jobs); err != nil {
+		return nil, err
+	}
+	stats, err := getWorkerStats(eps)
+	if err != nil {
+		return nil, err
+	}
+
+	info := SealingJobs{
+		Jobs: map[string]map[string]SealingJob{},
+	}
+	for worker, workerJobs := range jobs {
+		for _, wj := range workerJobs {
+			// returned jobs do not carry the hostname
+			hostname := wj.Hostname
+			if hostname == "" {
+				hostname = stats[worker].Info.Hostname
+			}
+			task := wj.Task.Short()
+			if _, ok := info.Jobs[task]; !ok {
+				info.Jobs[task] = map[string]SealingJob{}
+			}
+			job := info.Jobs[task][hostname]
+
+			elapsed := uint64(0)
+			if !wj.Start.IsZero() {
+				elapsed = uint64(time.Since(wj.Start).Seconds())
+			}
+
+			if wj.RunWait == 0 {
+				job.Running += 1
+				if job.MaxRunning < elapsed {
+					job.MaxRunning = elapsed
+				}
+			} else {
+				job.Assigned += 1
+				if job.MaxWaiting < elapsed {
+					job.MaxWaiting = elapsed
+				}
+			}
+			info.Jobs[task][hostname] = job
+		}
+	}
+
+	return &info, nil
+}
+
+// getWorkerInfosByRpc cannot tell maintaining workers, WorkerStats has no
+// such state so Maintaining is left unknown
+func getWorkerInfosByRpc(eps Endpoints) (*WorkerInfos, error) {
+	stats, err := getWorkerStats(eps)
+	if err != nil {
+		return nil, err
+	}
+
+	info := WorkerInfos{
+		Infos: map[string]WorkerInfo{},
+	}
+	for _, stat := range stats {
+		hostname := stat.Info.Hostname
+		if hostname == "localhost" {
+			continue
+		}
+		workerInfo := info.Infos[hostname]
+		workerInfo.GPUs += len(stat.Info.Resources.GPUs)
+		if !stat.Enabled {
+			workerInfo.RejectTask = 1
+		}
+		info.Infos[hostname] = workerInfo
+	}
+
+	return &info, nil
+}
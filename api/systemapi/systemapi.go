@@ -10,7 +10,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/EntropyPool/entropy-logger"
 	runtime "github.com/NpoolDevOps/fbc-devops-peer/runtime"
@@ -62,6 +64,46 @@ func MountpointWrittable(mountpoint string) (bool, error) {
 	return false, xerrors.Errorf("mountpoint %v not found", mountpoint)
 }
 
+type pendingStat struct {
+	done chan struct{}
+	err  error
+}
+
+// A hung stat never returns, so at most one stat runs per path and later
+// callers wait for the same result
+var (
+	stat         = os.Stat
+	pendingStats = map[string]*pendingStat{}
+	statMutex    sync.Mutex
+)
+
+// StatTimeout stats path in background, a hung network filesystem blocks
+// the goroutine but not the caller
+func StatTimeout(path string, timeout time.Duration) error {
+	statMutex.Lock()
+	st, ok := pendingStats[path]
+	if !ok {
+		st = &pendingStat{done: make(chan struct{})}
+		pendingStats[path] = st
+		go func() {
+			_, err := stat(path)
+			statMutex.Lock()
+			delete(pendingStats, path)
+			statMutex.Unlock()
+			st.err = err
+			close(st.done)
+		}()
+	}
+	statMutex.Unlock()
+
+	select {
+	case <-st.done:
+		return st.err
+	case <-time.After(timeout):
+		return xerrors.Errorf("stat %v timeout after %v", path, timeout)
+	}
+}
+
 func StatSubDirs(dir string, sublevel int) map[string]error {
 	stat := map[string]error{}
 	mySlashes := strings.Count(dir, "/")
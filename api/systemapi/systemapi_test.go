@@ -0,0 +1,45 @@
+package systemapi
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatTimeoutSingleInFlight(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	stat = func(path string) (os.FileInfo, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil, nil
+	}
+	defer func() { stat = os.Stat }()
+
+	for i := 0; i < 3; i++ {
+		if StatTimeout("/mnt/hung", 10*time.Millisecond) == nil {
+			t.Fatalf("hung stat should time out")
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("%v stats in flight for one path, expect 1", n)
+	}
+
+	close(release)
+	for i := 0; i < 100; i++ {
+		statMutex.Lock()
+		pending := len(pendingStats)
+		statMutex.Unlock()
+		if pending == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := StatTimeout("/mnt/hung", time.Second); err != nil {
+		t.Fatalf("stat should return after release: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("%v stats after the hung one returned, expect 2", n)
+	}
+}
@@ -1,6 +1,7 @@
 package basenode
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,12 +9,14 @@ import (
 	"net/http"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/EntropyPool/entropy-logger"
 	machspec "github.com/EntropyPool/machine-spec"
 	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
 	exporter "github.com/NpoolDevOps/fbc-devops-peer/exporter"
+	lifecycle "github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
 	basemetrics "github.com/NpoolDevOps/fbc-devops-peer/metrics/basemetrics"
 	parser "github.com/NpoolDevOps/fbc-devops-peer/parser"
 	"github.com/NpoolDevOps/fbc-devops-peer/peer"
@@ -34,14 +37,21 @@ type Basenode struct {
 	Id            uuid.UUID
 	devopsClient  *devops.DevopsClient
 	parser        *parser.Parser
+	parserLock    sync.RWMutex
 	HasId         bool
 	TestMode      bool
 	Peer          *peer.Peer
 	hasPublicAddr bool
 	hasLocalAddr  bool
-	addrNotifier  func(string, string)
+	// mutex guards the notifiers and the sub role, the watcher and the
+	// address updater use them while roles add their notifiers
+	mutex         sync.Mutex
+	addrNotifiers []func(string, string)
+	topoNotifiers []func()
 	BaseMetrics   *basemetrics.BaseMetrics
 	Versions      []version.Version
+	ctx           context.Context
+	cancel        context.CancelFunc
 }
 
 type NodeHardware struct {
@@ -83,6 +93,7 @@ type BasenodeConfig struct {
 	Password      string
 	NetworkType   string
 	TestMode      bool
+	Context       context.Context
 }
 
 func NewBasenode(config *BasenodeConfig, devopsClient *devops.DevopsClient) *Basenode {
@@ -98,6 +109,12 @@ func NewBasenode(config *BasenodeConfig, devopsClient *devops.DevopsClient) *Bas
 		hasLocalAddr: true,
 	}
 
+	parent := config.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	basenode.ctx, basenode.cancel = context.WithCancel(parent)
+
 	spec := machspec.NewMachineSpec()
 	spec.PrepareLowLevel()
 	basenode.NodeDesc.MySpec = spec.SN()
@@ -105,19 +122,21 @@ func NewBasenode(config *BasenodeConfig, devopsClient *devops.DevopsClient) *Bas
 	basenode.NodeDesc.HardwareInfo = &NodeHardware{}
 	basenode.NodeDesc.HardwareInfo.UpdateNodeInfo()
 
-	basenode.parser = parser.NewParser()
+	basenode.parser = parser.NewParser(&parser.ParserConfig{})
+	lifecycle.Go(func() {
+		basenode.parser.Watch(basenode.ctx, parser.ReloadInterval, basenode.parserChanged)
+	})
 
 	basenode.GetAddress()
 	basenode.AddressUpdater()
 
 	basenode.ReadOsSpec()
 
-	role, err := basenode.parser.GetSubRole(basenode.GetMainRole())
-	if err == nil {
-		basenode.NodeDesc.NodeConfig.SubRole = role
+	if role, ok := basenode.subRole(basenode.parser); ok {
+		basenode.setSubRole(role)
 	}
 
-	basenode.BaseMetrics = basemetrics.NewBaseMetrics(basenode.Username, basenode.NetworkType)
+	basenode.BaseMetrics = basemetrics.NewBaseMetrics(basenode.ctx, basenode.Username, basenode.NetworkType)
 
 	basenode.startLicenseChecker()
 	basenode.devopsClient.FeedMsg(types.DeviceRegisterAPI, basenode.ToDeviceRegisterInput(), true)
@@ -127,12 +146,98 @@ func NewBasenode(config *BasenodeConfig, devopsClient *devops.DevopsClient) *Bas
 	return basenode
 }
 
+// Context is done when the peer shuts down, roles start their loops with it
+func (n *Basenode) Context() context.Context {
+	return n.ctx
+}
+
+// Shutdown stops the loops of every role and waits for them to exit
+func (n *Basenode) Shutdown(ctx context.Context) error {
+	n.cancel()
+	return lifecycle.Wait(ctx)
+}
+
+func (n *Basenode) getParser() *parser.Parser {
+	n.parserLock.RLock()
+	defer n.parserLock.RUnlock()
+	return n.parser
+}
+
+// parserChanged takes the topology parsed again, roles get their api hosts
+// through the address notifiers and the peer exchanges parent spec again
+func (n *Basenode) parserChanged(p *parser.Parser) {
+	n.parserLock.Lock()
+	n.parser = p
+	n.parserLock.Unlock()
+
+	if role, ok := n.subRole(p); ok {
+		if old, changed := n.setSubRole(role); changed {
+			log.Infof(log.Fields{}, "sub role updated: %v -> %v", old, role)
+			n.devopsClient.FeedMsg(types.DeviceRegisterAPI, n.ToDeviceRegisterInput(), true)
+		}
+	}
+
+	localAddr, _ := n.MyLocalAddr()
+	publicAddr, _ := n.MyPublicAddr()
+	addrNotifiers, topoNotifiers := n.notifiers()
+	for _, addrNotifier := range addrNotifiers {
+		addrNotifier(localAddr, publicAddr)
+	}
+	for _, topoNotifier := range topoNotifiers {
+		topoNotifier()
+	}
+
+	if n.Peer != nil {
+		n.Peer.TopologyChanged()
+	}
+}
+
+// SetTopologyNotifier adds a notifier called after the topology is parsed
+// again, roles re-point their log files and storage paths there
+func (n *Basenode) SetTopologyNotifier(topoNotifier func()) {
+	n.mutex.Lock()
+	n.topoNotifiers = append(n.topoNotifiers, topoNotifier)
+	n.mutex.Unlock()
+}
+
+// notifiers returns copies, a notifier is called without the lock so it
+// may add another one
+func (n *Basenode) notifiers() ([]func(string, string), []func()) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	addrNotifiers := append([]func(string, string){}, n.addrNotifiers...)
+	topoNotifiers := append([]func(){}, n.topoNotifiers...)
+	return addrNotifiers, topoNotifiers
+}
+
+func (n *Basenode) setSubRole(role string) (string, bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	old := n.NodeDesc.NodeConfig.SubRole
+	n.NodeDesc.NodeConfig.SubRole = role
+	return old, old != role
+}
+
+// subRole returns the sub role of the first of my roles which has one
+func (n *Basenode) subRole(p *parser.Parser) (string, bool) {
+	for _, myRole := range n.GetRoles() {
+		role, err := p.GetSubRole(myRole)
+		if err == nil {
+			return role, true
+		}
+	}
+	return "", false
+}
+
 func (n *Basenode) SetPeer(p interface{}) {
 	n.Peer = p.(*peer.Peer)
 }
 
+// SetAddrNotifier adds a notifier, roles sharing one basenode add their own
 func (n *Basenode) SetAddrNotifier(addrNotifier func(string, string)) {
-	n.addrNotifier = addrNotifier
+	n.mutex.Lock()
+	n.addrNotifiers = append(n.addrNotifiers, addrNotifier)
+	n.mutex.Unlock()
 	var err error
 	localAddr, err := n.MyLocalAddr()
 	if err != nil {
@@ -146,12 +251,17 @@ func (n *Basenode) SetAddrNotifier(addrNotifier func(string, string)) {
 }
 
 func (n *Basenode) WatchVersions(localAddr string, err error, versionGetter func(string) []version.Version) {
-	go func() {
+	lifecycle.Go(func() {
 		ticker := time.NewTicker(2 * time.Minute)
+		defer ticker.Stop()
 		vers := []version.Version{}
 		for {
 			if err != nil {
-				<-ticker.C
+				select {
+				case <-ticker.C:
+				case <-n.ctx.Done():
+					return
+				}
 				continue
 			}
 			vs := versionGetter(localAddr)
@@ -171,9 +281,13 @@ func (n *Basenode) WatchVersions(localAddr string, err error, versionGetter func
 				n.devopsClient.FeedMsg(types.DeviceRegisterAPI, n.ToDeviceRegisterInput(), true)
 			}
 
-			<-ticker.C
+			select {
+			case <-ticker.C:
+			case <-n.ctx.Done():
+				return
+			}
 		}
-	}()
+	})
 }
 
 func (n *Basenode) SetApplicationVersions(versions []version.Version) {
@@ -311,12 +425,17 @@ func (n *Basenode) GetAddress() (string, string, error) {
 
 func (n *Basenode) AddressUpdater() {
 	ticker := time.NewTicker(2 * time.Minute)
-	go func() {
+	lifecycle.Go(func() {
+		defer ticker.Stop()
 		for {
 			updated := false
 			localAddr, publicAddr, err := n.GetAddress()
 			if err != nil {
-				<-ticker.C
+				select {
+				case <-ticker.C:
+				case <-n.ctx.Done():
+					return
+				}
 				continue
 			}
 
@@ -338,13 +457,18 @@ func (n *Basenode) AddressUpdater() {
 
 			if updated {
 				n.devopsClient.FeedMsg(types.DeviceRegisterAPI, n.ToDeviceRegisterInput(), true)
-				if n.addrNotifier != nil {
-					n.addrNotifier(localAddr, publicAddr)
+				addrNotifiers, _ := n.notifiers()
+				for _, addrNotifier := range addrNotifiers {
+					addrNotifier(localAddr, publicAddr)
 				}
 			}
-			<-ticker.C
+			select {
+			case <-ticker.C:
+			case <-n.ctx.Done():
+				return
+			}
 		}
-	}()
+	})
 }
 
 func (n *Basenode) ToDeviceRegisterInput() *types.DeviceRegisterInput {
@@ -362,7 +486,7 @@ func (n *Basenode) ToDeviceRegisterInput() *types.DeviceRegisterInput {
 		Spec:          n.NodeDesc.MySpec,
 		ParentSpec:    parentSpecs,
 		Role:          n.NodeDesc.NodeConfig.MainRole,
-		SubRole:       n.NodeDesc.NodeConfig.SubRole,
+		SubRole:       n.GetSubRole(),
 		CurrentUser:   n.Username,
 		NvmeCount:     n.NodeDesc.HardwareInfo.NvmeCount,
 		NvmeDesc:      n.NodeDesc.HardwareInfo.NvmeDesc,
@@ -430,7 +554,25 @@ func (n *Basenode) GetMainRole() string {
 	return n.NodeDesc.NodeConfig.MainRole
 }
 
+// ParseRoles splits a main role list like "miner,worker"
+func ParseRoles(mainRole string) []string {
+	roles := []string{}
+	for _, role := range strings.Split(mainRole, ",") {
+		role = strings.TrimSpace(role)
+		if role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func (n *Basenode) GetRoles() []string {
+	return ParseRoles(n.GetMainRole())
+}
+
 func (n *Basenode) GetSubRole() string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
 	return n.NodeDesc.NodeConfig.SubRole
 }
 
@@ -444,43 +586,110 @@ func (n *Basenode) NotifyParentSpec(spec string) {
 	n.devopsClient.FeedMsg(types.DeviceRegisterAPI, n.ToDeviceRegisterInput(), true)
 }
 
+// GetParentIP returns the first parent of my roles, a parent on this host is
+// one of my own roles and is skipped
 func (n *Basenode) GetParentIP() (string, error) {
-	return n.parser.GetParentIP(n.GetMainRole())
+	err := xerrors.Errorf("no parent for %v", n.GetMainRole())
+	for _, role := range n.GetRoles() {
+		ip, perr := n.getParser().GetParentIP(role)
+		if perr != nil {
+			err = perr
+			continue
+		}
+		if 1 < len(n.GetRoles()) && ip == n.NodeDesc.NodeConfig.LocalAddr {
+			continue
+		}
+		return ip, nil
+	}
+	return "", err
 }
 
 func (n *Basenode) GetChildsIPs() ([]string, error) {
-	return n.parser.GetChildsIPs(n.GetMainRole())
+	err := xerrors.Errorf("no child for %v", n.GetMainRole())
+	childs := []string{}
+	found := map[string]struct{}{}
+	hasChilds := false
+	for _, role := range n.GetRoles() {
+		ips, cerr := n.getParser().GetChildsIPs(role)
+		if cerr != nil {
+			err = cerr
+			continue
+		}
+		hasChilds = true
+		for _, ip := range ips {
+			if _, ok := found[ip]; ok {
+				continue
+			}
+			if 1 < len(n.GetRoles()) && ip == n.NodeDesc.NodeConfig.LocalAddr {
+				continue
+			}
+			found[ip] = struct{}{}
+			childs = append(childs, ip)
+		}
+	}
+	if !hasChilds {
+		return nil, err
+	}
+	return childs, nil
 }
 
 func (n *Basenode) GetLogFileByRole(role string) (string, error) {
-	return n.parser.GetLogFile(role)
+	return n.getParser().GetLogFile(role)
 }
 
 func (n *Basenode) GetMinerStoragePath() []string {
-	return n.parser.GetMinerStoragePath()
+	return n.getParser().GetMinerStoragePath()
+}
+
+func (n *Basenode) GetMinerStorageBackends() map[string]string {
+	return n.getParser().GetMinerStorageBackends()
+}
+
+func (n *Basenode) GetStorageMounts() []parser.StorageMount {
+	return n.getParser().GetStorageMounts()
 }
 
 func (n *Basenode) GetFullnodeStoragePath() string {
-	return n.parser.GetFullnodeStoragePath()
+	return n.getParser().GetFullnodeStoragePath()
 }
 
 func (n *Basenode) GetFullnodeApiHost(myRole string) (string, error) {
-	return n.parser.GetApiHostByHostRole(myRole)
+	return n.getParser().GetApiHostByHostRole(myRole)
 }
 
 func (n *Basenode) GetMinerApiHost(myRole string) (string, error) {
-	return n.parser.GetApiHostByHostRole(myRole)
+	return n.getParser().GetApiHostByHostRole(myRole)
+}
+
+func (n *Basenode) GetApiInfo(myRole string) (string, error) {
+	return n.getParser().GetApiInfo(myRole)
 }
 
 func (n *Basenode) GetShareStorageRoot() (string, error) {
-	return n.parser.GetShareStorageRoot(n.GetMainRole())
+	err := xerrors.Errorf("no share storage for %v", n.GetMainRole())
+	for _, role := range n.GetRoles() {
+		root, rerr := n.getParser().GetShareStorageRoot(role)
+		if rerr == nil {
+			return root, nil
+		}
+		err = rerr
+	}
+	return "", err
 }
 func (n *Basenode) GetShareStorageRootByRole(role string) (string, error) {
-	return n.parser.GetShareStorageRoot(role)
+	return n.getParser().GetShareStorageRoot(role)
 }
 
 func (n *Basenode) GetLogFile() (string, error) {
-	return n.parser.GetLogFile(n.GetMainRole())
+	err := xerrors.Errorf("no log file for %v", n.GetMainRole())
+	for _, role := range n.GetRoles() {
+		file, lerr := n.getParser().GetLogFile(role)
+		if lerr == nil {
+			return file, nil
+		}
+		err = lerr
+	}
+	return "", err
 }
 
 func (n *Basenode) NotifyPeerId(id uuid.UUID) {
@@ -497,7 +706,7 @@ func (n *Basenode) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (n *Basenode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(n)
+	return exporter.NewExporter(n.ctx, n)
 }
 
 func (n *Basenode) Banner() {
@@ -0,0 +1,156 @@
+package basenode
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/NpoolDevOps/fbc-devops-peer/parser"
+	"github.com/NpoolDevOps/fbc-devops-peer/types"
+)
+
+func newFixtureNode(fixture string, mainRole string, localAddr string, listen ...string) *Basenode {
+	p := parser.NewParser(&parser.ParserConfig{
+		Root:      filepath.Join("testdata", fixture),
+		LocalAddr: localAddr,
+		Probe: func(url string) bool {
+			for _, port := range listen {
+				if strings.HasSuffix(url, ":"+port) {
+					return true
+				}
+			}
+			return false
+		},
+	})
+	return &Basenode{
+		NodeDesc: &NodeDesc{
+			NodeConfig: &NodeConfig{
+				MainRole:  mainRole,
+				LocalAddr: localAddr,
+			},
+		},
+		parser: p,
+	}
+}
+
+func TestFixtureMultiRoleParentIP(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		mainRole string
+		local    string
+		parent   string
+	}{
+		// the miner of the worker is on this host, the fullnode is the parent
+		{"minerworker", types.WorkerNode + "," + types.MinerNode, "10.0.0.2", "10.0.0.1"},
+		{"minerworker", types.MinerNode + "," + types.WorkerNode, "10.0.0.2", "10.0.0.1"},
+		{"minerworker", types.WorkerNode, "10.0.0.2", "10.0.0.2"},
+		{"fullnodestorage", types.FullNode + "," + types.StorageNode, "10.0.0.30", ""},
+	}
+
+	for _, test := range tests {
+		n := newFixtureNode(test.fixture, test.mainRole, test.local)
+		ip, err := n.GetParentIP()
+		if test.parent == "" {
+			if err == nil {
+				t.Errorf("%v: %v should not have parent, got %v", test.fixture, test.mainRole, ip)
+			}
+			continue
+		}
+		if err != nil || ip != test.parent {
+			t.Errorf("%v: parent of %v should be %v, got %v: %v", test.fixture, test.mainRole, test.parent, ip, err)
+		}
+	}
+}
+
+func TestFixtureMultiRoleChildsIPs(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		mainRole string
+		local    string
+		listen   []string
+		childs   []string
+	}{
+		// the ceph server on this host is not a child of the miner
+		{"minerworker", types.MinerNode + "," + types.WorkerNode, "10.0.0.2", nil, []string{"10.0.0.11", "10.0.0.12"}},
+		// 10.0.0.11 is a ceph server of the miner and a peer of the mgr
+		{"minerworker", types.MinerNode + "," + types.StorageNode, "10.0.0.2", []string{"9283"}, []string{"10.0.0.11", "10.0.0.12", "10.0.0.13"}},
+		{"fullnodestorage", types.FullNode + "," + types.StorageNode, "10.0.0.30", []string{"9283"}, []string{"10.0.0.31", "10.0.0.32"}},
+	}
+
+	for _, test := range tests {
+		n := newFixtureNode(test.fixture, test.mainRole, test.local, test.listen...)
+		childs, err := n.GetChildsIPs()
+		if err != nil {
+			t.Errorf("%v: cannot get childs of %v: %v", test.fixture, test.mainRole, err)
+			continue
+		}
+		sort.Strings(childs)
+		if !reflect.DeepEqual(childs, test.childs) {
+			t.Errorf("%v: childs of %v should be %v, got %v", test.fixture, test.mainRole, test.childs, childs)
+		}
+	}
+
+	n := newFixtureNode("minerworker", types.WorkerNode, "10.0.0.2")
+	if _, err := n.GetChildsIPs(); err == nil {
+		t.Errorf("worker should not have childs")
+	}
+}
+
+func TestFixtureMultiRoleSubRole(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		mainRole string
+		local    string
+		listen   []string
+		subRole  string
+	}{
+		{"fullnodestorage", types.FullNode + "," + types.StorageNode, "10.0.0.30", []string{"9283"}, types.StorageRoleMgr},
+		{"fullnodestorage", types.FullNode + "," + types.StorageNode, "10.0.0.30", []string{"7000"}, types.StorageRoleAPI},
+		{"fullnodestorage", types.FullNode + "," + types.StorageNode, "10.0.0.30", nil, types.StorageRoleOsd},
+		{"minerworker", types.MinerNode + "," + types.WorkerNode, "10.0.0.2", nil, ""},
+	}
+
+	for _, test := range tests {
+		n := newFixtureNode(test.fixture, test.mainRole, test.local, test.listen...)
+		role, ok := n.subRole(n.getParser())
+		if ok != (test.subRole != "") || role != test.subRole {
+			t.Errorf("%v: sub role of %v should be %q, got %q", test.fixture, test.mainRole, test.subRole, role)
+		}
+	}
+}
+
+func TestNotifiersAddedWhileTopologyChanges(t *testing.T) {
+	n := newFixtureNode("fullnodestorage", types.FullNode+","+types.StorageNode, "10.0.0.30")
+	p := n.getParser()
+	n.setSubRole(types.StorageRoleOsd)
+
+	var wg sync.WaitGroup
+	var calls int64
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			n.SetAddrNotifier(func(string, string) { atomic.AddInt64(&calls, 1) })
+			n.SetTopologyNotifier(func() { atomic.AddInt64(&calls, 1) })
+		}()
+		go func() {
+			defer wg.Done()
+			n.parserChanged(p)
+			n.GetSubRole()
+		}()
+	}
+	wg.Wait()
+
+	before := atomic.LoadInt64(&calls)
+	n.parserChanged(p)
+	if added := atomic.LoadInt64(&calls) - before; added != 40 {
+		t.Errorf("%v notifiers called, expect 40", added)
+	}
+	if role := n.GetSubRole(); role != types.StorageRoleOsd {
+		t.Errorf("sub role should be %v, got %v", types.StorageRoleOsd, role)
+	}
+}
@@ -0,0 +1 @@
+export FULLNODE_API_INFO=token:/ip4/10.0.0.1/tcp/1234/http
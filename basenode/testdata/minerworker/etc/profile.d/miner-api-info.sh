@@ -0,0 +1 @@
+export MINER_API_INFO=token:/ip4/10.0.0.2/tcp/2345/http
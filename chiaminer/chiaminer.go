@@ -17,13 +17,18 @@ type ChiaMinerNode struct {
 
 func NewChiaMinerNode(config *basenode.BasenodeConfig, devopsClient *devops.DevopsClient) *ChiaMinerNode {
 	log.Infof(log.Fields{}, "create %v node", config.NodeConfig.MainRole)
+	return NewChiaMinerNodeWithBase(basenode.NewBasenode(config, devopsClient))
+}
+
+// NewChiaMinerNodeWithBase creates the role on a basenode shared with other roles
+func NewChiaMinerNodeWithBase(base *basenode.Basenode) *ChiaMinerNode {
 	chiaminer := &ChiaMinerNode{
-		basenode.NewBasenode(config, devopsClient),
+		base,
 		nil,
 	}
 
 	logfile, _ := chiaminer.GetLogFileByRole(types.ChiaMinerNode)
-	chiaminer.chiaMinerMetrics = metrics.NewChiaMinerMetrics(logfile, chiaminer.Username, chiaminer.NetworkType)
+	chiaminer.chiaMinerMetrics = metrics.NewChiaMinerMetrics(chiaminer.Context(), logfile, chiaminer.Username, chiaminer.NetworkType)
 
 	chiaminer.SetAddrNotifier(chiaminer.addressNotifier)
 	return chiaminer
@@ -33,6 +38,10 @@ func (c *ChiaMinerNode) addressNotifier(local, public string) {
 	c.chiaMinerMetrics.SetHost(local)
 }
 
+func (c *ChiaMinerNode) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.chiaMinerMetrics}
+}
+
 func (c *ChiaMinerNode) Describe(ch chan<- *prometheus.Desc) {
 	c.chiaMinerMetrics.Describe(ch)
 	c.BaseMetrics.Describe(ch)
@@ -44,7 +53,7 @@ func (c *ChiaMinerNode) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (c *ChiaMinerNode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(c)
+	return exporter.NewExporter(c.Context(), c)
 }
 
 func (c *ChiaMinerNode) Banner() {
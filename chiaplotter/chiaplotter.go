@@ -17,13 +17,18 @@ type ChiaPlotterNode struct {
 
 func NewPlotterNode(config *basenode.BasenodeConfig, devopsClient *devops.DevopsClient) *ChiaPlotterNode {
 	log.Infof(log.Fields{}, "create %v node", config.NodeConfig.MainRole)
+	return NewPlotterNodeWithBase(basenode.NewBasenode(config, devopsClient))
+}
+
+// NewPlotterNodeWithBase creates the role on a basenode shared with other roles
+func NewPlotterNodeWithBase(base *basenode.Basenode) *ChiaPlotterNode {
 	plotter := &ChiaPlotterNode{
-		basenode.NewBasenode(config, devopsClient),
+		base,
 		nil,
 	}
 
 	logfile, _ := plotter.GetLogFileByRole(types.ChiaPlotterNode)
-	plotter.plotterMetrics = metrics.NewChiaPlotterMetrics(logfile, plotter.Username, plotter.NetworkType)
+	plotter.plotterMetrics = metrics.NewChiaPlotterMetrics(plotter.Context(), logfile, plotter.Username, plotter.NetworkType)
 
 	plotter.SetAddrNotifier(plotter.addressNotifier)
 	return plotter
@@ -33,6 +38,10 @@ func (p *ChiaPlotterNode) addressNotifier(local, public string) {
 	p.plotterMetrics.SetHost(local)
 }
 
+func (p *ChiaPlotterNode) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.plotterMetrics}
+}
+
 func (p *ChiaPlotterNode) Describe(ch chan<- *prometheus.Desc) {
 	p.plotterMetrics.Describe(ch)
 	p.BaseMetrics.Describe(ch)
@@ -44,7 +53,7 @@ func (p *ChiaPlotterNode) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (p *ChiaPlotterNode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(p)
+	return exporter.NewExporter(p.Context(), p)
 }
 
 func (p *ChiaPlotterNode) Banner() {
@@ -0,0 +1,552 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	lotusapi "github.com/NpoolDevOps/fbc-devops-peer/api/lotusapi"
+	basenode "github.com/NpoolDevOps/fbc-devops-peer/basenode"
+	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
+	multinode "github.com/NpoolDevOps/fbc-devops-peer/multinode"
+	parser "github.com/NpoolDevOps/fbc-devops-peer/parser"
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+	"github.com/docker/go-units"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFlag names the yaml configuration file, values in the file are
+// overridden by environment variables and then by command line flags
+const ConfigFlag = "config"
+
+const envPrefix = "FBC_DEVOPS_"
+
+// Leaf fields tagged with flag can also be set from the command line and from
+// the environment variable FBC_DEVOPS_<FLAG>, like FBC_DEVOPS_REPORT_HOST
+type Basenode struct {
+	MainRole    string `yaml:"main_role" flag:"main-role" usage:"First level role in cluster [fullnode | miner | fullminer | worker | storage | gateway | chiaminer | chiaplotter], a comma separated list runs several roles"`
+	NetworkType string `yaml:"network_type" flag:"network-type"`
+	Username    string `yaml:"username" flag:"username"`
+	Password    string `yaml:"password" flag:"password" usage:"Password, prefer the environment variable to keep it out of the process list"`
+	MonAddress  string `yaml:"mon_address" flag:"mon-address"`
+	TestMode    bool   `yaml:"test_mode" flag:"test-mode"`
+}
+
+type Outbox struct {
+	MaxMessages int    `yaml:"max_messages" flag:"outbox-max-messages"`
+	MaxSize     string `yaml:"max_size" flag:"outbox-max-size"`
+	DropPolicy  string `yaml:"drop_policy" flag:"outbox-drop-policy" usage:"Policy when report outbox is full [drop-oldest | drop-newest]"`
+}
+
+type TLS struct {
+	CAFile   string `yaml:"ca_file" flag:"report-ca-file" usage:"PEM CA bundle to verify the https report host"`
+	CertFile string `yaml:"cert_file" flag:"report-cert-file" usage:"PEM client certificate presented to the report host"`
+	KeyFile  string `yaml:"key_file" flag:"report-key-file" usage:"PEM key of the client certificate"`
+}
+
+type Sign struct {
+	Alg     string `yaml:"alg" flag:"report-sign-alg" usage:"Algorithm to sign reports with the device key [hmac-sha256 | ed25519]"`
+	KeyFile string `yaml:"key_file" flag:"report-sign-key-file" usage:"Hex encoded device key to sign reports, hmac secret or ed25519 seed"`
+}
+
+type Retry struct {
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	MaxDelay     time.Duration `yaml:"max_delay"`
+	Multiplier   float64       `yaml:"multiplier"`
+	Jitter       float64       `yaml:"jitter"`
+	MaxAttempts  int           `yaml:"max_attempts" flag:"report-max-attempts" usage:"Attempts before a rejected report is dropped"`
+}
+
+type Devops struct {
+	ReportHost        string        `yaml:"report_host" flag:"report-host"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" flag:"heartbeat-interval"`
+	Outbox            Outbox        `yaml:"outbox"`
+	Retry             Retry         `yaml:"retry"`
+	// ApiRetry overrides the retry of one report api, unset fields are
+	// taken from retry
+	ApiRetry map[string]Retry `yaml:"api_retry"`
+	TLS      TLS              `yaml:"tls"`
+	Sign     Sign             `yaml:"sign"`
+}
+
+type Snmp struct {
+	Monitor           bool   `yaml:"monitor" flag:"snmp-monitor"`
+	User              string `yaml:"user" flag:"snmp-user"`
+	Pass              string `yaml:"pass" flag:"snmp-pass"`
+	Target            string `yaml:"target" flag:"snmp-target"`
+	Community         string `yaml:"community" flag:"snmp-community"`
+	ConfigInBandwidth string `yaml:"config_in_bandwidth" flag:"snmp-config-in-bandwidth"`
+	LocationLabel     string `yaml:"location_label" flag:"location-label"`
+}
+
+type Gateway struct {
+	PrometheusConfigFile string        `yaml:"prometheus_config_file" flag:"prometheus-config-file"`
+	TopologyInterval     time.Duration `yaml:"topology_interval"`
+}
+
+type Peer struct {
+	OperationKeys      string        `yaml:"operation_keys" flag:"operation-keys" usage:"JSON file of trusted operation keys and their permitted actions"`
+	HttpPort           int           `yaml:"http_port" flag:"peer-port" usage:"Port of peer rpc, must be the same on all peers"`
+	ParentSpecInterval time.Duration `yaml:"parent_spec_interval"`
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout" flag:"shutdown-timeout" usage:"Time to flush outbox and log offsets before exit"`
+	StateDir           string        `yaml:"state_dir" flag:"state-dir" usage:"Directory of jobs, binary history, baseline profile and report outbox, $HOME/.fbc-devops-peer when empty"`
+}
+
+type Exporter struct {
+	Port int `yaml:"port" flag:"exporter-port" usage:"Port of prometheus exporter, must be the same on all peers"`
+}
+
+type Lotus struct {
+	ApiVersion string `yaml:"api_version" flag:"lotus-api-version" usage:"Json-rpc version of fullnode, v0 or v1, when the api info does not name it"`
+}
+
+type Parser struct {
+	FullnodeAPIFile     string        `yaml:"fullnode_api_file"`
+	FullnodeServiceFile string        `yaml:"fullnode_service_file"`
+	MinerAPIFile        string        `yaml:"miner_api_file"`
+	MinerServiceFile    string        `yaml:"miner_service_file"`
+	WorkerServiceFile   string        `yaml:"worker_service_file"`
+	CommonSetupFile     string        `yaml:"common_setup_file"`
+	HostsFile           string        `yaml:"hosts_file"`
+	CephConfigFile      string        `yaml:"ceph_config_file"`
+	ChiaMinerLogFile    string        `yaml:"chia_miner_log_file" flag:"chia-miner-log-file"`
+	ChiaPlotterLogFile  string        `yaml:"chia_plotter_log_file" flag:"chia-plotter-log-file"`
+	ReloadInterval      time.Duration `yaml:"reload_interval" flag:"parser-reload-interval" usage:"Interval to check the parsed files and parse again on change"`
+}
+
+type Config struct {
+	Basenode Basenode `yaml:"basenode"`
+	Devops   Devops   `yaml:"devops"`
+	Snmp     Snmp     `yaml:"snmp"`
+	Gateway  Gateway  `yaml:"gateway"`
+	Peer     Peer     `yaml:"peer"`
+	Exporter Exporter `yaml:"exporter"`
+	Lotus    Lotus    `yaml:"lotus"`
+	Parser   Parser   `yaml:"parser"`
+}
+
+func Default() *Config {
+	return &Config{
+		Devops: Devops{
+			HeartbeatInterval: 3 * time.Minute,
+			Outbox: Outbox{
+				MaxMessages: 1000,
+				MaxSize:     "64MiB",
+				DropPolicy:  devops.DropOldest,
+			},
+			Retry: Retry{
+				InitialDelay: devops.DefaultRetryPolicy.InitialDelay,
+				MaxDelay:     devops.DefaultRetryPolicy.MaxDelay,
+				Multiplier:   devops.DefaultRetryPolicy.Multiplier,
+				Jitter:       devops.DefaultRetryPolicy.Jitter,
+				MaxAttempts:  devops.DefaultRetryPolicy.MaxAttempts,
+			},
+			Sign: Sign{
+				Alg: devops.SignEd25519,
+			},
+		},
+		Snmp: Snmp{
+			ConfigInBandwidth: "500MiB",
+		},
+		Gateway: Gateway{
+			PrometheusConfigFile: "/usr/local/prometheus/prometheus.yml",
+			TopologyInterval:     2 * time.Minute,
+		},
+		Peer: Peer{
+			HttpPort:           52375,
+			ParentSpecInterval: 2 * time.Minute,
+			ShutdownTimeout:    30 * time.Second,
+		},
+		Exporter: Exporter{
+			Port: types.ExporterPort,
+		},
+		Lotus: Lotus{
+			ApiVersion: lotusapi.ApiVersion,
+		},
+		Parser: Parser{
+			FullnodeAPIFile:     parser.FullnodeAPIFile,
+			FullnodeServiceFile: parser.FullnodeServiceFile,
+			MinerAPIFile:        parser.MinerAPIFile,
+			MinerServiceFile:    parser.MinerServiceFile,
+			WorkerServiceFile:   parser.WorkerServiceFile,
+			CommonSetupFile:     parser.CommonSetupFile,
+			HostsFile:           parser.HostsFile,
+			CephConfigFile:      parser.CephConfigFile,
+			ChiaMinerLogFile:    parser.ChiaMinerLogFile,
+			ChiaPlotterLogFile:  parser.ChiaPlotterLogFile,
+			ReloadInterval:      parser.ReloadInterval,
+		},
+	}
+}
+
+type leaf struct {
+	path  string
+	flag  string
+	usage string
+	value reflect.Value
+}
+
+// leaves walks the struct in field order, path is the yaml path like
+// devops.outbox.max_size
+func leaves(v reflect.Value, prefix string) []leaf {
+	fields := []leaf{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		path := name
+		if prefix != "" {
+			path = fmt.Sprintf("%v.%v", prefix, name)
+		}
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			fields = append(fields, leaves(v.Field(i), path)...)
+			continue
+		}
+		fields = append(fields, leaf{
+			path:  path,
+			flag:  sf.Tag.Get("flag"),
+			usage: sf.Tag.Get("usage"),
+			value: v.Field(i),
+		})
+	}
+	return fields
+}
+
+func envVar(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// Flags returns the command line flags of the configuration with the
+// defaults as values
+func Flags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    ConfigFlag,
+			Usage:   "YAML configuration file",
+			EnvVars: []string{envVar(ConfigFlag)},
+		},
+	}
+
+	for _, f := range leaves(reflect.ValueOf(Default()).Elem(), "") {
+		if f.flag == "" {
+			continue
+		}
+		envs := []string{envVar(f.flag)}
+		switch v := f.value.Interface().(type) {
+		case string:
+			flags = append(flags, &cli.StringFlag{Name: f.flag, Usage: f.usage, Value: v, EnvVars: envs})
+		case int:
+			flags = append(flags, &cli.IntFlag{Name: f.flag, Usage: f.usage, Value: v, EnvVars: envs})
+		case bool:
+			flags = append(flags, &cli.BoolFlag{Name: f.flag, Usage: f.usage, Value: v, EnvVars: envs})
+		case time.Duration:
+			flags = append(flags, &cli.DurationFlag{Name: f.flag, Usage: f.usage, Value: v, EnvVars: envs})
+		}
+	}
+
+	return flags
+}
+
+func LoadFile(file string) (*Config, error) {
+	c := Default()
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot read config %v: %v", file, err)
+	}
+	err = yaml.UnmarshalStrict(b, c)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot parse config %v: %v", file, err)
+	}
+
+	return c, nil
+}
+
+// Load reads the configuration file if any, then applies the flags set on
+// the command line or from the environment
+func Load(cctx *cli.Context) (*Config, error) {
+	c := Default()
+
+	if file := cctx.String(ConfigFlag); file != "" {
+		var err error
+		c, err = LoadFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range leaves(reflect.ValueOf(c).Elem(), "") {
+		if f.flag == "" || !cctx.IsSet(f.flag) {
+			continue
+		}
+		switch f.value.Interface().(type) {
+		case string:
+			f.value.SetString(cctx.String(f.flag))
+		case int:
+			f.value.SetInt(int64(cctx.Int(f.flag)))
+		case bool:
+			f.value.SetBool(cctx.Bool(f.flag))
+		case time.Duration:
+			f.value.SetInt(int64(cctx.Duration(f.flag)))
+		}
+	}
+
+	return c, nil
+}
+
+// FieldError reports an invalid configuration value with its yaml path
+type FieldError struct {
+	Path string
+	Msg  string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Path, e.Msg)
+}
+
+func fieldErrorf(path string, format string, args ...interface{}) error {
+	return &FieldError{Path: path, Msg: fmt.Sprintf(format, args...)}
+}
+
+func validRole(role string) bool {
+	switch role {
+	case types.FullNode, types.MinerNode, types.FullMinerNode, types.WorkerNode,
+		types.StorageNode, types.GatewayNode, types.ChiaMinerNode, types.ChiaPlotterNode:
+		return true
+	}
+	return false
+}
+
+// Validate returns all invalid fields, a peer refuses to start with any
+func (c *Config) Validate() []error {
+	errs := []error{}
+
+	roles := basenode.ParseRoles(c.Basenode.MainRole)
+	if len(roles) == 0 {
+		errs = append(errs, fieldErrorf("basenode.main_role", "is required"))
+	}
+	found := map[string]struct{}{}
+	for _, role := range roles {
+		if !validRole(role) {
+			errs = append(errs, fieldErrorf("basenode.main_role", "unknown role %v", role))
+		} else if 1 < len(roles) && !multinode.ComposableRole(role) {
+			errs = append(errs, fieldErrorf("basenode.main_role", "role %v cannot run with other roles", role))
+		}
+		if _, ok := found[role]; ok {
+			errs = append(errs, fieldErrorf("basenode.main_role", "duplicated role %v", role))
+		}
+		found[role] = struct{}{}
+	}
+	if c.Basenode.NetworkType == "" {
+		errs = append(errs, fieldErrorf("basenode.network_type", "is required"))
+	}
+	if c.Basenode.Username == "" {
+		errs = append(errs, fieldErrorf("basenode.username", "is required"))
+	}
+	if c.Basenode.Password == "" {
+		errs = append(errs, fieldErrorf("basenode.password", "is required"))
+	}
+	if c.Basenode.MonAddress == "" {
+		errs = append(errs, fieldErrorf("basenode.mon_address", "is required"))
+	}
+
+	if c.Devops.HeartbeatInterval <= 0 {
+		errs = append(errs, fieldErrorf("devops.heartbeat_interval", "must be positive"))
+	}
+	if c.Parser.ReloadInterval <= 0 {
+		errs = append(errs, fieldErrorf("parser.reload_interval", "must be positive"))
+	}
+	switch c.Lotus.ApiVersion {
+	case "v0", "v1":
+	default:
+		errs = append(errs, fieldErrorf("lotus.api_version", "invalid version %v", c.Lotus.ApiVersion))
+	}
+	if c.Peer.ShutdownTimeout <= 0 {
+		errs = append(errs, fieldErrorf("peer.shutdown_timeout", "must be positive"))
+	}
+	if c.Peer.StateDir != "" && !filepath.IsAbs(c.Peer.StateDir) {
+		errs = append(errs, fieldErrorf("peer.state_dir", "must be an absolute path"))
+	}
+	if c.Devops.Outbox.MaxMessages <= 0 {
+		errs = append(errs, fieldErrorf("devops.outbox.max_messages", "must be positive"))
+	}
+	if _, err := units.RAMInBytes(c.Devops.Outbox.MaxSize); err != nil {
+		errs = append(errs, fieldErrorf("devops.outbox.max_size", "%v", err))
+	}
+	switch c.Devops.Outbox.DropPolicy {
+	case devops.DropOldest, devops.DropNewest:
+	default:
+		errs = append(errs, fieldErrorf("devops.outbox.drop_policy", "invalid policy %v", c.Devops.Outbox.DropPolicy))
+	}
+
+	errs = append(errs, validateRetry("devops.retry", c.Devops.Retry)...)
+	for api := range c.Devops.ApiRetry {
+		if !strings.HasPrefix(api, "/") {
+			errs = append(errs, fieldErrorf("devops.api_retry", "invalid api %v", api))
+		}
+		errs = append(errs, validateRetry(fmt.Sprintf("devops.api_retry.%v", api), c.apiRetry(api))...)
+	}
+
+	if c.Devops.TLS != (TLS{}) {
+		if !strings.HasPrefix(c.Devops.ReportHost, "https://") {
+			errs = append(errs, fieldErrorf("devops.report_host", "must be https with tls options"))
+		}
+		if _, err := c.TLSConfig(); err != nil {
+			errs = append(errs, fieldErrorf("devops.tls", "%v", err))
+		}
+	}
+	if c.Devops.Sign.KeyFile != "" {
+		if _, err := c.Signer(); err != nil {
+			errs = append(errs, fieldErrorf("devops.sign", "%v", err))
+		}
+	}
+
+	if c.Basenode.MainRole == types.GatewayNode {
+		if c.Snmp.Monitor {
+			if c.Snmp.User == "" || c.Snmp.Pass == "" {
+				errs = append(errs, fieldErrorf("snmp.user", "user and pass are required for snmp monitor"))
+			}
+			if c.Snmp.Target == "" || c.Snmp.Community == "" {
+				errs = append(errs, fieldErrorf("snmp.target", "target and community are required for snmp monitor"))
+			}
+		}
+		if _, err := units.RAMInBytes(c.Snmp.ConfigInBandwidth); err != nil {
+			errs = append(errs, fieldErrorf("snmp.config_in_bandwidth", "%v", err))
+		}
+		if c.Gateway.PrometheusConfigFile == "" {
+			errs = append(errs, fieldErrorf("gateway.prometheus_config_file", "is required"))
+		}
+	}
+
+	for _, port := range []struct {
+		path string
+		port int
+	}{
+		{"peer.http_port", c.Peer.HttpPort},
+		{"exporter.port", c.Exporter.Port},
+	} {
+		if port.port <= 0 || 65535 < port.port {
+			errs = append(errs, fieldErrorf(port.path, "invalid port %v", port.port))
+		}
+	}
+	if c.Peer.HttpPort == c.Exporter.Port {
+		errs = append(errs, fieldErrorf("exporter.port", "conflicts with peer.http_port"))
+	}
+
+	for _, f := range leaves(reflect.ValueOf(&c.Parser).Elem(), "parser") {
+		if f.value.Kind() == reflect.String && f.value.String() == "" {
+			errs = append(errs, fieldErrorf(f.path, "is required"))
+		}
+	}
+
+	return errs
+}
+
+// TLSConfig returns nil when no tls option is set
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if c.Devops.TLS == (TLS{}) {
+		return nil, nil
+	}
+	return devops.NewTLSConfig(c.Devops.TLS.CAFile, c.Devops.TLS.CertFile, c.Devops.TLS.KeyFile)
+}
+
+// validateRetry requires a finite attempt count, an unlimited one lets a
+// rejected report block the ones queued behind it
+func validateRetry(path string, r Retry) []error {
+	errs := []error{}
+	if r.InitialDelay <= 0 {
+		errs = append(errs, fieldErrorf(path+".initial_delay", "must be positive"))
+	}
+	if r.MaxDelay < r.InitialDelay {
+		errs = append(errs, fieldErrorf(path+".max_delay", "must not be less than initial_delay"))
+	}
+	if r.Multiplier < 1 {
+		errs = append(errs, fieldErrorf(path+".multiplier", "must be at least 1"))
+	}
+	if r.Jitter < 0 || 1 <= r.Jitter {
+		errs = append(errs, fieldErrorf(path+".jitter", "must be in [0, 1)"))
+	}
+	if r.MaxAttempts <= 0 {
+		errs = append(errs, fieldErrorf(path+".max_attempts", "must be positive"))
+	}
+	return errs
+}
+
+func (r Retry) policy() *devops.RetryPolicy {
+	return &devops.RetryPolicy{
+		InitialDelay: r.InitialDelay,
+		MaxDelay:     r.MaxDelay,
+		Multiplier:   r.Multiplier,
+		Jitter:       r.Jitter,
+		MaxAttempts:  r.MaxAttempts,
+	}
+}
+
+// RetryPolicy is the retry of report apis without their own policy
+func (c *Config) RetryPolicy() *devops.RetryPolicy {
+	return c.Devops.Retry.policy()
+}
+
+// apiRetry merges api_retry of the api over retry
+func (c *Config) apiRetry(api string) Retry {
+	merged := c.Devops.Retry
+	r := c.Devops.ApiRetry[api]
+	if r.InitialDelay != 0 {
+		merged.InitialDelay = r.InitialDelay
+	}
+	if r.MaxDelay != 0 {
+		merged.MaxDelay = r.MaxDelay
+	}
+	if r.Multiplier != 0 {
+		merged.Multiplier = r.Multiplier
+	}
+	if r.Jitter != 0 {
+		merged.Jitter = r.Jitter
+	}
+	if r.MaxAttempts != 0 {
+		merged.MaxAttempts = r.MaxAttempts
+	}
+	return merged
+}
+
+// RetryPolicies returns the policies of the apis in api_retry
+func (c *Config) RetryPolicies() map[string]*devops.RetryPolicy {
+	policies := map[string]*devops.RetryPolicy{}
+	for api := range c.Devops.ApiRetry {
+		policies[api] = c.apiRetry(api).policy()
+	}
+	return policies
+}
+
+// Signer returns nil when no sign key is set
+func (c *Config) Signer() (*devops.Signer, error) {
+	if c.Devops.Sign.KeyFile == "" {
+		return nil, nil
+	}
+	return devops.NewSigner(c.Devops.Sign.Alg, c.Devops.Sign.KeyFile)
+}
+
+// Apply sets the process wide values, call it before creating any node
+func (c *Config) Apply() {
+	types.ExporterPort = c.Exporter.Port
+	types.StateDir = c.Peer.StateDir
+	lotusapi.ApiVersion = c.Lotus.ApiVersion
+
+	parser.FullnodeAPIFile = c.Parser.FullnodeAPIFile
+	parser.FullnodeServiceFile = c.Parser.FullnodeServiceFile
+	parser.MinerAPIFile = c.Parser.MinerAPIFile
+	parser.MinerServiceFile = c.Parser.MinerServiceFile
+	parser.WorkerServiceFile = c.Parser.WorkerServiceFile
+	parser.CommonSetupFile = c.Parser.CommonSetupFile
+	parser.HostsFile = c.Parser.HostsFile
+	parser.CephConfigFile = c.Parser.CephConfigFile
+	parser.ChiaMinerLogFile = c.Parser.ChiaMinerLogFile
+	parser.ChiaPlotterLogFile = c.Parser.ChiaPlotterLogFile
+	parser.ReloadInterval = c.Parser.ReloadInterval
+}
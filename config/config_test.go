@@ -0,0 +1,177 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const testConfig = `
+basenode:
+  main_role: fullnode
+  network_type: mainnet
+  username: user
+  password: pass
+  mon_address: 10.0.0.2
+devops:
+  report_host: http://devops.local
+  heartbeat_interval: 1m
+  outbox:
+    max_messages: 50
+  api_retry:
+    /api/v0/device/register:
+      max_attempts: 3
+parser:
+  hosts_file: /tmp/hosts
+`
+
+func writeConfig(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "peer.yaml")
+	err := ioutil.WriteFile(file, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func loadArgs(t *testing.T, args ...string) (*Config, error) {
+	var cfg *Config
+	var err error
+	app := &cli.App{
+		Flags: Flags(),
+		Action: func(cctx *cli.Context) error {
+			cfg, err = Load(cctx)
+			return nil
+		},
+	}
+	runErr := app.Run(append([]string{"fbc-devops-peer"}, args...))
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	return cfg, err
+}
+
+func TestLoadFileAndOverrides(t *testing.T) {
+	file := writeConfig(t, testConfig)
+
+	t.Setenv("FBC_DEVOPS_USERNAME", "env-user")
+	t.Setenv("FBC_DEVOPS_REPORT_HOST", "http://env.local")
+
+	cfg, err := loadArgs(t, "--config", file, "--report-host", "https://flag.local",
+		"--chia-plotter-log-file", "/data/plotter.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Basenode.MainRole != "fullnode" || cfg.Devops.Outbox.MaxMessages != 50 {
+		t.Errorf("file values not loaded: %+v", cfg.Basenode)
+	}
+	if cfg.Devops.HeartbeatInterval != time.Minute {
+		t.Errorf("heartbeat interval %v, expect 1m", cfg.Devops.HeartbeatInterval)
+	}
+	if cfg.Parser.HostsFile != "/tmp/hosts" || cfg.Parser.CephConfigFile != "/etc/ceph/ceph.conf" {
+		t.Errorf("parser paths not merged with defaults: %+v", cfg.Parser)
+	}
+	if cfg.Parser.ChiaPlotterLogFile != "/data/plotter.log" {
+		t.Errorf("chia plotter log file %v, expect flag value", cfg.Parser.ChiaPlotterLogFile)
+	}
+	if cfg.Devops.Outbox.MaxSize != "64MiB" {
+		t.Errorf("default outbox size lost: %v", cfg.Devops.Outbox.MaxSize)
+	}
+	if cfg.Basenode.Username != "env-user" {
+		t.Errorf("environment should override file, got %v", cfg.Basenode.Username)
+	}
+	if cfg.Devops.ReportHost != "https://flag.local" {
+		t.Errorf("flag should override environment, got %v", cfg.Devops.ReportHost)
+	}
+
+	policy := cfg.RetryPolicies()["/api/v0/device/register"]
+	if policy == nil || policy.MaxAttempts != 3 || policy.InitialDelay != cfg.Devops.Retry.InitialDelay {
+		t.Errorf("api retry not merged with retry: %+v", policy)
+	}
+
+	if errs := cfg.Validate(); len(errs) != 0 {
+		t.Errorf("config should be valid: %v", errs)
+	}
+}
+
+func TestLoadFileUnknownField(t *testing.T) {
+	_, err := LoadFile(writeConfig(t, "basenode:\n  main_rol: fullnode\n"))
+	if err == nil || !strings.Contains(err.Error(), "main_rol") {
+		t.Errorf("unknown field should be reported, got %v", err)
+	}
+}
+
+func TestValidatePaths(t *testing.T) {
+	cfg := Default()
+	cfg.Basenode.MainRole = "gateway"
+	cfg.Basenode.NetworkType = "mainnet"
+	cfg.Basenode.Username = "user"
+	cfg.Basenode.Password = "pass"
+	cfg.Basenode.MonAddress = "10.0.0.2"
+	cfg.Devops.Outbox.DropPolicy = "drop-all"
+	cfg.Devops.TLS.CAFile = "/nonexistent/ca.pem"
+	cfg.Snmp.Monitor = true
+	cfg.Exporter.Port = cfg.Peer.HttpPort
+	cfg.Parser.MinerAPIFile = ""
+	cfg.Lotus.ApiVersion = "v2"
+	cfg.Devops.Retry.MaxAttempts = 0
+	cfg.Peer.StateDir = "state"
+
+	paths := map[string]bool{}
+	for _, err := range cfg.Validate() {
+		paths[err.(*FieldError).Path] = true
+	}
+
+	for _, path := range []string{
+		"devops.outbox.drop_policy",
+		"devops.report_host",
+		"devops.tls",
+		"snmp.user",
+		"snmp.target",
+		"exporter.port",
+		"parser.miner_api_file",
+		"lotus.api_version",
+		"devops.retry.max_attempts",
+		"peer.state_dir",
+	} {
+		if !paths[path] {
+			t.Errorf("expect error at %v, got %v", path, paths)
+		}
+	}
+	if paths["basenode.main_role"] {
+		t.Errorf("gateway is a valid role")
+	}
+}
+
+func TestValidateMultiRole(t *testing.T) {
+	cases := map[string]bool{
+		"miner,worker":      true,
+		"fullnode, storage": true,
+		"miner,gateway":     false,
+		"fullminer,worker":  false,
+		"miner,miner":       false,
+		"miner,bogus":       false,
+	}
+
+	for roles, valid := range cases {
+		cfg := Default()
+		cfg.Basenode.MainRole = roles
+		cfg.Basenode.NetworkType = "mainnet"
+		cfg.Basenode.Username = "user"
+		cfg.Basenode.Password = "pass"
+		cfg.Basenode.MonAddress = "10.0.0.2"
+
+		errs := cfg.Validate()
+		if valid && len(errs) != 0 {
+			t.Errorf("%v should be valid: %v", roles, errs)
+		}
+		if !valid && len(errs) == 0 {
+			t.Errorf("%v should be invalid", roles)
+		}
+	}
+}
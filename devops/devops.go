@@ -1,36 +1,127 @@
 package devops
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	log "github.com/EntropyPool/entropy-logger"
+	health "github.com/NpoolDevOps/fbc-devops-peer/health"
 	"github.com/NpoolDevOps/fbc-devops-peer/node"
+	peertypes "github.com/NpoolDevOps/fbc-devops-peer/types"
 	types "github.com/NpoolDevOps/fbc-devops-service/types"
 	httpdaemon "github.com/NpoolRD/http-daemon"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/xerrors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
-type DevopsMsg struct {
-	Api   string
-	Msg   interface{}
-	Retry bool
-}
-
 type DevopsConfig struct {
-	PeerReportAPI string
-	TestMode      bool
+	PeerReportAPI     string
+	PeerVersion       string
+	HeartbeatInterval time.Duration
+	TestMode          bool
+	Outbox            *OutboxConfig
+	RetryPolicy       *RetryPolicy
+	RetryPolicies     map[string]*RetryPolicy
+	Breaker           *BreakerConfig
+	TLSConfig         *tls.Config
+	Signer            *Signer
 }
 
+const defaultHeartbeatInterval = 3 * time.Minute
+
+type reportPoster func(url string, body []byte, headers map[string]string) (int, *httpdaemon.ApiResp, error)
+
 type DevopsClient struct {
-	config *DevopsConfig
-	newMsg chan *DevopsMsg
-	node   node.Node
+	config   *DevopsConfig
+	outbox   *Outbox
+	breaker  *CircuitBreaker
+	attempts map[uint64]int
+	acked    map[string]string
+	node     node.Node
+	post     reportPoster
+	startAt  time.Time
+	peerId   string
+	deviceId uuid.UUID
+	device   *types.DeviceRegisterInput
+	spec     string
+	mutex    sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	OutboxDepth     *prometheus.Desc
+	OutboxOldestAge *prometheus.Desc
+	OutboxDropped   *prometheus.Desc
+	BreakerState    *prometheus.Desc
 }
 
 func NewDevopsClient(config *DevopsConfig) *DevopsClient {
+	if config.TLSConfig != nil {
+		return newDevopsClient(config, httpClientPost(&http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: config.TLSConfig,
+			},
+		}))
+	}
+	return newDevopsClient(config, httpdaemonPost)
+}
+
+func newDevopsClient(config *DevopsConfig, post reportPoster) *DevopsClient {
+	outboxConfig := config.Outbox
+	if outboxConfig == nil {
+		outboxConfig = &OutboxConfig{}
+	}
+	if outboxConfig.Dir == "" {
+		outboxConfig.Dir = filepath.Join(peertypes.PeerStateDir(), "outbox")
+	}
+
+	breakerConfig := config.Breaker
+	if breakerConfig == nil {
+		breakerConfig = &DefaultBreakerConfig
+	}
+
 	cli := &DevopsClient{
-		config: config,
-		newMsg: make(chan *DevopsMsg, 10),
+		config:   config,
+		outbox:   NewOutbox(outboxConfig),
+		breaker:  NewCircuitBreaker(breakerConfig),
+		attempts: map[uint64]int{},
+		acked:    map[string]string{},
+		post:     post,
+		startAt:  time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		OutboxDepth: prometheus.NewDesc(
+			"devops_outbox_depth",
+			"Show pending report messages in outbox",
+			nil, nil,
+		),
+		OutboxOldestAge: prometheus.NewDesc(
+			"devops_outbox_oldest_age_seconds",
+			"Show age of the oldest pending report message",
+			nil, nil,
+		),
+		OutboxDropped: prometheus.NewDesc(
+			"devops_outbox_dropped",
+			"Show report messages dropped for outbox limits",
+			nil, nil,
+		),
+		BreakerState: prometheus.NewDesc(
+			"devops_report_breaker_state",
+			"Show report circuit breaker state, 0 closed, 1 open, 2 half open",
+			nil, nil,
+		),
 	}
 
 	go cli.reporter()
@@ -42,84 +133,340 @@ func (c *DevopsClient) SetNode(node node.Node) {
 	c.node = node
 }
 
-func (c *DevopsClient) onMessage(msg *DevopsMsg) {
-	if c.config.TestMode {
-		log.Infof(log.Fields{}, "runnint in TEST MODE, do not send message")
-		return
-	}
-	b, _ := json.Marshal(msg.Msg)
+func httpdaemonPost(url string, body []byte, headers map[string]string) (int, *httpdaemon.ApiResp, error) {
 	resp, err := httpdaemon.R().
 		SetHeader("Content-Type", "application/json").
-		SetBody(b).
-		Post(fmt.Sprintf("%v%v", c.config.PeerReportAPI, msg.Api))
+		SetHeaders(headers).
+		SetBody(body).
+		Post(url)
 	if err != nil {
-		log.Errorf(log.Fields{}, "fail to report message: %v", err)
-		if msg.Retry {
-			go func() {
-				time.Sleep(10 * time.Second)
-				c.newMsg <- msg
-			}()
-		}
-		return
+		return 0, nil, err
 	}
 	if resp.StatusCode() != 200 {
-		if msg.Retry {
-			go func() {
-				time.Sleep(10 * time.Second)
-				c.newMsg <- msg
-			}()
-		}
-		return
+		return resp.StatusCode(), nil, xerrors.Errorf("NON-200 return: %v", resp.StatusCode())
 	}
 
 	apiResp, err := httpdaemon.ParseResponse(resp)
-	if err != nil {
-		log.Errorf(log.Fields{}, "fail to report my config: %v", err)
-		if msg.Retry {
-			go func() {
-				time.Sleep(2 * time.Minute)
-				c.newMsg <- msg
-			}()
+	if err == nil && apiResp == nil {
+		err = xerrors.Errorf("empty response")
+	}
+	return resp.StatusCode(), apiResp, err
+}
+
+// httpClientPost posts with a dedicated client, used when the report host
+// needs a CA bundle or client certificate
+func httpClientPost(client *http.Client) reportPoster {
+	return func(url string, body []byte, headers map[string]string) (int, *httpdaemon.ApiResp, error) {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
 		}
-		return
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return resp.StatusCode, nil, err
+		}
+		if resp.StatusCode != 200 {
+			return resp.StatusCode, nil, xerrors.Errorf("NON-200 return: %v", resp.StatusCode)
+		}
+
+		apiResp := &httpdaemon.ApiResp{}
+		err = json.Unmarshal(b, apiResp)
+		if err != nil {
+			return resp.StatusCode, nil, xerrors.Errorf("cannot parse response: %v", err)
+		}
+		return resp.StatusCode, apiResp, nil
+	}
+}
+
+func (c *DevopsClient) headers(api string, body []byte) map[string]string {
+	if c.config.Signer == nil {
+		return map[string]string{}
+	}
+	timestamp := time.Now().Unix()
+	return map[string]string{
+		TimestampHeader: fmt.Sprintf("%v", timestamp),
+		SignatureHeader: c.config.Signer.Sign(timestamp, api, body),
+	}
+}
+
+func (c *DevopsClient) retryPolicy(api string) *RetryPolicy {
+	if policy, ok := c.config.RetryPolicies[api]; ok {
+		return policy
+	}
+	if c.config.RetryPolicy != nil {
+		return c.config.RetryPolicy
+	}
+	return &DefaultRetryPolicy
+}
+
+// onMessage sends one outbox message, reachable tells whether the report
+// host is up, only transport errors and 5xx count against the breaker since
+// a 4xx is about the message
+func (c *DevopsClient) onMessage(msg *outboxEntry) (bool, error) {
+	code, apiResp, err := c.post(fmt.Sprintf("%v%v", c.config.PeerReportAPI, msg.Api), []byte(msg.Body),
+		c.headers(msg.Api, msg.Body))
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to report message: %v", err)
+		return code != 0 && code < 500, err
 	}
 
 	if apiResp.Code != 0 {
 		log.Errorf(log.Fields{}, "fail to report my config: %v", apiResp.Msg)
-		if msg.Retry {
-			go func() {
-				time.Sleep(2 * time.Minute)
-				c.newMsg <- msg
-			}()
-		}
-		return
+		return true, xerrors.Errorf("fail to report: %v", apiResp.Msg)
 	}
 
 	if msg.Api == types.DeviceRegisterAPI {
 		b, _ := json.Marshal(apiResp.Body)
 		id := types.DeviceRegisterOutput{}
 		json.Unmarshal(b, &id)
-		c.node.NotifyPeerId(id.Id)
+		if c.node != nil {
+			c.node.NotifyPeerId(id.Id)
+		}
+
+		c.mutex.Lock()
+		c.peerId = id.Id.String()
+		c.deviceId = id.Id
+		c.mutex.Unlock()
+	}
+
+	return true, nil
+}
+
+func (c *DevopsClient) removeMsg(msg *outboxEntry) {
+	delete(c.attempts, msg.Seq)
+	c.outbox.Remove(msg)
+}
+
+// sendHead sends the head message once, it returns false when the outbox is
+// empty, otherwise how long to hold before the next one
+func (c *DevopsClient) sendHead() (bool, time.Duration) {
+	msg := c.outbox.Head()
+	if msg == nil {
+		return false, 0
+	}
+
+	// Only the head is ever retried, a different head means the old one
+	// was coalesced or dropped while waiting
+	if _, ok := c.attempts[msg.Seq]; !ok && 0 < len(c.attempts) {
+		c.attempts = map[uint64]int{}
+	}
+
+	if c.config.TestMode {
+		log.Infof(log.Fields{}, "runnint in TEST MODE, do not send message")
+		c.removeMsg(msg)
+		return true, 0
+	}
+
+	if wait := c.breaker.Wait(); 0 < wait {
+		return true, wait
+	}
+
+	hash := ""
+	if msg.Key != "" {
+		sum := sha256.Sum256(msg.Body)
+		hash = hex.EncodeToString(sum[:])
+		if c.acked[msg.Key] == hash {
+			c.removeMsg(msg)
+			return true, 0
+		}
+	}
+
+	// The head message blocks the ones behind it to keep the report order
+	reachable, err := c.onMessage(msg)
+	c.breaker.Record(reachable)
+	if err == nil && msg.Key != "" {
+		c.acked[msg.Key] = hash
 	}
+	if err == nil || !msg.Retry {
+		c.removeMsg(msg)
+		return true, 0
+	}
+
+	c.attempts[msg.Seq]++
+	attempts := c.attempts[msg.Seq]
+	policy := c.retryPolicy(msg.Api)
+	if policy.Exhausted(attempts) {
+		log.Errorf(log.Fields{}, "drop %v message after %v attempts: %v", msg.Api, attempts, err)
+		c.removeMsg(msg)
+		return true, 0
+	}
+
+	return true, policy.Delay(attempts)
 }
 
 func (c *DevopsClient) reporter() {
-	ticker := time.NewTicker(3 * time.Minute)
+	defer close(c.done)
+
+	interval := c.config.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
+		var notify <-chan struct{}
+		var wait <-chan time.Time
+
+		more, delay := c.sendHead()
+		if !more {
+			notify = c.outbox.Notify()
+		} else if 0 < delay {
+			wait = time.After(delay)
+		} else {
+			continue
+		}
+
 		select {
-		case msg := <-c.newMsg:
-			c.onMessage(msg)
+		case <-notify:
+		case <-wait:
 		case <-ticker.C:
+			c.heartbeat()
+		case <-c.stop:
+			return
 		}
 	}
 }
 
-func (c *DevopsClient) FeedMsg(api string, msg interface{}, retry bool) {
-	go func() {
-		c.newMsg <- &DevopsMsg{
-			Api:   api,
-			Msg:   msg,
-			Retry: retry,
+// Close stops the reporter and sends what is left in the outbox until ctx is
+// done, the unsent messages stay on disk and are replayed on next start
+func (c *DevopsClient) Close(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return xerrors.Errorf("reporter still running: %v", ctx.Err())
+	}
+
+	for {
+		more, delay := c.sendHead()
+		if !more {
+			return nil
+		}
+		if delay == 0 {
+			continue
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return xerrors.Errorf("%v messages left in outbox: %v", c.outbox.Depth(), ctx.Err())
 		}
-	}()
+	}
+}
+
+func (c *DevopsClient) status() *peertypes.PeerStatus {
+	c.mutex.Lock()
+	status := &peertypes.PeerStatus{
+		Id:         c.peerId,
+		Spec:       c.spec,
+		Version:    c.config.PeerVersion,
+		Uptime:     int64(time.Since(c.startAt).Seconds()),
+		Subsystems: health.Snapshot(),
+	}
+	c.mutex.Unlock()
+
+	if c.node != nil {
+		status.Role = c.node.GetMainRole()
+		status.SubRole = c.node.GetSubRole()
+	}
+
+	return status
+}
+
+// statusReport rides on the device report api, the service has no status
+// api yet and ignores the extra field until it learns it, the device fields
+// repeat the registered values so the report does not clear them
+type statusReport struct {
+	types.DeviceReportInput
+	Status *peertypes.PeerStatus `json:"status"`
+}
+
+// heartbeat sends the peer status directly, a stale status is useless so it
+// never goes through the outbox, neither does it feed the breaker of reports
+func (c *DevopsClient) heartbeat() {
+	if c.config.TestMode {
+		return
+	}
+
+	c.mutex.Lock()
+	deviceId := c.deviceId
+	device := c.device
+	c.mutex.Unlock()
+	if deviceId == uuid.Nil || device == nil {
+		return
+	}
+
+	b, err := json.Marshal(&statusReport{
+		DeviceReportInput: types.DeviceReportInput{
+			Id:          deviceId,
+			NvmeCount:   device.NvmeCount,
+			GpuCount:    device.GpuCount,
+			MemoryCount: device.MemoryCount,
+			MemorySize:  device.MemorySize,
+			HddCount:    device.HddCount,
+			LocalAddr:   device.LocalAddr,
+			PublicAddr:  device.PublicAddr,
+		},
+		Status: c.status(),
+	})
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to marshal status: %v", err)
+		return
+	}
+
+	_, err = c.onMessage(&outboxEntry{
+		Api:  types.DeviceReportAPI,
+		Body: b,
+	})
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to report status: %v", err)
+	}
+}
+
+// coalesceKey identifies messages where only the newest one matters
+func coalesceKey(api string, msg interface{}) string {
+	switch m := msg.(type) {
+	case *types.DeviceRegisterInput:
+		return fmt.Sprintf("%v:%v", api, m.Spec)
+	}
+	return ""
+}
+
+func (c *DevopsClient) FeedMsg(api string, msg interface{}, retry bool) {
+	if input, ok := msg.(*types.DeviceRegisterInput); ok {
+		c.mutex.Lock()
+		c.spec = input.Spec
+		c.device = input
+		c.mutex.Unlock()
+	}
+
+	err := c.outbox.Push(api, coalesceKey(api, msg), msg, retry)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to queue %v message: %v", api, err)
+	}
+}
+
+func (c *DevopsClient) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.OutboxDepth
+	ch <- c.OutboxOldestAge
+	ch <- c.OutboxDropped
+	ch <- c.BreakerState
+}
+
+func (c *DevopsClient) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.OutboxDepth, prometheus.GaugeValue, float64(c.outbox.Depth()))
+	ch <- prometheus.MustNewConstMetric(c.OutboxOldestAge, prometheus.GaugeValue, c.outbox.OldestAge().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.OutboxDropped, prometheus.CounterValue, float64(c.outbox.Dropped()))
+	ch <- prometheus.MustNewConstMetric(c.BreakerState, prometheus.GaugeValue, float64(c.breaker.State()))
 }
@@ -0,0 +1,289 @@
+package devops
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	health "github.com/NpoolDevOps/fbc-devops-peer/health"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"golang.org/x/xerrors"
+)
+
+var testPost = httpClientPost(http.DefaultClient)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %v", what)
+}
+
+func TestDevopsClientBreaker(t *testing.T) {
+	var healthy int32
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"code":0,"msg":"","body":null}`))
+	}))
+	defer server.Close()
+
+	client := newDevopsClient(&DevopsConfig{
+		PeerReportAPI: server.URL,
+		Outbox:        &OutboxConfig{Dir: t.TempDir()},
+		RetryPolicies: map[string]*RetryPolicy{
+			"/api/test": {InitialDelay: 5 * time.Millisecond, MaxDelay: 20 * time.Millisecond, Multiplier: 2},
+		},
+		Breaker: &BreakerConfig{FailureThreshold: 3, OpenDuration: 300 * time.Millisecond},
+	}, testPost)
+
+	client.FeedMsg("/api/test", "hello", true)
+
+	waitFor(t, "breaker open", func() bool { return client.breaker.State() == BreakerOpen })
+	opened := atomic.LoadInt32(&hits)
+	if opened != 3 {
+		t.Errorf("breaker opened after %v attempts, expect 3", opened)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&hits) != opened {
+		t.Errorf("open breaker should hold reporting")
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	waitFor(t, "outbox drained", func() bool { return client.outbox.Depth() == 0 })
+	if client.breaker.State() != BreakerClosed {
+		t.Errorf("breaker state %v after recovery, expect closed", client.breaker.State())
+	}
+}
+
+func TestDevopsClientMaxAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":-1,"msg":"rejected","body":null}`))
+	}))
+	defer server.Close()
+
+	client := newDevopsClient(&DevopsConfig{
+		PeerReportAPI: server.URL,
+		Outbox:        &OutboxConfig{Dir: t.TempDir()},
+		RetryPolicies: map[string]*RetryPolicy{
+			"/api/test": {InitialDelay: time.Millisecond, MaxAttempts: 3},
+		},
+	}, testPost)
+
+	client.FeedMsg("/api/test", "hello", true)
+	waitFor(t, "message dropped", func() bool { return client.outbox.Depth() == 0 })
+	if client.breaker.State() != BreakerClosed {
+		t.Errorf("api errors should not open the breaker")
+	}
+}
+
+func TestDevopsClientBreakerIgnores4xx(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := newDevopsClient(&DevopsConfig{
+		PeerReportAPI: server.URL,
+		Outbox:        &OutboxConfig{Dir: t.TempDir()},
+		RetryPolicy:   &RetryPolicy{InitialDelay: time.Millisecond, MaxAttempts: 5},
+		Breaker:       &BreakerConfig{FailureThreshold: 2, OpenDuration: time.Minute},
+	}, testPost)
+
+	client.FeedMsg("/api/test", "hello", true)
+	waitFor(t, "message dropped", func() bool { return client.outbox.Depth() == 0 })
+	if atomic.LoadInt32(&hits) != 5 {
+		t.Errorf("message sent %v times, expect 5", atomic.LoadInt32(&hits))
+	}
+	if client.breaker.State() != BreakerClosed {
+		t.Errorf("4xx should not open the breaker")
+	}
+}
+
+func TestDefaultRetryPolicyFinite(t *testing.T) {
+	if DefaultRetryPolicy.MaxAttempts <= 0 {
+		t.Errorf("default retry policy should give up")
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := &RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+	for attempt, expect := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if delay := p.Delay(attempt); delay != expect {
+			t.Errorf("attempt %v delay %v, expect %v", attempt, delay, expect)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := p.Delay(1); delay < 500*time.Millisecond || 1500*time.Millisecond < delay {
+			t.Fatalf("jitter delay %v out of range", delay)
+		}
+	}
+}
+
+func TestDevopsClientSkipAcked(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(`{"code":0,"msg":"","body":null}`))
+	}))
+	defer server.Close()
+
+	client := newDevopsClient(&DevopsConfig{
+		PeerReportAPI: server.URL,
+		Outbox:        &OutboxConfig{Dir: t.TempDir()},
+	}, testPost)
+
+	input := &types.DeviceRegisterInput{Spec: "test-spec", Role: "miner"}
+	client.FeedMsg(types.DeviceRegisterAPI, input, true)
+	waitFor(t, "first register", func() bool { return atomic.LoadInt32(&hits) == 1 })
+
+	client.FeedMsg(types.DeviceRegisterAPI, input, true)
+	waitFor(t, "outbox drained", func() bool { return client.outbox.Depth() == 0 })
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Errorf("acknowledged payload should not be sent again")
+	}
+
+	input = &types.DeviceRegisterInput{Spec: "test-spec", Role: "worker"}
+	client.FeedMsg(types.DeviceRegisterAPI, input, true)
+	waitFor(t, "changed register", func() bool { return atomic.LoadInt32(&hits) == 2 })
+}
+
+func TestDevopsClientHeartbeat(t *testing.T) {
+	var mutex sync.Mutex
+	apis := []string{}
+	report := statusReport{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		apis = append(apis, r.URL.Path)
+		if r.URL.Path == types.DeviceReportAPI {
+			json.Unmarshal(b, &report)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"code":0,"msg":"","body":{"id":"6f1a8c1e-0b1d-4a53-9d43-3c2b1a0e9f00"}}`))
+	}))
+	defer server.Close()
+
+	client := newDevopsClient(&DevopsConfig{
+		PeerReportAPI: server.URL,
+		PeerVersion:   "0.1.0",
+		Outbox:        &OutboxConfig{Dir: t.TempDir()},
+		Breaker:       &BreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute},
+	}, testPost)
+
+	health.Update("lotus-api", xerrors.Errorf("connection refused"))
+	health.Update("exporter", nil)
+
+	client.heartbeat()
+	mutex.Lock()
+	if len(apis) != 0 {
+		t.Errorf("status should wait for registration, got %v", apis)
+	}
+	mutex.Unlock()
+
+	client.FeedMsg(types.DeviceRegisterAPI, &types.DeviceRegisterInput{Spec: "test-spec", NvmeCount: 4}, true)
+	waitFor(t, "registered", func() bool { return client.outbox.Depth() == 0 })
+	client.heartbeat()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(apis) != 2 || apis[1] != types.DeviceReportAPI {
+		t.Fatalf("status posted to %v, expect %v", apis, types.DeviceReportAPI)
+	}
+	if report.Id.String() != "6f1a8c1e-0b1d-4a53-9d43-3c2b1a0e9f00" || report.NvmeCount != 4 {
+		t.Errorf("device fields should repeat the registration: %+v", report.DeviceReportInput)
+	}
+	status := report.Status
+	if status == nil || status.Version != "0.1.0" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if len(status.Subsystems) != 2 {
+		t.Fatalf("%v subsystems, expect 2", len(status.Subsystems))
+	}
+	if status.Subsystems[0].Name != "exporter" || !status.Subsystems[0].Healthy {
+		t.Errorf("exporter should be healthy: %+v", status.Subsystems[0])
+	}
+	lotus := status.Subsystems[1]
+	if lotus.Healthy || lotus.LastError != "connection refused" || lotus.LastErrorAt == 0 {
+		t.Errorf("lotus-api should be unhealthy with last error: %+v", lotus)
+	}
+	if client.breaker.State() != BreakerClosed {
+		t.Errorf("failed heartbeat should not open the report breaker")
+	}
+}
+
+func TestDevopsClientCloseFlush(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(`{"code":0,"msg":"","body":null}`))
+	}))
+	defer server.Close()
+
+	client := newDevopsClient(&DevopsConfig{
+		PeerReportAPI: server.URL,
+		Outbox:        &OutboxConfig{Dir: t.TempDir()},
+	}, testPost)
+
+	for i := 0; i < 5; i++ {
+		client.FeedMsg("/api/v0/test", map[string]int{"seq": i}, true)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := client.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.outbox.Depth() != 0 || atomic.LoadInt32(&hits) != 5 {
+		t.Errorf("outbox should be flushed, depth %v hits %v", client.outbox.Depth(), hits)
+	}
+}
+
+func TestDevopsClientCloseTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := newDevopsClient(&DevopsConfig{
+		PeerReportAPI: server.URL,
+		Outbox:        &OutboxConfig{Dir: dir},
+	}, testPost)
+
+	client.FeedMsg("/api/v0/test", map[string]int{"seq": 1}, true)
+	client.FeedMsg("/api/v0/test", map[string]int{"seq": 2}, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := client.Close(ctx)
+	if err == nil {
+		t.Fatalf("close should time out with unreachable report host")
+	}
+
+	if depth := NewOutbox(&OutboxConfig{Dir: dir}).Depth(); depth != 2 {
+		t.Errorf("unsent messages should stay on disk, got %v", depth)
+	}
+}
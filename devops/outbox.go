@@ -0,0 +1,276 @@
+package devops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	"golang.org/x/xerrors"
+)
+
+const (
+	DropOldest = "drop-oldest"
+	DropNewest = "drop-newest"
+)
+
+const (
+	defaultOutboxMaxMessages = 1000
+	defaultOutboxMaxBytes    = 64 * 1024 * 1024
+)
+
+type OutboxConfig struct {
+	Dir         string
+	MaxMessages int
+	MaxBytes    int64
+	DropPolicy  string
+}
+
+type outboxEntry struct {
+	Seq      uint64          `json:"seq"`
+	Api      string          `json:"api"`
+	Key      string          `json:"key"`
+	Body     json.RawMessage `json:"body"`
+	Retry    bool            `json:"retry"`
+	CreateAt int64           `json:"create_at"`
+	size     int64
+}
+
+// Outbox keeps the pending reports on disk in feed order, one file per
+// message, so they survive restarts and report host outages
+type Outbox struct {
+	config  OutboxConfig
+	entries []*outboxEntry
+	bytes   int64
+	seq     uint64
+	dropped uint64
+	notify  chan struct{}
+	mutex   sync.Mutex
+}
+
+func NewOutbox(config *OutboxConfig) *Outbox {
+	o := &Outbox{
+		config:  *config,
+		entries: []*outboxEntry{},
+		notify:  make(chan struct{}, 1),
+	}
+
+	if o.config.MaxMessages <= 0 {
+		o.config.MaxMessages = defaultOutboxMaxMessages
+	}
+	if o.config.MaxBytes <= 0 {
+		o.config.MaxBytes = defaultOutboxMaxBytes
+	}
+	if o.config.DropPolicy == "" {
+		o.config.DropPolicy = DropOldest
+	}
+
+	err := os.MkdirAll(o.config.Dir, 0755)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot create outbox dir %v: %v", o.config.Dir, err)
+	}
+
+	o.load()
+
+	return o
+}
+
+func (o *Outbox) entryFile(seq uint64) string {
+	return filepath.Join(o.config.Dir, fmt.Sprintf("%020d.json", seq))
+}
+
+func (o *Outbox) load() {
+	files, err := ioutil.ReadDir(o.config.Dir)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot read outbox dir %v: %v", o.config.Dir, err)
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(o.config.Dir, file.Name()))
+		if err != nil {
+			log.Errorf(log.Fields{}, "cannot read outbox message %v: %v", file.Name(), err)
+			continue
+		}
+
+		entry := &outboxEntry{}
+		err = json.Unmarshal(b, entry)
+		if err != nil {
+			log.Errorf(log.Fields{}, "drop corrupted outbox message %v: %v", file.Name(), err)
+			os.Remove(filepath.Join(o.config.Dir, file.Name()))
+			continue
+		}
+		entry.size = int64(len(b))
+
+		o.entries = append(o.entries, entry)
+		o.bytes += entry.size
+		if o.seq < entry.Seq {
+			o.seq = entry.Seq
+		}
+	}
+
+	sort.Slice(o.entries, func(i, j int) bool {
+		return o.entries[i].Seq < o.entries[j].Seq
+	})
+
+	if 0 < len(o.entries) {
+		log.Infof(log.Fields{}, "replay %v outbox messages", len(o.entries))
+		o.signal()
+	}
+}
+
+func (o *Outbox) signal() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// removeLocked drops the entry at index i, caller holds the mutex
+func (o *Outbox) removeLocked(i int) {
+	entry := o.entries[i]
+	os.Remove(o.entryFile(entry.Seq))
+	o.bytes -= entry.size
+	o.entries = append(o.entries[:i], o.entries[i+1:]...)
+}
+
+// Push queues the message, a non empty key replaces the queued messages with
+// the same key so only the newest snapshot is sent
+func (o *Outbox) Push(api string, key string, msg interface{}, retry bool) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	entry := &outboxEntry{
+		Seq:      o.seq + 1,
+		Api:      api,
+		Key:      key,
+		Body:     body,
+		Retry:    retry,
+		CreateAt: time.Now().Unix(),
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	entry.size = int64(len(b))
+
+	if o.config.MaxBytes < entry.size {
+		o.dropped++
+		return xerrors.Errorf("message of %v bytes exceeds outbox size %v", entry.size, o.config.MaxBytes)
+	}
+
+	// Messages with the same key are replaced, so their room counts as free,
+	// but they are only removed once the new message is accepted
+	superseded := func(e *outboxEntry) bool {
+		return key != "" && e.Key == key
+	}
+	count, bytes := len(o.entries), o.bytes
+	for _, e := range o.entries {
+		if superseded(e) {
+			count--
+			bytes -= e.size
+		}
+	}
+
+	for o.config.MaxMessages <= count || o.config.MaxBytes < bytes+entry.size {
+		if o.config.DropPolicy == DropNewest {
+			o.dropped++
+			return xerrors.Errorf("outbox full, drop %v message", api)
+		}
+		oldest := o.entries[0]
+		log.Errorf(log.Fields{}, "outbox full, drop oldest %v message", oldest.Api)
+		o.removeLocked(0)
+		if !superseded(oldest) {
+			count--
+			bytes -= oldest.size
+			o.dropped++
+		}
+	}
+
+	tmp := o.entryFile(entry.Seq) + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err == nil {
+		err = os.Rename(tmp, o.entryFile(entry.Seq))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return xerrors.Errorf("cannot persist outbox message: %v", err)
+	}
+
+	for i := len(o.entries) - 1; 0 <= i; i-- {
+		if superseded(o.entries[i]) {
+			o.removeLocked(i)
+		}
+	}
+
+	o.seq = entry.Seq
+	o.entries = append(o.entries, entry)
+	o.bytes += entry.size
+	o.signal()
+
+	return nil
+}
+
+// Head returns the oldest pending message without removing it
+func (o *Outbox) Head() *outboxEntry {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.entries) == 0 {
+		return nil
+	}
+	return o.entries[0]
+}
+
+func (o *Outbox) Remove(entry *outboxEntry) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for i, e := range o.entries {
+		if e.Seq == entry.Seq {
+			o.removeLocked(i)
+			return
+		}
+	}
+}
+
+func (o *Outbox) Notify() <-chan struct{} {
+	return o.notify
+}
+
+func (o *Outbox) Depth() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.entries)
+}
+
+func (o *Outbox) OldestAge() time.Duration {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.entries) == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(o.entries[0].CreateAt, 0))
+}
+
+func (o *Outbox) Dropped() uint64 {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.dropped
+}
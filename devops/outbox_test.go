@@ -0,0 +1,92 @@
+package devops
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutboxReplay(t *testing.T) {
+	dir := t.TempDir()
+	o := NewOutbox(&OutboxConfig{Dir: dir})
+
+	for i := 0; i < 3; i++ {
+		err := o.Push("/api/test", "", map[string]int{"index": i}, true)
+		if err != nil {
+			t.Fatalf("cannot push message: %v", err)
+		}
+	}
+	o.Remove(o.Head())
+
+	o = NewOutbox(&OutboxConfig{Dir: dir})
+	if o.Depth() != 2 {
+		t.Fatalf("outbox depth %v, expect 2", o.Depth())
+	}
+	if body := string(o.Head().Body); body != `{"index":1}` {
+		t.Errorf("head message %v, expect index 1", body)
+	}
+
+	err := o.Push("/api/test", "", map[string]int{"index": 3}, true)
+	if err != nil {
+		t.Fatalf("cannot push message: %v", err)
+	}
+	if seq := o.entries[len(o.entries)-1].Seq; seq != 4 {
+		t.Errorf("sequence %v after replay, expect 4", seq)
+	}
+}
+
+func TestOutboxDropPolicy(t *testing.T) {
+	o := NewOutbox(&OutboxConfig{Dir: t.TempDir(), MaxMessages: 2})
+	for i := 0; i < 3; i++ {
+		o.Push("/api/test", "", i, true)
+	}
+	if o.Depth() != 2 || string(o.Head().Body) != "1" || o.Dropped() != 1 {
+		t.Errorf("drop oldest: depth %v head %v dropped %v", o.Depth(), string(o.Head().Body), o.Dropped())
+	}
+
+	o = NewOutbox(&OutboxConfig{Dir: t.TempDir(), MaxMessages: 2, DropPolicy: DropNewest})
+	for i := 0; i < 3; i++ {
+		o.Push("/api/test", "", i, true)
+	}
+	if o.Depth() != 2 || string(o.Head().Body) != "0" || o.Dropped() != 1 {
+		t.Errorf("drop newest: depth %v head %v dropped %v", o.Depth(), string(o.Head().Body), o.Dropped())
+	}
+}
+
+func TestOutboxCoalesce(t *testing.T) {
+	o := NewOutbox(&OutboxConfig{Dir: t.TempDir()})
+	o.Push("/api/register", "device-a", 1, true)
+	o.Push("/api/other", "", 2, true)
+	o.Push("/api/register", "device-b", 3, true)
+	o.Push("/api/register", "device-a", 4, true)
+
+	bodies := []string{}
+	for _, entry := range o.entries {
+		bodies = append(bodies, string(entry.Body))
+	}
+	if strings.Join(bodies, ",") != "2,3,4" {
+		t.Errorf("coalesced outbox %v, expect 2,3,4", bodies)
+	}
+}
+
+func TestOutboxCoalesceKeepsOldOnReject(t *testing.T) {
+	o := NewOutbox(&OutboxConfig{Dir: t.TempDir(), MaxBytes: 256, MaxMessages: 2, DropPolicy: DropNewest})
+	o.Push("/api/register", "device-a", 1, true)
+
+	err := o.Push("/api/register", "device-a", strings.Repeat("x", 256), true)
+	if err == nil {
+		t.Fatalf("oversized message should be rejected")
+	}
+	if o.Depth() != 1 || string(o.Head().Body) != "1" {
+		t.Errorf("superseded message removed by a rejected one: depth %v", o.Depth())
+	}
+
+	// a full outbox still takes the replacement of a queued message
+	o.Push("/api/other", "", 2, true)
+	err = o.Push("/api/register", "device-a", 3, true)
+	if err != nil {
+		t.Fatalf("replacement should fit in a full outbox: %v", err)
+	}
+	if o.Depth() != 2 || string(o.Head().Body) != "2" {
+		t.Errorf("unexpected outbox after replacement: depth %v head %v", o.Depth(), string(o.Head().Body))
+	}
+}
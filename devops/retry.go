@@ -0,0 +1,134 @@
+package devops
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       float64
+	MaxAttempts  int
+}
+
+// DefaultRetryPolicy gives up after about half an hour, the outbox sends in
+// order so a message the service keeps rejecting must not block forever
+var DefaultRetryPolicy = RetryPolicy{
+	InitialDelay: 10 * time.Second,
+	MaxDelay:     5 * time.Minute,
+	Multiplier:   2,
+	Jitter:       0.2,
+	MaxAttempts:  10,
+}
+
+// Delay returns the wait before the next attempt after attempt failures, the
+// jitter spreads peers so they do not hit a recovering service together
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if 0 < p.MaxDelay && float64(p.MaxDelay) < delay {
+		delay = float64(p.MaxDelay)
+	}
+	if 0 < p.Jitter {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// Exhausted reports whether the message should be dropped after attempt
+// failures, zero MaxAttempts retries forever
+func (p *RetryPolicy) Exhausted(attempt int) bool {
+	return 0 < p.MaxAttempts && p.MaxAttempts <= attempt
+}
+
+const (
+	BreakerClosed   = 0
+	BreakerOpen     = 1
+	BreakerHalfOpen = 2
+)
+
+type BreakerConfig struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	FailureThreshold: 5,
+	OpenDuration:     time.Minute,
+}
+
+// CircuitBreaker pauses reporting after the report host fails repeatedly,
+// after OpenDuration one message is let through to probe it
+type CircuitBreaker struct {
+	config   BreakerConfig
+	state    int
+	failures int
+	openedAt time.Time
+	mutex    sync.Mutex
+}
+
+func NewCircuitBreaker(config *BreakerConfig) *CircuitBreaker {
+	b := &CircuitBreaker{
+		config: *config,
+	}
+	if b.config.FailureThreshold <= 0 {
+		b.config.FailureThreshold = DefaultBreakerConfig.FailureThreshold
+	}
+	if b.config.OpenDuration <= 0 {
+		b.config.OpenDuration = DefaultBreakerConfig.OpenDuration
+	}
+	return b
+}
+
+// Wait returns how long to hold the next send, zero when it may go now
+func (b *CircuitBreaker) Wait() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state != BreakerOpen {
+		return 0
+	}
+
+	elapsed := time.Since(b.openedAt)
+	if elapsed < b.config.OpenDuration {
+		return b.config.OpenDuration - elapsed
+	}
+
+	b.state = BreakerHalfOpen
+	return 0
+}
+
+func (b *CircuitBreaker) Record(reachable bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if reachable {
+		b.failures = 0
+		b.state = BreakerClosed
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.config.FailureThreshold <= b.failures {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *CircuitBreaker) State() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
@@ -0,0 +1,126 @@
+package devops
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	SignHmacSha256 = "hmac-sha256"
+	SignEd25519    = "ed25519"
+)
+
+const (
+	SignatureHeader = "X-Fbc-Signature"
+	TimestampHeader = "X-Fbc-Timestamp"
+)
+
+// NewTLSConfig loads the CA bundle used to verify the report host and the
+// optional client certificate presented to it
+func NewTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, xerrors.Errorf("cannot read ca bundle %v: %v", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, xerrors.Errorf("no certificate found in ca bundle %v", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, xerrors.Errorf("client certificate and key must be provided together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, xerrors.Errorf("cannot load client certificate %v: %v", certFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Signer signs each report body with the per-device key so the service can
+// reject forged reports
+type Signer struct {
+	alg     string
+	hmacKey []byte
+	edKey   ed25519.PrivateKey
+}
+
+// NewSigner reads a hex encoded key from keyFile, the hmac secret as is or
+// the ed25519 seed or private key
+func NewSigner(alg string, keyFile string) (*Signer, error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot read sign key %v: %v", keyFile, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, xerrors.Errorf("invalid sign key %v: %v", keyFile, err)
+	}
+
+	s := &Signer{alg: alg}
+
+	switch alg {
+	case SignHmacSha256:
+		if len(key) < 16 {
+			return nil, xerrors.Errorf("hmac key of %v bytes is too short", len(key))
+		}
+		s.hmacKey = key
+	case SignEd25519:
+		switch len(key) {
+		case ed25519.SeedSize:
+			s.edKey = ed25519.NewKeyFromSeed(key)
+		case ed25519.PrivateKeySize:
+			s.edKey = ed25519.PrivateKey(key)
+		default:
+			return nil, xerrors.Errorf("invalid ed25519 key size %v", len(key))
+		}
+	default:
+		return nil, xerrors.Errorf("invalid sign algorithm %v", alg)
+	}
+
+	return s, nil
+}
+
+// SignPayload returns the bytes to sign: the timestamp, the api path and the
+// body joined by newline, so a signed body cannot be replayed to another api
+func SignPayload(timestamp int64, api string, body []byte) []byte {
+	return append([]byte(fmt.Sprintf("%v\n%v\n", timestamp, api)), body...)
+}
+
+// Sign returns the signature header value like "ed25519 <base64>"
+func (s *Signer) Sign(timestamp int64, api string, body []byte) string {
+	payload := SignPayload(timestamp, api, body)
+
+	var sig []byte
+	switch s.alg {
+	case SignHmacSha256:
+		mac := hmac.New(sha256.New, s.hmacKey)
+		mac.Write(payload)
+		sig = mac.Sum(nil)
+	case SignEd25519:
+		sig = ed25519.Sign(s.edKey, payload)
+	}
+
+	return fmt.Sprintf("%v %v", s.alg, base64.StdEncoding.EncodeToString(sig))
+}
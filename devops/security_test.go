@@ -0,0 +1,150 @@
+package devops
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func writeKey(t *testing.T, key []byte) string {
+	file := filepath.Join(t.TempDir(), "sign.key")
+	err := ioutil.WriteFile(file, []byte(hex.EncodeToString(key)+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func decodeSignature(t *testing.T, header string, alg string) []byte {
+	s := strings.SplitN(header, " ", 2)
+	if len(s) != 2 || s[0] != alg {
+		t.Fatalf("invalid signature header %v", header)
+	}
+	sig, err := base64.StdEncoding.DecodeString(s[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestSignerHmac(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	signer, err := NewSigner(SignHmacSha256, writeKey(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"spec":"test-spec"}`)
+	sig := decodeSignature(t, signer.Sign(1600000000, "/api/v0/device/register", body), SignHmacSha256)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(SignPayload(1600000000, "/api/v0/device/register", body))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		t.Errorf("hmac signature mismatch")
+	}
+
+	_, err = NewSigner(SignHmacSha256, writeKey(t, []byte("short")))
+	if err == nil {
+		t.Errorf("short hmac key should be rejected")
+	}
+}
+
+func TestSignerEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range [][]byte{priv.Seed(), priv} {
+		signer, err := NewSigner(SignEd25519, writeKey(t, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := []byte(`{"spec":"test-spec"}`)
+		sig := decodeSignature(t, signer.Sign(1600000000, "/api/v0/device/register", body), SignEd25519)
+		if !ed25519.Verify(pub, SignPayload(1600000000, "/api/v0/device/register", body), sig) {
+			t.Errorf("ed25519 signature of %v bytes key does not verify", len(key))
+		}
+		if ed25519.Verify(pub, SignPayload(1600000000, "/api/v0/device/status", body), sig) {
+			t.Errorf("signature should not verify for another api")
+		}
+	}
+
+	_, err = NewSigner("rsa", writeKey(t, priv.Seed()))
+	if err == nil {
+		t.Errorf("unknown algorithm should be rejected")
+	}
+}
+
+func TestDevopsClientTLSSigned(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	verified := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		sig := decodeSignature(t, r.Header.Get(SignatureHeader), SignEd25519)
+
+		mutex.Lock()
+		verified = ed25519.Verify(pub, SignPayload(timestamp, r.URL.Path, body), sig)
+		mutex.Unlock()
+
+		w.Write([]byte(`{"code":0,"msg":"","body":null}`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := NewTLSConfig(caFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(SignEd25519, writeKey(t, priv.Seed()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewDevopsClient(&DevopsConfig{
+		PeerReportAPI: server.URL,
+		Outbox:        &OutboxConfig{Dir: t.TempDir()},
+		TLSConfig:     tlsConfig,
+		Signer:        signer,
+	})
+
+	client.FeedMsg("/api/v0/test", map[string]string{"hello": "world"}, true)
+	waitFor(t, "outbox drained", func() bool { return client.outbox.Depth() == 0 })
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !verified {
+		t.Errorf("report signature should verify on the service")
+	}
+
+	_, err = NewTLSConfig(caFile, "client.pem", "")
+	if err == nil {
+		t.Errorf("client certificate without key should be rejected")
+	}
+}
@@ -1,29 +1,67 @@
 package exporter
 
 import (
+	"context"
 	"fmt"
 	log "github.com/EntropyPool/entropy-logger"
 	collector "github.com/NpoolDevOps/fbc-devops-peer/collector"
+	health "github.com/NpoolDevOps/fbc-devops-peer/health"
+	lifecycle "github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
 	types "github.com/NpoolDevOps/fbc-devops-peer/types"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
 	"net/http"
 	"time"
 )
 
+const shutdownTimeout = 5 * time.Second
+
 type Exporter struct {
 }
 
-func NewExporter(collector collector.Collector) *Exporter {
+// NewExporter serves the collector until ctx is done, then the server is
+// shut down gracefully
+func NewExporter(ctx context.Context, collector collector.Collector) *Exporter {
 	prometheus.MustRegister(collector)
 	http.Handle("/metrics", promhttp.Handler())
-	go func() {
+	lifecycle.Go(func() {
 		for {
 			listen := fmt.Sprintf(":%v", types.ExporterPort)
 			log.Infof(log.Fields{}, "Run exporter at %v", listen)
-			http.ListenAndServe(listen, nil)
-			time.Sleep(1 * time.Minute)
+			l, err := net.Listen("tcp", listen)
+			if err == nil {
+				health.Update("exporter", nil)
+				err = serve(ctx, l)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf(log.Fields{}, "exporter stopped: %v", err)
+			health.Update("exporter", err)
+			select {
+			case <-time.After(1 * time.Minute):
+			case <-ctx.Done():
+				return
+			}
 		}
-	}()
+	})
 	return &Exporter{}
 }
+
+func serve(ctx context.Context, l net.Listener) error {
+	server := &http.Server{}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(l)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
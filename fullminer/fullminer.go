@@ -6,6 +6,7 @@ import (
 	"github.com/NpoolDevOps/fbc-devops-peer/basenode"
 	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
 	exporter "github.com/NpoolDevOps/fbc-devops-peer/exporter"
+	health "github.com/NpoolDevOps/fbc-devops-peer/health"
 	lotusmetrics "github.com/NpoolDevOps/fbc-devops-peer/metrics/lotusmetrics"
 	minermetrics "github.com/NpoolDevOps/fbc-devops-peer/metrics/minermetrics"
 	types "github.com/NpoolDevOps/fbc-devops-peer/types"
@@ -30,17 +31,22 @@ func NewFullMinerNode(config *basenode.BasenodeConfig, devopsClient *devops.Devo
 
 	fullnodeRepoDir := fullminer.GetFullnodeStoragePath()
 	logfile, _ := fullminer.GetLogFileByRole(types.FullNode)
-	fullminer.lotusMetrics = lotusmetrics.NewLotusMetrics(logfile, fullnodeRepoDir, fullminer.Username, fullminer.NetworkType)
+	fullminer.lotusMetrics = lotusmetrics.NewLotusMetrics(fullminer.Context(), logfile, fullnodeRepoDir, fullminer.Username, fullminer.NetworkType)
 	logfile, _ = fullminer.GetLogFileByRole(types.MinerNode)
 	shareStorageRoot, _ := fullminer.GetShareStorageRootByRole(types.FullMinerNode)
 	fullminer.minerMetrics = minermetrics.NewMinerMetrics(minermetrics.MinerMetricsConfig{
+		Context:          fullminer.Context(),
 		ShareStorageRoot: shareStorageRoot,
 		Logfile:          logfile,
 		Username:         fullminer.Username,
 		NetworkType:      fullminer.NetworkType,
+		StorageMounts:    fullminer.GetStorageMounts,
+		StorageBackends:  fullminer.GetMinerStorageBackends,
+		ApiInfo:          fullminer.GetApiInfo,
 	}, paths)
 
 	fullminer.SetAddrNotifier(fullminer.addressNotifier)
+	fullminer.SetTopologyNotifier(fullminer.topologyNotifier)
 	fullnodeHost, err := fullminer.GetFullnodeApiHost(types.FullNode)
 	fullminer.WatchVersions(fullnodeHost, err, fullminer.getVersions)
 	return fullminer
@@ -55,10 +61,19 @@ func (n *FullMinerNode) addressNotifier(string, string) {
 
 }
 
+func (n *FullMinerNode) topologyNotifier() {
+	logfile, _ := n.GetLogFileByRole(types.FullNode)
+	n.lotusMetrics.SetLogfile(logfile)
+	logfile, _ = n.GetLogFileByRole(types.MinerNode)
+	n.minerMetrics.SetLogfile(logfile)
+	n.minerMetrics.SetStoragePaths(n.GetMinerStoragePath())
+}
+
 func (n *FullMinerNode) getVersions(host string) []version.Version {
 	vers := []version.Version{}
 
 	ver, err := lotusapi.ClientVersion(host)
+	health.Update("lotus-api", err)
 	if err == nil {
 		vers = append(vers, ver)
 	}
@@ -79,7 +94,7 @@ func (n *FullMinerNode) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (n *FullMinerNode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(n)
+	return exporter.NewExporter(n.Context(), n)
 }
 
 func (n *FullMinerNode) Banner() {
@@ -6,6 +6,7 @@ import (
 	"github.com/NpoolDevOps/fbc-devops-peer/basenode"
 	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
 	exporter "github.com/NpoolDevOps/fbc-devops-peer/exporter"
+	health "github.com/NpoolDevOps/fbc-devops-peer/health"
 	lotusmetrics "github.com/NpoolDevOps/fbc-devops-peer/metrics/lotusmetrics"
 	"github.com/NpoolDevOps/fbc-devops-peer/types"
 	"github.com/NpoolDevOps/fbc-devops-peer/version"
@@ -19,17 +20,23 @@ type FullNode struct {
 
 func NewFullNode(config *basenode.BasenodeConfig, devopsClient *devops.DevopsClient) *FullNode {
 	log.Infof(log.Fields{}, "create %v node", config.NodeConfig.MainRole)
+	return NewFullNodeWithBase(basenode.NewBasenode(config, devopsClient))
+}
+
+// NewFullNodeWithBase creates the role on a basenode shared with other roles
+func NewFullNodeWithBase(base *basenode.Basenode) *FullNode {
 	fullnode := &FullNode{
-		basenode.NewBasenode(config, devopsClient),
+		base,
 		nil,
 	}
 
 	dir := fullnode.GetFullnodeStoragePath()
-	logfile, _ := fullnode.GetLogFile()
-	fullnode.lotusMetrics = lotusmetrics.NewLotusMetrics(logfile, dir, fullnode.Username, fullnode.NetworkType)
+	logfile, _ := fullnode.GetLogFileByRole(types.FullNode)
+	fullnode.lotusMetrics = lotusmetrics.NewLotusMetrics(fullnode.Context(), logfile, dir, fullnode.Username, fullnode.NetworkType)
 
 	fullnodeHost, err := fullnode.GetFullnodeApiHost(types.FullNode)
 	fullnode.SetAddrNotifier(fullnode.addressNotifier)
+	fullnode.SetTopologyNotifier(fullnode.topologyNotifier)
 	fullnode.WatchVersions(fullnodeHost, err, fullnode.getVersions)
 
 	return fullnode
@@ -40,10 +47,16 @@ func (n *FullNode) addressNotifier(string, string) {
 	n.lotusMetrics.SetHost(fullnodeHost)
 }
 
+func (n *FullNode) topologyNotifier() {
+	logfile, _ := n.GetLogFileByRole(types.FullNode)
+	n.lotusMetrics.SetLogfile(logfile)
+}
+
 func (n *FullNode) getVersions(host string) []version.Version {
 	vers := []version.Version{}
 
 	ver, err := lotusapi.ClientVersion(host)
+	health.Update("lotus-api", err)
 	if err == nil {
 		vers = append(vers, ver)
 	}
@@ -51,6 +64,10 @@ func (n *FullNode) getVersions(host string) []version.Version {
 	return vers
 }
 
+func (n *FullNode) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{n.lotusMetrics}
+}
+
 func (n *FullNode) Describe(ch chan<- *prometheus.Desc) {
 	n.lotusMetrics.Describe(ch)
 	n.BaseMetrics.Describe(ch)
@@ -62,7 +79,7 @@ func (n *FullNode) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (n *FullNode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(n)
+	return exporter.NewExporter(n.Context(), n)
 }
 
 func (n *FullNode) Banner() {
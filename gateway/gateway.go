@@ -16,6 +16,7 @@ import (
 	"github.com/NpoolDevOps/fbc-devops-peer/basenode"
 	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
 	exporter "github.com/NpoolDevOps/fbc-devops-peer/exporter"
+	lifecycle "github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
 	snmpmetrics "github.com/NpoolDevOps/fbc-devops-peer/metrics/snmpmetrics"
 	snmp "github.com/NpoolDevOps/fbc-devops-peer/snmp"
 	mytypes "github.com/NpoolDevOps/fbc-devops-peer/types"
@@ -35,9 +36,16 @@ type hostMonitor struct {
 	newCreated bool
 }
 
+const (
+	defaultPrometheusConfigFile = "/usr/local/prometheus/prometheus.yml"
+	defaultTopologyInterval     = 2 * time.Minute
+)
+
 type GatewayConfig struct {
-	BasenodeConfig *basenode.BasenodeConfig
-	SnmpConfig     *snmp.SnmpConfig
+	BasenodeConfig       *basenode.BasenodeConfig
+	SnmpConfig           *snmp.SnmpConfig
+	PrometheusConfigFile string
+	TopologyInterval     time.Duration
 }
 
 type GatewayNode struct {
@@ -48,22 +56,34 @@ type GatewayNode struct {
 	onlineChecker   chan struct{}
 	configGenerator chan struct{}
 	hosts           map[string]hostMonitor
+	prometheusFile  string
 }
 
 func NewGatewayNode(config *GatewayConfig, devopsClient *devops.DevopsClient) *GatewayNode {
 	log.Infof(log.Fields{}, "create %v node", config.BasenodeConfig.NodeConfig.MainRole)
+
+	prometheusFile := config.PrometheusConfigFile
+	if prometheusFile == "" {
+		prometheusFile = defaultPrometheusConfigFile
+	}
+	interval := config.TopologyInterval
+	if interval <= 0 {
+		interval = defaultTopologyInterval
+	}
+
 	gateway := &GatewayNode{
 		basenode.NewBasenode(config.BasenodeConfig, devopsClient),
 		snmpmetrics.NewSnmpMetrics(config.SnmpConfig),
-		time.NewTicker(2 * time.Minute),
+		time.NewTicker(interval),
 		make(chan struct{}, 10),
 		make(chan struct{}, 10),
 		make(chan struct{}, 10),
 		make(map[string]hostMonitor, 0),
+		prometheusFile,
 	}
 
 	gateway.updateTopology()
-	go gateway.handler()
+	lifecycle.Go(gateway.handler)
 
 	return gateway
 }
@@ -80,6 +100,9 @@ func (g *GatewayNode) handler() {
 		// case <-g.configGenerator:
 				g.generateConfig()
 			}
+		case <-g.Context().Done():
+			g.topologyTicker.Stop()
+			return
 		}
 	}
 }
@@ -327,7 +350,7 @@ func (g *GatewayNode) generateConfig() {
 		return
 	}
 
-	err = exec.Command("mv", monitorCfgFile, "/usr/local/prometheus/prometheus.yml").Run()
+	err = exec.Command("mv", monitorCfgFile, g.prometheusFile).Run()
 	if err != nil {
 		log.Errorf(log.Fields{}, "fail to move monitor configuration")
 		return
@@ -360,7 +383,7 @@ func (n *GatewayNode) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (n *GatewayNode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(n)
+	return exporter.NewExporter(n.Context(), n)
 }
 
 func (g *GatewayNode) Banner() {
@@ -0,0 +1,57 @@
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+)
+
+// Subsystems of the peer report their state here, the devops client sends
+// the snapshot upstream with the periodic status report
+var (
+	subsystems = map[string]*types.SubsystemHealth{}
+	mutex      sync.Mutex
+)
+
+func Update(name string, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	h, ok := subsystems[name]
+	if !ok {
+		h = &types.SubsystemHealth{Name: name}
+		subsystems[name] = h
+	}
+
+	now := time.Now().Unix()
+	h.Healthy = err == nil
+	h.UpdateAt = now
+	if err != nil {
+		h.LastError = err.Error()
+		h.LastErrorAt = now
+	}
+}
+
+// Remove drops a subsystem which is not run any more
+func Remove(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(subsystems, name)
+}
+
+func Snapshot() []types.SubsystemHealth {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	healths := []types.SubsystemHealth{}
+	for _, h := range subsystems {
+		healths = append(healths, *h)
+	}
+	sort.Slice(healths, func(i, j int) bool {
+		return healths[i].Name < healths[j].Name
+	})
+
+	return healths
+}
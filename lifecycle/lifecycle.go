@@ -0,0 +1,37 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// Subsystems start their loops here, shutdown cancels their context and
+// waits for them to exit, so offsets and outbox are flushed before exit
+var wg sync.WaitGroup
+
+// Go runs fn in a goroutine tracked by Wait
+func Go(fn func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until every tracked goroutine exited or ctx is done
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return xerrors.Errorf("subsystems still running: %v", ctx.Err())
+	}
+}
@@ -1,6 +1,7 @@
 package chiaminerlog
 
 import (
+	"context"
 	"sync"
 
 	"github.com/NpoolDevOps/fbc-devops-peer/loganalysis/logbase"
@@ -40,10 +41,10 @@ type ChiaMinerLog struct {
 	mutex sync.Mutex
 }
 
-func NewChiaMinerLog(logfile string) *ChiaMinerLog {
+func NewChiaMinerLog(ctx context.Context, logfile string) *ChiaMinerLog {
 	newline := make(chan logbase.LogLine)
 	cml := &ChiaMinerLog{
-		logbase: logbase.NewLogbase(logfile, newline),
+		logbase: logbase.NewLogbase(ctx, logfile, newline),
 		newline: newline,
 	}
 
@@ -74,7 +75,10 @@ func (cml *ChiaMinerLog) processLine(line logbase.LogLine) {
 
 func (cml *ChiaMinerLog) watch() {
 	for {
-		line := <-cml.newline
+		line, ok := <-cml.newline
+		if !ok {
+			return
+		}
 		cml.processLine(line)
 	}
 }
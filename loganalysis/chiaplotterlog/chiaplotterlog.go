@@ -1,6 +1,7 @@
 package chiaplotterlog
 
 import (
+	"context"
 	"strconv"
 	"strings"
 	"sync"
@@ -39,10 +40,10 @@ type ChiaPlotterLog struct {
 	mutex sync.Mutex
 }
 
-func NewChiaMinerLog(logfile string) *ChiaPlotterLog {
+func NewChiaMinerLog(ctx context.Context, logfile string) *ChiaPlotterLog {
 	newline := make(chan logbase.LogLine)
 	cpl := &ChiaPlotterLog{
-		logbase: logbase.NewLogbase(logfile, newline),
+		logbase: logbase.NewLogbase(ctx, logfile, newline),
 		newline: newline,
 	}
 
@@ -85,7 +86,10 @@ func (cpl *ChiaPlotterLog) processLine(line logbase.LogLine) {
 
 func (cpl *ChiaPlotterLog) watch() {
 	for {
-		line := <-cpl.newline
+		line, ok := <-cpl.newline
+		if !ok {
+			return
+		}
 		cpl.processLine(line)
 	}
 }
@@ -1,10 +1,14 @@
 package logbase
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/EntropyPool/entropy-logger"
+	health "github.com/NpoolDevOps/fbc-devops-peer/health"
+	lifecycle "github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
 	"github.com/hpcloud/tail"
+	"golang.org/x/xerrors"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -12,6 +16,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,36 +33,92 @@ func (ll *LogLine) String() string {
 	return fmt.Sprintf("%v	%v", ll.Timestamp, ll.Msg)
 }
 
+const logTsFlushInterval = 10 * time.Second
+
 type Logbase struct {
+	ctx         context.Context
 	tail        *tail.Tail
 	newline     chan LogLine
+	reopen      chan string
 	lastLogTime uint64
+	lastLogTs   string
+	logTsDirty  bool
 	logfile     string
 	logTsFile   string
 	logTsPath   string
+	mutex       sync.Mutex
 }
 
-func NewLogbase(logfile string, newline chan LogLine) *Logbase {
+// NewLogbase tails logfile until ctx is done, then it persists the timestamp
+// of the last line and closes newline
+func NewLogbase(ctx context.Context, logfile string, newline chan LogLine) *Logbase {
 	lb := &Logbase{
+		ctx:       ctx,
 		newline:   newline,
-		logfile:   logfile,
-		logTsFile: fmt.Sprintf(".%v.timestamp", path.Base(logfile)),
+		reopen:    make(chan string),
 		logTsPath: filepath.Join(os.Getenv("HOME"), ".fbc-devios-peer"),
 	}
-	lb.tail, _ = tail.TailFile(logfile, tail.Config{
+	lb.open(logfile)
+
+	lifecycle.Go(func() { lb.watch(ctx) })
+
+	return lb
+}
+
+func (lb *Logbase) open(logfile string) {
+	lb.mutex.Lock()
+	lb.logfile = logfile
+	lb.mutex.Unlock()
+
+	lb.logTsFile = fmt.Sprintf(".%v.timestamp", path.Base(logfile))
+	lb.lastLogTime = 0
+	lb.lastLogTs = ""
+	lb.logTsDirty = false
+
+	var err error
+	lb.tail, err = tail.TailFile(logfile, tail.Config{
 		ReOpen:    true,
 		Follow:    true,
 		MustExist: false,
 	})
+	health.Update(lb.healthName(), err)
 
 	b, err := ioutil.ReadFile(filepath.Join(lb.logTsPath, lb.logTsFile))
 	if err == nil {
 		lb.lastLogTime = lb.Timestamp(string(b))
 	}
+}
 
-	go lb.watch()
+// SetLogfile tails logfile instead when the node logs to another file
+func (lb *Logbase) SetLogfile(logfile string) {
+	select {
+	case lb.reopen <- logfile:
+	case <-lb.ctx.Done():
+	}
+}
 
-	return lb
+func (lb *Logbase) switchLogfile(logfile string) {
+	if logfile == lb.Logfile() {
+		return
+	}
+	log.Infof(log.Fields{}, "log file changed: %v -> %v", lb.Logfile(), logfile)
+
+	lb.tail.Stop()
+	lb.tail.Cleanup()
+	lb.flushTimestamp()
+	health.Remove(lb.healthName())
+
+	lb.open(logfile)
+}
+
+func (lb *Logbase) Logfile() string {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	return lb.logfile
+}
+
+func (lb *Logbase) healthName() string {
+	return fmt.Sprintf("logtail:%v", lb.Logfile())
 }
 
 func (lb *Logbase) parseTimestamp(ts string) uint64 {
@@ -66,11 +127,58 @@ func (lb *Logbase) parseTimestamp(ts string) uint64 {
 	return t
 }
 
-func (lb *Logbase) watch() {
+func (lb *Logbase) flushTimestamp() {
+	if !lb.logTsDirty {
+		return
+	}
+	os.MkdirAll(lb.logTsPath, 0666)
+	err := ioutil.WriteFile(filepath.Join(lb.logTsPath, lb.logTsFile),
+		[]byte(lb.lastLogTs), 0666)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot write timestamp: %v", err)
+		return
+	}
+	lb.logTsDirty = false
+}
+
+func (lb *Logbase) stop() {
+	lb.tail.Stop()
+	lb.tail.Cleanup()
+	lb.flushTimestamp()
+	close(lb.newline)
+}
+
+func (lb *Logbase) watch(ctx context.Context) {
+	ticker := time.NewTicker(logTsFlushInterval)
+	defer ticker.Stop()
+
 	for {
-		line, ok := <-lb.tail.Lines
+		var line *tail.Line
+		var ok bool
+
+		select {
+		case <-ctx.Done():
+			lb.stop()
+			return
+		case <-ticker.C:
+			lb.flushTimestamp()
+			continue
+		case logfile := <-lb.reopen:
+			lb.switchLogfile(logfile)
+			continue
+		case line, ok = <-lb.tail.Lines:
+		}
+
 		if !ok {
-			time.Sleep(1 * time.Second)
+			health.Update(lb.healthName(), xerrors.Errorf("tail of %v stopped: %v", lb.Logfile(), lb.tail.Err()))
+			select {
+			case <-ctx.Done():
+				lb.stop()
+				return
+			case logfile := <-lb.reopen:
+				lb.switchLogfile(logfile)
+			case <-time.After(1 * time.Second):
+			}
 			continue
 		}
 		logLine := LogLine{}
@@ -82,15 +190,16 @@ func (lb *Logbase) watch() {
 			}
 
 			logLine.Line = line.Text
-			lb.newline <- logLine
+			select {
+			case lb.newline <- logLine:
+			case <-ctx.Done():
+				lb.stop()
+				return
+			}
 
 			if 0 < timestamp {
-				os.MkdirAll(lb.logTsPath, 0666)
-				err = ioutil.WriteFile(filepath.Join(lb.logTsPath, lb.logTsFile),
-					[]byte(logLine.Timestamp), 0666)
-			}
-			if err != nil {
-				log.Errorf(log.Fields{}, "cannot write timestamp: %v", err)
+				lb.lastLogTs = logLine.Timestamp
+				lb.logTsDirty = true
 			}
 		}
 	}
@@ -105,7 +214,7 @@ func (lb *Logbase) LineMatchKey(line string, key string) bool {
 }
 
 func (lb *Logbase) LogFileSize() uint64 {
-	s, err := os.Stat(lb.logfile)
+	s, err := os.Stat(lb.Logfile())
 	if err == nil {
 		return uint64(s.Size())
 	}
@@ -1,13 +1,14 @@
 package logbase
 
 import (
+	"context"
 	"log"
 	"testing"
 )
 
 func TestNewLogbase(t *testing.T) {
 	newline := make(chan LogLine)
-	lb := NewLogbase("/var/log/lotus/miner.log", newline)
+	lb := NewLogbase(context.Background(), "/var/log/lotus/miner.log", newline)
 	if lb == nil {
 		log.Fatal("cannot watch file")
 	}
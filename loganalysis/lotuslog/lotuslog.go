@@ -1,6 +1,7 @@
 package lotuslog
 
 import (
+	"context"
 	"strconv"
 	"strings"
 	"sync"
@@ -53,10 +54,10 @@ var logKeys = []levelLogKeys{
 	},
 }
 
-func NewLotusLog(logfile string) *LotusLog {
+func NewLotusLog(ctx context.Context, logfile string) *LotusLog {
 	newline := make(chan logbase.LogLine)
 	ll := &LotusLog{
-		logbase: logbase.NewLogbase(logfile, newline),
+		logbase: logbase.NewLogbase(ctx, logfile, newline),
 		newline: newline,
 		hasHost: false,
 	}
@@ -118,7 +119,10 @@ func (ll *LotusLog) processLine(line string) {
 
 func (ll *LotusLog) watch() {
 	for {
-		line := <-ll.newline
+		line, ok := <-ll.newline
+		if !ok {
+			return
+		}
 		ll.processLine(line.Line)
 	}
 }
@@ -137,6 +141,10 @@ func (ll *LotusLog) GetTimeouts() uint64 {
 	return timeouts
 }
 
+func (ll *LotusLog) SetLogfile(logfile string) {
+	ll.logbase.SetLogfile(logfile)
+}
+
 func (ll *LotusLog) LogFileSize() uint64 {
 	return ll.logbase.LogFileSize()
 }
@@ -1,6 +1,7 @@
 package minerlog
 
 import (
+	"context"
 	"encoding/json"
 	"math/rand"
 	"strconv"
@@ -229,10 +230,10 @@ func (ml *MinerLog) setMinerFee(line logbase.LogLine) {
 	ml.minerAdjustBaseFee = minerAdjustBaseFee2Float
 }
 
-func NewMinerLog(logfile string) *MinerLog {
+func NewMinerLog(ctx context.Context, logfile string) *MinerLog {
 	newline := make(chan logbase.LogLine)
 	ml := &MinerLog{
-		logbase:                    logbase.NewLogbase(logfile, newline),
+		logbase:                    logbase.NewLogbase(ctx, logfile, newline),
 		newline:                    newline,
 		items:                      map[string][]uint64{},
 		hasFullnodeHost:            false,
@@ -523,7 +524,10 @@ func (ml *MinerLog) processCandidateBlocks() {
 
 func (ml *MinerLog) watch() {
 	for {
-		line := <-ml.newline
+		line, ok := <-ml.newline
+		if !ok {
+			return
+		}
 		ml.processLine(line)
 		ml.processCandidateBlocks()
 	}
@@ -631,6 +635,10 @@ func (ml *MinerLog) GetCheckSectors() map[int]CheckSectors {
 	return sectors
 }
 
+func (ml *MinerLog) SetLogfile(logfile string) {
+	ml.logbase.SetLogfile(logfile)
+}
+
 func (ml *MinerLog) LogFileSize() uint64 {
 	return ml.logbase.LogFileSize()
 }
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	log "github.com/EntropyPool/entropy-logger"
 	basenode "github.com/NpoolDevOps/fbc-devops-peer/basenode"
+	chiaminer "github.com/NpoolDevOps/fbc-devops-peer/chiaminer"
+	chiaplotter "github.com/NpoolDevOps/fbc-devops-peer/chiaplotter"
+	config "github.com/NpoolDevOps/fbc-devops-peer/config"
 	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
 	fullminer "github.com/NpoolDevOps/fbc-devops-peer/fullminer"
 	fullnode "github.com/NpoolDevOps/fbc-devops-peer/fullnode"
 	gateway "github.com/NpoolDevOps/fbc-devops-peer/gateway"
 	miner "github.com/NpoolDevOps/fbc-devops-peer/miner"
+	multinode "github.com/NpoolDevOps/fbc-devops-peer/multinode"
 	node "github.com/NpoolDevOps/fbc-devops-peer/node"
 	"github.com/NpoolDevOps/fbc-devops-peer/peer"
 	snmp "github.com/NpoolDevOps/fbc-devops-peer/snmp"
@@ -15,9 +21,13 @@ import (
 	types "github.com/NpoolDevOps/fbc-devops-peer/types"
 	worker "github.com/NpoolDevOps/fbc-devops-peer/worker"
 	"github.com/docker/go-units"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 func main() {
@@ -26,136 +36,149 @@ func main() {
 		Usage:                "FBC devops peer used to report peer information",
 		Version:              "0.1.0",
 		EnableBashCompletion: true,
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:  "main-role",
-				Usage: "First level role in cluster [fullnode | miner | worker | storage]",
-			},
-			&cli.StringFlag{
-				Name: "report-host",
-			},
-			&cli.StringFlag{
-				Name: "username",
-			},
-			&cli.StringFlag{
-				Name: "password",
-			},
-			&cli.StringFlag{
-				Name: "network-type",
-			},
-			&cli.BoolFlag{
-				Name: "test-mode",
-			},
-			&cli.BoolFlag{
-				Name: "snmp-monitor",
-			},
-			&cli.StringFlag{
-				Name: "snmp-user",
-			},
-			&cli.StringFlag{
-				Name: "snmp-pass",
-			},
-			&cli.StringFlag{
-				Name: "snmp-target",
-			},
-			&cli.StringFlag{
-				Name: "snmp-community",
-			},
-			&cli.StringFlag{
-				Name:  "snmp-config-in-bandwidth",
-				Value: "500MiB",
-			},
-			&cli.StringFlag{
-				Name: "location-label",
-			},
-			&cli.StringFlag{
-				Name: "mon-address",
+		Flags:                config.Flags(),
+		Commands: []*cli.Command{
+			{
+				Name:  "config",
+				Usage: "Configuration file utilities",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "validate",
+						Usage: "Validate configuration file with flags and environment applied",
+						Action: func(cctx *cli.Context) error {
+							cfg, err := config.Load(cctx)
+							if err != nil {
+								return err
+							}
+							errs := cfg.Validate()
+							for _, err := range errs {
+								fmt.Println(err)
+							}
+							if 0 < len(errs) {
+								return xerrors.Errorf("%v invalid fields", len(errs))
+							}
+							fmt.Println("configuration is valid")
+							return nil
+						},
+					},
+				},
 			},
 		},
 		Action: func(cctx *cli.Context) error {
-			if cctx.String("main-role") == "" {
-				return xerrors.Errorf("main-role is must")
+			cfg, err := config.Load(cctx)
+			if err != nil {
+				return err
 			}
-
-			if cctx.String("network-type") == "" {
-				return xerrors.Errorf("network type is must")
-			}
-
-			if cctx.String("username") == "" || cctx.String("password") == "" {
-				return xerrors.Errorf("invalid username or password")
+			if errs := cfg.Validate(); 0 < len(errs) {
+				for _, err := range errs {
+					log.Errorf(log.Fields{}, "invalid config %v", err)
+				}
+				return errs[0]
 			}
+			cfg.Apply()
 
-			if cctx.String("mon-address") == "" {
-				return xerrors.Errorf("invalid monitor address")
-			}
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
 
-			config := &basenode.BasenodeConfig{
+			nodeConfig := &basenode.BasenodeConfig{
 				NodeConfig: &basenode.NodeConfig{
-					MainRole:  cctx.String("main-role"),
-					LocalAddr: cctx.String("mon-address"),
+					MainRole:  cfg.Basenode.MainRole,
+					LocalAddr: cfg.Basenode.MonAddress,
 				},
-				Username:    cctx.String("username"),
-				Password:    cctx.String("password"),
-				NetworkType: cctx.String("network-type"),
-				TestMode:    cctx.Bool("test-mode"),
+				Username:    cfg.Basenode.Username,
+				Password:    cfg.Basenode.Password,
+				NetworkType: cfg.Basenode.NetworkType,
+				TestMode:    cfg.Basenode.TestMode,
+				Context:     ctx,
+			}
+
+			outboxSize, _ := units.RAMInBytes(cfg.Devops.Outbox.MaxSize)
+
+			if !strings.HasPrefix(cfg.Devops.ReportHost, "https://") {
+				log.Errorf(log.Fields{}, "report host %v is not https, credentials are sent in clear", cfg.Devops.ReportHost)
+			}
+			tlsConfig, err := cfg.TLSConfig()
+			if err != nil {
+				return err
+			}
+			signer, err := cfg.Signer()
+			if err != nil {
+				return err
 			}
 
 			client := devops.NewDevopsClient(&devops.DevopsConfig{
-				PeerReportAPI: cctx.String("report-host"),
-				TestMode:      cctx.Bool("test-mode"),
+				PeerReportAPI:     cfg.Devops.ReportHost,
+				PeerVersion:       cctx.App.Version,
+				HeartbeatInterval: cfg.Devops.HeartbeatInterval,
+				TestMode:          cfg.Basenode.TestMode,
+				Outbox: &devops.OutboxConfig{
+					MaxMessages: cfg.Devops.Outbox.MaxMessages,
+					MaxBytes:    outboxSize,
+					DropPolicy:  cfg.Devops.Outbox.DropPolicy,
+				},
+				RetryPolicy:   cfg.RetryPolicy(),
+				RetryPolicies: cfg.RetryPolicies(),
+				TLSConfig:     tlsConfig,
+				Signer:        signer,
 			})
+			prometheus.MustRegister(client)
 
 			var node node.Node
 
-			switch cctx.String("main-role") {
+			switch cfg.Basenode.MainRole {
 			case types.GatewayNode:
-				if cctx.Bool("snmp-monitor") {
-					if cctx.String("snmp-user") == "" || cctx.String("snmp-pass") == "" {
-						return xerrors.Errorf("you need provide user and password for switcher")
-					}
-					if cctx.String("snmp-target") == "" || cctx.String("snmp-community") == "" {
-						return xerrors.Errorf("you need provide target and community for switcher")
-					}
-				}
-
-				configBw, err := units.RAMInBytes(cctx.String("snmp-config-in-bandwidth"))
-				if err != nil {
-					return xerrors.Errorf("cannot parse config in bandwidth %v: %v", cctx.String("snmp-config-in-bandwidth"), err)
-				}
+				configBw, _ := units.RAMInBytes(cfg.Snmp.ConfigInBandwidth)
 
 				node = gateway.NewGatewayNode(&gateway.GatewayConfig{
-					BasenodeConfig: config,
+					BasenodeConfig: nodeConfig,
 					SnmpConfig: &snmp.SnmpConfig{
-						Target:          cctx.String("snmp-target"),
-						Community:       cctx.String("snmp-community"),
-						Username:        cctx.String("snmp-user"),
-						Password:        cctx.String("snmp-pass"),
+						Target:          cfg.Snmp.Target,
+						Community:       cfg.Snmp.Community,
+						Username:        cfg.Snmp.User,
+						Password:        cfg.Snmp.Pass,
 						ConfigBandwidth: configBw,
-						Label:           cctx.String("location-label"),
+						Label:           cfg.Snmp.LocationLabel,
 					},
+					PrometheusConfigFile: cfg.Gateway.PrometheusConfigFile,
+					TopologyInterval:     cfg.Gateway.TopologyInterval,
 				}, client)
 			case types.FullMinerNode:
-				node = fullminer.NewFullMinerNode(config, client)
+				node = fullminer.NewFullMinerNode(nodeConfig, client)
 			case types.FullNode:
-				node = fullnode.NewFullNode(config, client)
+				node = fullnode.NewFullNode(nodeConfig, client)
 			case types.MinerNode:
-				node = miner.NewMinerNode(config, client)
+				node = miner.NewMinerNode(nodeConfig, client)
 			case types.WorkerNode:
-				node = worker.NewWorkerNode(config, client)
+				node = worker.NewWorkerNode(nodeConfig, client)
 			case types.StorageNode:
-				node = storage.NewStorageNode(config, client)
+				node = storage.NewStorageNode(nodeConfig, client)
+			case types.ChiaMinerNode:
+				node = chiaminer.NewChiaMinerNode(nodeConfig, client)
+			case types.ChiaPlotterNode:
+				node = chiaplotter.NewPlotterNode(nodeConfig, client)
 			default:
-				node = basenode.NewBasenode(config, client)
+				if 1 < len(basenode.ParseRoles(cfg.Basenode.MainRole)) {
+					node, err = multinode.NewMultiNode(nodeConfig, client)
+					if err != nil {
+						return err
+					}
+				} else {
+					node = basenode.NewBasenode(nodeConfig, client)
+				}
 			}
 
 			if node == nil {
-				return xerrors.Errorf("cannot init basenode: %v", cctx.String("main-role"))
+				return xerrors.Errorf("cannot init basenode: %v", cfg.Basenode.MainRole)
 			}
 
 			node.Banner()
 			node.CreateExporter()
 
-			rpcPeer := peer.NewPeer(node)
+			rpcPeer := peer.NewPeer(node, &peer.PeerConfig{
+				OperationKeyFile:   cfg.Peer.OperationKeys,
+				HttpPort:           cfg.Peer.HttpPort,
+				ParentSpecInterval: cfg.Peer.ParentSpecInterval,
+			})
 			if rpcPeer == nil {
 				return xerrors.Errorf("cannot init peer")
 			}
@@ -163,8 +186,19 @@ func main() {
 			node.SetPeer(rpcPeer)
 			rpcPeer.Run()
 
-			ch := make(chan int)
-			<-ch
+			<-ctx.Done()
+			stop()
+			log.Infof(log.Fields{}, "shutting down, wait at most %v", cfg.Peer.ShutdownTimeout)
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Peer.ShutdownTimeout)
+			defer cancel()
+
+			if err := node.Shutdown(shutdownCtx); err != nil {
+				log.Errorf(log.Fields{}, "fail to stop node: %v", err)
+			}
+			if err := client.Close(shutdownCtx); err != nil {
+				log.Errorf(log.Fields{}, "fail to flush devops outbox: %v", err)
+			}
 
 			return nil
 		},
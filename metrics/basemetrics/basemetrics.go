@@ -2,6 +2,7 @@ package basemetrics
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"math"
 	"net"
@@ -12,6 +13,7 @@ import (
 
 	log "github.com/EntropyPool/entropy-logger"
 	"github.com/NpoolDevOps/fbc-devops-peer/api/systemapi"
+	"github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
 	"github.com/beevik/ntp"
 	"github.com/go-ping/ping"
 	"github.com/prometheus/client_golang/prometheus"
@@ -38,7 +40,7 @@ type BaseMetrics struct {
 	networkType string
 }
 
-func NewBaseMetrics(username, networkType string) *BaseMetrics {
+func NewBaseMetrics(ctx context.Context, username, networkType string) *BaseMetrics {
 	metrics := &BaseMetrics{
 		username:    username,
 		networkType: networkType,
@@ -84,18 +86,23 @@ func NewBaseMetrics(username, networkType string) *BaseMetrics {
 		),
 	}
 
-	go metrics.updater()
+	lifecycle.Go(func() { metrics.updater(ctx) })
 
 	return metrics
 }
 
-func (m *BaseMetrics) updater() {
+func (m *BaseMetrics) updater(ctx context.Context) {
 	ticker := time.NewTicker(2 * time.Minute)
+	defer ticker.Stop()
 	for {
 		ip, err := getDefaultGateway()
 		if err != nil {
 			log.Errorf(log.Fields{}, "fail to get default gateway: %v", err)
-			<-ticker.C
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
 			continue
 		}
 
@@ -107,7 +114,11 @@ func (m *BaseMetrics) updater() {
 		m.pingBaiduDelayMs = delay
 		m.pingBaiduLost = lost
 
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
@@ -0,0 +1,308 @@
+package cephmetrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	"github.com/NpoolDevOps/fbc-devops-peer/api/cephapi"
+	"github.com/NpoolDevOps/fbc-devops-peer/health"
+	"github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type osdStat struct {
+	id     int64
+	status *cephapi.DaemonStatus
+	df     *cephapi.OsdDfNode
+}
+
+type CephMetrics struct {
+	HealthStatus *prometheus.Desc
+	HealthCheck  *prometheus.Desc
+	PgState      *prometheus.Desc
+	Pgs          *prometheus.Desc
+	OsdCount     *prometheus.Desc
+	OsdUp        *prometheus.Desc
+	OsdIn        *prometheus.Desc
+	ClusterBytes *prometheus.Desc
+	ClusterUsed  *prometheus.Desc
+	PoolStored   *prometheus.Desc
+	PoolUsed     *prometheus.Desc
+	PoolMaxAvail *prometheus.Desc
+	PoolPercent  *prometheus.Desc
+
+	OsdDaemonUp          *prometheus.Desc
+	OsdDaemonPgs         *prometheus.Desc
+	OsdDeviceBytes       *prometheus.Desc
+	OsdDeviceUsed        *prometheus.Desc
+	OsdDeviceUtilization *prometheus.Desc
+
+	mutex  sync.Mutex
+	status *cephapi.Status
+	df     *cephapi.Df
+	osds   []osdStat
+
+	subRole     func() string
+	username    string
+	networkType string
+}
+
+// NewCephMetrics collects cluster wide metrics on mgr nodes and metrics of
+// the local osds on every storage node, sub role may change with topology
+func NewCephMetrics(ctx context.Context, subRole func() string, username, networkType string) *CephMetrics {
+	cm := newCephMetrics(subRole, username, networkType)
+	lifecycle.Go(func() { cm.updater(ctx) })
+	return cm
+}
+
+func newCephMetrics(subRole func() string, username, networkType string) *CephMetrics {
+	return &CephMetrics{
+		subRole:     subRole,
+		username:    username,
+		networkType: networkType,
+		HealthStatus: prometheus.NewDesc(
+			"ceph_health_status",
+			"show ceph health, 0 ok, 1 warn, 2 err",
+			[]string{"networktype", "user"}, nil,
+		),
+		HealthCheck: prometheus.NewDesc(
+			"ceph_health_check",
+			"show failing ceph health checks",
+			[]string{"check", "severity", "networktype", "user"}, nil,
+		),
+		PgState: prometheus.NewDesc(
+			"ceph_pg_state",
+			"show ceph pg count by state",
+			[]string{"state", "networktype", "user"}, nil,
+		),
+		Pgs: prometheus.NewDesc(
+			"ceph_pgs",
+			"show ceph pg count",
+			[]string{"networktype", "user"}, nil,
+		),
+		OsdCount: prometheus.NewDesc(
+			"ceph_osds",
+			"show ceph osd count",
+			[]string{"networktype", "user"}, nil,
+		),
+		OsdUp: prometheus.NewDesc(
+			"ceph_osds_up",
+			"show ceph up osd count",
+			[]string{"networktype", "user"}, nil,
+		),
+		OsdIn: prometheus.NewDesc(
+			"ceph_osds_in",
+			"show ceph in osd count",
+			[]string{"networktype", "user"}, nil,
+		),
+		ClusterBytes: prometheus.NewDesc(
+			"ceph_cluster_bytes",
+			"show ceph cluster capacity",
+			[]string{"networktype", "user"}, nil,
+		),
+		ClusterUsed: prometheus.NewDesc(
+			"ceph_cluster_used_bytes",
+			"show ceph cluster used capacity",
+			[]string{"networktype", "user"}, nil,
+		),
+		PoolStored: prometheus.NewDesc(
+			"ceph_pool_stored_bytes",
+			"show data stored in ceph pool",
+			[]string{"pool", "networktype", "user"}, nil,
+		),
+		PoolUsed: prometheus.NewDesc(
+			"ceph_pool_used_bytes",
+			"show raw capacity used by ceph pool",
+			[]string{"pool", "networktype", "user"}, nil,
+		),
+		PoolMaxAvail: prometheus.NewDesc(
+			"ceph_pool_max_avail_bytes",
+			"show capacity available to ceph pool",
+			[]string{"pool", "networktype", "user"}, nil,
+		),
+		PoolPercent: prometheus.NewDesc(
+			"ceph_pool_percent_used",
+			"show used ratio of ceph pool",
+			[]string{"pool", "networktype", "user"}, nil,
+		),
+		OsdDaemonUp: prometheus.NewDesc(
+			"ceph_osd_daemon_up",
+			"show whether local osd daemon is active",
+			[]string{"osd", "networktype", "user"}, nil,
+		),
+		OsdDaemonPgs: prometheus.NewDesc(
+			"ceph_osd_daemon_pgs",
+			"show pg count of local osd daemon",
+			[]string{"osd", "networktype", "user"}, nil,
+		),
+		OsdDeviceBytes: prometheus.NewDesc(
+			"ceph_osd_device_bytes",
+			"show capacity of local osd backing device",
+			[]string{"osd", "class", "networktype", "user"}, nil,
+		),
+		OsdDeviceUsed: prometheus.NewDesc(
+			"ceph_osd_device_used_bytes",
+			"show used capacity of local osd backing device",
+			[]string{"osd", "class", "networktype", "user"}, nil,
+		),
+		OsdDeviceUtilization: prometheus.NewDesc(
+			"ceph_osd_device_utilization",
+			"show utilization percent of local osd backing device",
+			[]string{"osd", "class", "networktype", "user"}, nil,
+		),
+	}
+}
+
+func (m *CephMetrics) updater(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+	for {
+		var status *cephapi.Status
+		var df *cephapi.Df
+		var err error
+
+		if m.subRole() == types.StorageRoleMgr {
+			status, err = cephapi.GetStatus(ctx)
+			if err == nil {
+				df, err = cephapi.GetDf(ctx)
+			}
+			health.Update("ceph", err)
+			if err != nil {
+				log.Errorf(log.Fields{}, "fail to get ceph cluster status: %v", err)
+			}
+		}
+
+		osds := m.updateOsds(ctx)
+
+		m.mutex.Lock()
+		m.status = status
+		m.df = df
+		m.osds = osds
+		m.mutex.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// updateOsds reads osd status from the admin socket, usage needs cluster
+// access and is left out when the node has no keyring
+func (m *CephMetrics) updateOsds(ctx context.Context) []osdStat {
+	ids, err := cephapi.GetLocalOsds()
+	if err != nil || len(ids) == 0 {
+		return nil
+	}
+
+	nodes := map[int64]*cephapi.OsdDfNode{}
+	df, err := cephapi.GetOsdDf(ctx)
+	if err == nil {
+		for i, node := range df.Nodes {
+			nodes[node.Id] = &df.Nodes[i]
+		}
+	} else {
+		log.Errorf(log.Fields{}, "fail to get osd usage: %v", err)
+	}
+
+	osds := []osdStat{}
+	for _, id := range ids {
+		status, err := cephapi.GetDaemonStatus(ctx, id)
+		health.Update(fmt.Sprintf("ceph-osd.%v", id), err)
+		osds = append(osds, osdStat{
+			id:     id,
+			status: status,
+			df:     nodes[id],
+		})
+	}
+	return osds
+}
+
+func healthValue(status string) float64 {
+	switch status {
+	case cephapi.HealthOk:
+		return 0
+	case cephapi.HealthWarn:
+		return 1
+	}
+	return 2
+}
+
+func (m *CephMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.HealthStatus
+	ch <- m.HealthCheck
+	ch <- m.PgState
+	ch <- m.Pgs
+	ch <- m.OsdCount
+	ch <- m.OsdUp
+	ch <- m.OsdIn
+	ch <- m.ClusterBytes
+	ch <- m.ClusterUsed
+	ch <- m.PoolStored
+	ch <- m.PoolUsed
+	ch <- m.PoolMaxAvail
+	ch <- m.PoolPercent
+	ch <- m.OsdDaemonUp
+	ch <- m.OsdDaemonPgs
+	ch <- m.OsdDeviceBytes
+	ch <- m.OsdDeviceUsed
+	ch <- m.OsdDeviceUtilization
+}
+
+func (m *CephMetrics) Collect(ch chan<- prometheus.Metric) {
+	username := m.username
+	networkType := m.networkType
+
+	m.mutex.Lock()
+	status := m.status
+	df := m.df
+	osds := m.osds
+	m.mutex.Unlock()
+
+	if status != nil {
+		ch <- prometheus.MustNewConstMetric(m.HealthStatus, prometheus.GaugeValue, healthValue(status.Health.Status), networkType, username)
+		for name, check := range status.Health.Checks {
+			ch <- prometheus.MustNewConstMetric(m.HealthCheck, prometheus.GaugeValue, healthValue(check.Severity), name, check.Severity, networkType, username)
+		}
+		for _, state := range status.PgMap.PgsByState {
+			ch <- prometheus.MustNewConstMetric(m.PgState, prometheus.GaugeValue, float64(state.Count), state.StateName, networkType, username)
+		}
+		ch <- prometheus.MustNewConstMetric(m.Pgs, prometheus.GaugeValue, float64(status.PgMap.NumPgs), networkType, username)
+		ch <- prometheus.MustNewConstMetric(m.OsdCount, prometheus.GaugeValue, float64(status.OsdMap.NumOsds), networkType, username)
+		ch <- prometheus.MustNewConstMetric(m.OsdUp, prometheus.GaugeValue, float64(status.OsdMap.NumUpOsds), networkType, username)
+		ch <- prometheus.MustNewConstMetric(m.OsdIn, prometheus.GaugeValue, float64(status.OsdMap.NumInOsds), networkType, username)
+	}
+
+	if df != nil {
+		ch <- prometheus.MustNewConstMetric(m.ClusterBytes, prometheus.GaugeValue, float64(df.Stats.TotalBytes), networkType, username)
+		ch <- prometheus.MustNewConstMetric(m.ClusterUsed, prometheus.GaugeValue, float64(df.Stats.TotalUsedBytes), networkType, username)
+		for _, pool := range df.Pools {
+			ch <- prometheus.MustNewConstMetric(m.PoolStored, prometheus.GaugeValue, float64(pool.Stats.Stored), pool.Name, networkType, username)
+			ch <- prometheus.MustNewConstMetric(m.PoolUsed, prometheus.GaugeValue, float64(pool.Stats.BytesUsed), pool.Name, networkType, username)
+			ch <- prometheus.MustNewConstMetric(m.PoolMaxAvail, prometheus.GaugeValue, float64(pool.Stats.MaxAvail), pool.Name, networkType, username)
+			ch <- prometheus.MustNewConstMetric(m.PoolPercent, prometheus.GaugeValue, pool.Stats.PercentUsed, pool.Name, networkType, username)
+		}
+	}
+
+	for _, osd := range osds {
+		name := fmt.Sprintf("osd.%v", osd.id)
+		up := 0.0
+		if osd.status != nil && osd.status.State == "active" {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(m.OsdDaemonUp, prometheus.GaugeValue, up, name, networkType, username)
+		if osd.status != nil {
+			ch <- prometheus.MustNewConstMetric(m.OsdDaemonPgs, prometheus.GaugeValue, float64(osd.status.NumPgs), name, networkType, username)
+		}
+		if osd.df != nil {
+			ch <- prometheus.MustNewConstMetric(m.OsdDeviceBytes, prometheus.GaugeValue, float64(osd.df.Kb*1024), name, osd.df.DeviceClass, networkType, username)
+			ch <- prometheus.MustNewConstMetric(m.OsdDeviceUsed, prometheus.GaugeValue, float64(osd.df.KbUsed*1024), name, osd.df.DeviceClass, networkType, username)
+			ch <- prometheus.MustNewConstMetric(m.OsdDeviceUtilization, prometheus.GaugeValue, osd.df.Utilization, name, osd.df.DeviceClass, networkType, username)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package cephmetrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NpoolDevOps/fbc-devops-peer/api/cephapi"
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollect(t *testing.T) {
+	m := newCephMetrics(func() string { return types.StorageRoleOsd }, "test", "testnet")
+	if n := testutil.CollectAndCount(m); n != 0 {
+		t.Errorf("metrics should not be exported before update, got %v", n)
+	}
+
+	status, err := cephapi.ParseStatus([]byte(`{
+		"health": {"status": "HEALTH_WARN", "checks": {"OSD_DOWN": {"severity": "HEALTH_WARN"}}},
+		"osdmap": {"num_osds": 3, "num_up_osds": 2, "num_in_osds": 3},
+		"pgmap": {"pgs_by_state": [{"state_name": "active+clean", "count": 64}], "num_pgs": 64}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.mutex.Lock()
+	m.status = status
+	m.df = &cephapi.Df{Pools: []cephapi.Pool{{Name: "sectors"}}}
+	m.osds = []osdStat{
+		{id: 0, status: &cephapi.DaemonStatus{State: "active", NumPgs: 64}, df: &cephapi.OsdDfNode{Id: 0, DeviceClass: "hdd"}},
+		{id: 1},
+	}
+	m.mutex.Unlock()
+
+	// health, check, pg state, pgs, 3 osd counts, 2 cluster, 4 pool,
+	// osd.0 up, pgs and 3 device, osd.1 up
+	if n := testutil.CollectAndCount(m); n != 19 {
+		t.Errorf("should export 19 metrics, got %v", n)
+	}
+	if n := testutil.CollectAndCount(m, "ceph_health_status"); n != 1 {
+		t.Errorf("should export health status, got %v", n)
+	}
+	expected := `
+# HELP ceph_osds_up show ceph up osd count
+# TYPE ceph_osds_up gauge
+ceph_osds_up{networktype="testnet",user="test"} 2
+`
+	if err := testutil.CollectAndCompare(m, strings.NewReader(expected), "ceph_osds_up"); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,6 +1,8 @@
 package chiaminermetrics
 
 import (
+	"context"
+
 	"github.com/NpoolDevOps/fbc-devops-peer/api/systemapi"
 	"github.com/NpoolDevOps/fbc-devops-peer/loganalysis/chiaminerlog"
 	"github.com/prometheus/client_golang/prometheus"
@@ -17,9 +19,9 @@ type ChiaMinerMetrics struct {
 	networkType string
 }
 
-func NewChiaMinerMetrics(logfile, username, networkType string) *ChiaMinerMetrics {
+func NewChiaMinerMetrics(ctx context.Context, logfile, username, networkType string) *ChiaMinerMetrics {
 	cmm := &ChiaMinerMetrics{
-		cml:         chiaminerlog.NewChiaMinerLog(logfile),
+		cml:         chiaminerlog.NewChiaMinerLog(ctx, logfile),
 		username:    username,
 		networkType: networkType,
 		ChiaMinerProcessCount: prometheus.NewDesc(
@@ -1,6 +1,8 @@
 package chiaplottermetrics
 
 import (
+	"context"
+
 	"github.com/NpoolDevOps/fbc-devops-peer/api/systemapi"
 	"github.com/NpoolDevOps/fbc-devops-peer/loganalysis/chiaplotterlog"
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,8 +24,9 @@ type ChiaPlotterMetrics struct {
 	networkType string
 }
 
-func NewChiaPlotterMetrics(logfile, username, networkType string) *ChiaPlotterMetrics {
+func NewChiaPlotterMetrics(ctx context.Context, logfile, username, networkType string) *ChiaPlotterMetrics {
 	cpm := &ChiaPlotterMetrics{
+		cpl:         chiaplotterlog.NewChiaMinerLog(ctx, logfile),
 		username:    username,
 		networkType: networkType,
 		PlotterAvgTime: prometheus.NewDesc(
@@ -1,6 +1,7 @@
 package lotusmetrics
 
 import (
+	"context"
 	"fmt"
 
 	api "github.com/NpoolDevOps/fbc-devops-peer/api/lotusapi"
@@ -35,9 +36,9 @@ type LotusMetrics struct {
 	networkType  string
 }
 
-func NewLotusMetrics(logfile, dir, username, networkType string) *LotusMetrics {
+func NewLotusMetrics(ctx context.Context, logfile, dir, username, networkType string) *LotusMetrics {
 	return &LotusMetrics{
-		ll:           lotuslog.NewLotusLog(logfile),
+		ll:           lotuslog.NewLotusLog(ctx, logfile),
 		lotusRepoDir: dir,
 		username:     username,
 		networkType:  networkType,
@@ -114,6 +115,10 @@ func (m *LotusMetrics) SetHost(host string) {
 	m.hasHost = true
 }
 
+func (m *LotusMetrics) SetLogfile(logfile string) {
+	m.ll.SetLogfile(logfile)
+}
+
 func (m *LotusMetrics) Describe(ch chan<- *prometheus.Desc) {
 	ch <- m.HeightDiff
 	ch <- m.BlockElapsed
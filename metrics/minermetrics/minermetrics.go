@@ -1,6 +1,7 @@
 package minermetrics
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"sync"
@@ -8,17 +9,34 @@ import (
 
 	log "github.com/EntropyPool/entropy-logger"
 	"github.com/NpoolDevOps/fbc-devops-peer/api/lotusapi"
+	"github.com/NpoolDevOps/fbc-devops-peer/api/lotusbase"
 	"github.com/NpoolDevOps/fbc-devops-peer/api/minerapi"
 	"github.com/NpoolDevOps/fbc-devops-peer/api/systemapi"
+	"github.com/NpoolDevOps/fbc-devops-peer/health"
+	"github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
 	"github.com/NpoolDevOps/fbc-devops-peer/loganalysis/minerlog"
+	"github.com/NpoolDevOps/fbc-devops-peer/parser"
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/xerrors"
 )
 
+const storageStatTimeout = 10 * time.Second
+
 type MinerMetricsConfig struct {
+	Context          context.Context
 	ShareStorageRoot string
 	Logfile          string
 	Username         string
 	NetworkType      string
+	StorageMounts    func() []parser.StorageMount
+	StorageBackends  func() map[string]string
+	ApiInfo          func(role string) (string, error)
+}
+
+type storageMountStat struct {
+	mount parser.StorageMount
+	err   error
 }
 
 type MinerMetrics struct {
@@ -86,6 +104,7 @@ type MinerMetrics struct {
 
 	StorageMountpointPermission *prometheus.Desc
 	StorageMountError           *prometheus.Desc
+	StorageBackendError         *prometheus.Desc
 
 	MinerOpenFileNumber          *prometheus.Desc
 	MinerProcessTcpConnectNumber *prometheus.Desc
@@ -118,6 +137,7 @@ type MinerMetrics struct {
 	config           MinerMetricsConfig
 	lotusStoragePath []string
 	storageStat      map[string]error
+	storageMountStat []storageMountStat
 	sectorStat       map[string]uint64
 	username         string
 	networkType      string
@@ -125,7 +145,7 @@ type MinerMetrics struct {
 
 func NewMinerMetrics(cfg MinerMetricsConfig, paths []string) *MinerMetrics {
 	mm := &MinerMetrics{
-		ml:               minerlog.NewMinerLog(cfg.Logfile),
+		ml:               minerlog.NewMinerLog(cfg.Context, cfg.Logfile),
 		lotusStoragePath: paths,
 		config:           cfg,
 		username:         cfg.Username,
@@ -390,6 +410,11 @@ func NewMinerMetrics(cfg MinerMetricsConfig, paths []string) *MinerMetrics {
 			"show storage mount error",
 			[]string{"filedir", "networktype", "user"}, nil,
 		),
+		StorageBackendError: prometheus.NewDesc(
+			"miner_storage_backend_error",
+			"show error of network storage mounted by miner",
+			[]string{"mountpoint", "backend", "networktype", "user"}, nil,
+		),
 		MinerOpenFileNumber: prometheus.NewDesc(
 			"miner_open_file_number",
 			"show how many files miner opened",
@@ -458,7 +483,7 @@ func NewMinerMetrics(cfg MinerMetricsConfig, paths []string) *MinerMetrics {
 		MinerRepoDirUsage: prometheus.NewDesc(
 			"miner_repo_dir_usage",
 			"show miner repo dir usage",
-			[]string{"repodir", "totalcap", "networktype", "user"}, nil,
+			[]string{"repodir", "totalcap", "backend", "networktype", "user"}, nil,
 		),
 		ComputingWindowPost: prometheus.NewDesc(
 			"miner_computing_window_post",
@@ -467,8 +492,9 @@ func NewMinerMetrics(cfg MinerMetricsConfig, paths []string) *MinerMetrics {
 		),
 	}
 
-	go func() {
+	lifecycle.Go(func() {
 		ticker := time.NewTicker(2 * time.Minute)
+		defer ticker.Stop()
 		infoCh := make(chan minerapi.MinerInfo)
 		jobsCh := make(chan minerapi.SealingJobs)
 		workersCh := make(chan minerapi.WorkerInfos)
@@ -481,7 +507,11 @@ func NewMinerMetrics(cfg MinerMetricsConfig, paths []string) *MinerMetrics {
 
 			count += 1
 
-			minerapi.GetMinerInfo(infoCh, showSectors)
+			eps := minerapi.Endpoints{
+				Miner:    mm.endpoint(types.MinerNode),
+				Fullnode: mm.endpoint(types.FullNode),
+			}
+			minerapi.GetMinerInfo(infoCh, eps, showSectors)
 			info := <-infoCh
 
 			if len(info.State) != 0 {
@@ -494,14 +524,14 @@ func NewMinerMetrics(cfg MinerMetricsConfig, paths []string) *MinerMetrics {
 			mm.minerInfo = info
 			mm.mutex.Unlock()
 
-			minerapi.GetSealingJobs(jobsCh)
+			minerapi.GetSealingJobs(jobsCh, eps)
 			jobs := <-jobsCh
 
 			mm.mutex.Lock()
 			mm.sealingJobs = jobs
 			mm.mutex.Unlock()
 
-			minerapi.GetWorkerInfos(workersCh)
+			minerapi.GetWorkerInfos(workersCh, eps)
 			workerInfos := <-workersCh
 
 			mm.mutex.Lock()
@@ -513,13 +543,68 @@ func NewMinerMetrics(cfg MinerMetricsConfig, paths []string) *MinerMetrics {
 			mm.storageStat = storageStat
 			mm.mutex.Unlock()
 
-			<-ticker.C
+			mountStat := mm.statStorageMounts()
+			mm.mutex.Lock()
+			mm.storageMountStat = mountStat
+			mm.mutex.Unlock()
+
+			select {
+			case <-ticker.C:
+			case <-cfg.Context.Done():
+				return
+			}
 		}
-	}()
+	})
 
 	return mm
 }
 
+// endpoint is parsed on every poll so api info changes of a topology reload
+// are picked up, a missing endpoint falls back to lotus-miner
+func (m *MinerMetrics) endpoint(role string) *lotusbase.Endpoint {
+	if m.config.ApiInfo == nil {
+		return nil
+	}
+	info, err := m.config.ApiInfo(role)
+	if err != nil {
+		return nil
+	}
+	// lotus-miner only serves v0
+	version := "v0"
+	if role == types.FullNode {
+		version = lotusapi.ApiVersion
+	}
+	ep, err := lotusbase.ParseApiInfo(info, version)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to parse %v api info: %v", role, err)
+		return nil
+	}
+	return ep
+}
+
+// statStorageMounts checks the network storages are reachable and writable,
+// ceph, glusterfs and nfs alike
+func (m *MinerMetrics) statStorageMounts() []storageMountStat {
+	if m.config.StorageMounts == nil {
+		return nil
+	}
+	stats := []storageMountStat{}
+	for _, mount := range m.config.StorageMounts() {
+		err := systemapi.StatTimeout(mount.MountPoint, storageStatTimeout)
+		if err == nil {
+			rw, werr := systemapi.MountpointWrittable(mount.MountPoint)
+			if werr != nil {
+				err = werr
+			} else if !rw {
+				err = xerrors.Errorf("%v is mounted read only", mount.MountPoint)
+			}
+		}
+		health.Update(fmt.Sprintf("storage:%v", mount.MountPoint), err)
+		stats = append(stats, storageMountStat{mount: mount, err: err})
+	}
+	return stats
+}
+
 func (m *MinerMetrics) SetHost(host string) {
 	m.host = host
 	m.hasHost = true
@@ -530,6 +615,16 @@ func (m *MinerMetrics) SetFullnodeHost(host string) {
 	m.ml.SetFullnodeHost(host)
 }
 
+func (m *MinerMetrics) SetLogfile(logfile string) {
+	m.ml.SetLogfile(logfile)
+}
+
+func (m *MinerMetrics) SetStoragePaths(paths []string) {
+	m.mutex.Lock()
+	m.lotusStoragePath = paths
+	m.mutex.Unlock()
+}
+
 func (m *MinerMetrics) Describe(ch chan<- *prometheus.Desc) {
 	ch <- m.ForkBlocks
 	ch <- m.PastBlocks
@@ -583,6 +678,7 @@ func (m *MinerMetrics) Describe(ch chan<- *prometheus.Desc) {
 	ch <- m.ChainHeadListen
 	ch <- m.StorageMountpointPermission
 	ch <- m.StorageMountError
+	ch <- m.StorageBackendError
 	ch <- m.MinerOpenFileNumber
 	ch <- m.MinerProcessTcpConnectNumber
 	ch <- m.MinerAdjustBaseFee
@@ -728,7 +824,9 @@ func (m *MinerMetrics) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(m.MinerWorkers, prometheus.CounterValue, float64(len(workerInfos.Infos)), networkType, username)
 	for worker, info := range workerInfos.Infos {
 		ch <- prometheus.MustNewConstMetric(m.MinerWorkerGPUs, prometheus.CounterValue, float64(info.GPUs), worker, networkType, username)
-		ch <- prometheus.MustNewConstMetric(m.MinerWorkerMaintaining, prometheus.CounterValue, float64(info.Maintaining), worker, networkType, username)
+		if workerInfos.HasMaintaining {
+			ch <- prometheus.MustNewConstMetric(m.MinerWorkerMaintaining, prometheus.CounterValue, float64(info.Maintaining), worker, networkType, username)
+		}
 		ch <- prometheus.MustNewConstMetric(m.MinerWorkerRejectTask, prometheus.CounterValue, float64(info.RejectTask), worker, networkType, username)
 		gpus += info.GPUs
 	}
@@ -789,6 +887,18 @@ func (m *MinerMetrics) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(m.StorageMountpointPermission, prometheus.CounterValue, float64(filePerm), k, networkType, username)
 	}
 
+	m.mutex.Lock()
+	mountStat := m.storageMountStat
+	m.mutex.Unlock()
+
+	for _, stat := range mountStat {
+		storageError := 0.0
+		if stat.err != nil {
+			storageError = 1
+		}
+		ch <- prometheus.MustNewConstMetric(m.StorageBackendError, prometheus.CounterValue, storageError, stat.mount.MountPoint, stat.mount.Backend, networkType, username)
+	}
+
 	minerFileOpenNumber, _ := systemapi.GetProcessOpenFileNumber("lotus-miner")
 	ch <- prometheus.MustNewConstMetric(m.MinerOpenFileNumber, prometheus.CounterValue, float64(minerFileOpenNumber), networkType, username)
 
@@ -827,9 +937,16 @@ func (m *MinerMetrics) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(m.MiningNetworkPower, prometheus.CounterValue, miningNetworkPower, networkType, username)
 	ch <- prometheus.MustNewConstMetric(m.MiningMinerPower, prometheus.CounterValue, miningMinerPower, networkType, username)
 
-	for _, path := range m.lotusStoragePath {
+	m.mutex.Lock()
+	paths := m.lotusStoragePath
+	m.mutex.Unlock()
+	backends := map[string]string{}
+	if m.config.StorageBackends != nil {
+		backends = m.config.StorageBackends()
+	}
+	for _, path := range paths {
 		pathStatus := getMinerRepoDirUsage(path)
-		ch <- prometheus.MustNewConstMetric(m.MinerRepoDirUsage, prometheus.CounterValue, pathStatus.Used, fmt.Sprintf("%v", path), fmt.Sprintf("%v", pathStatus.All), networkType, username)
+		ch <- prometheus.MustNewConstMetric(m.MinerRepoDirUsage, prometheus.CounterValue, pathStatus.Used, fmt.Sprintf("%v", path), fmt.Sprintf("%v", pathStatus.All), backends[path], networkType, username)
 	}
 }
 
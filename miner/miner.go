@@ -6,6 +6,7 @@ import (
 	"github.com/NpoolDevOps/fbc-devops-peer/basenode"
 	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
 	exporter "github.com/NpoolDevOps/fbc-devops-peer/exporter"
+	health "github.com/NpoolDevOps/fbc-devops-peer/health"
 	"github.com/NpoolDevOps/fbc-devops-peer/metrics/minermetrics"
 	"github.com/NpoolDevOps/fbc-devops-peer/types"
 	"github.com/NpoolDevOps/fbc-devops-peer/version"
@@ -19,8 +20,13 @@ type MinerNode struct {
 
 func NewMinerNode(config *basenode.BasenodeConfig, devopsClient *devops.DevopsClient) *MinerNode {
 	log.Infof(log.Fields{}, "create %v node", config.NodeConfig.MainRole)
+	return NewMinerNodeWithBase(basenode.NewBasenode(config, devopsClient))
+}
+
+// NewMinerNodeWithBase creates the role on a basenode shared with other roles
+func NewMinerNodeWithBase(base *basenode.Basenode) *MinerNode {
 	miner := &MinerNode{
-		basenode.NewBasenode(config, devopsClient),
+		base,
 		nil,
 	}
 
@@ -29,13 +35,18 @@ func NewMinerNode(config *basenode.BasenodeConfig, devopsClient *devops.DevopsCl
 	logfile, _ := miner.GetLogFileByRole(types.MinerNode)
 	shareStorageRoot, _ := miner.GetShareStorageRootByRole(types.MinerNode)
 	miner.minerMetrics = minermetrics.NewMinerMetrics(minermetrics.MinerMetricsConfig{
+		Context:          miner.Context(),
 		ShareStorageRoot: shareStorageRoot,
 		Logfile:          logfile,
-		Username:         config.Username,
-		NetworkType:      config.NetworkType,
+		Username:         miner.Username,
+		NetworkType:      miner.NetworkType,
+		StorageMounts:    miner.GetStorageMounts,
+		StorageBackends:  miner.GetMinerStorageBackends,
+		ApiInfo:          miner.GetApiInfo,
 	}, paths)
 
 	miner.SetAddrNotifier(miner.addressNotifier)
+	miner.SetTopologyNotifier(miner.topologyNotifier)
 	fullnodeHost, err := miner.GetFullnodeApiHost(types.FullNode)
 	miner.WatchVersions(fullnodeHost, err, miner.getVersions)
 	return miner
@@ -48,10 +59,17 @@ func (n *MinerNode) addressNotifier(string, string) {
 	n.minerMetrics.SetFullnodeHost(fullnodeHost)
 }
 
+func (n *MinerNode) topologyNotifier() {
+	logfile, _ := n.GetLogFileByRole(types.MinerNode)
+	n.minerMetrics.SetLogfile(logfile)
+	n.minerMetrics.SetStoragePaths(n.GetMinerStoragePath())
+}
+
 func (n *MinerNode) getVersions(host string) []version.Version {
 	vers := []version.Version{}
 
 	ver, err := lotusapi.ClientVersion(host)
+	health.Update("lotus-api", err)
 	if err == nil {
 		vers = append(vers, ver)
 	}
@@ -59,6 +77,10 @@ func (n *MinerNode) getVersions(host string) []version.Version {
 	return vers
 }
 
+func (n *MinerNode) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{n.minerMetrics}
+}
+
 func (n *MinerNode) Describe(ch chan<- *prometheus.Desc) {
 	n.minerMetrics.Describe(ch)
 	n.BaseMetrics.Describe(ch)
@@ -70,7 +92,7 @@ func (n *MinerNode) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (n *MinerNode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(n)
+	return exporter.NewExporter(n.Context(), n)
 }
 
 func (n *MinerNode) Banner() {
@@ -0,0 +1,102 @@
+package multinode
+
+import (
+	"strings"
+
+	log "github.com/EntropyPool/entropy-logger"
+	"github.com/NpoolDevOps/fbc-devops-peer/basenode"
+	chiaminer "github.com/NpoolDevOps/fbc-devops-peer/chiaminer"
+	chiaplotter "github.com/NpoolDevOps/fbc-devops-peer/chiaplotter"
+	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
+	exporter "github.com/NpoolDevOps/fbc-devops-peer/exporter"
+	fullnode "github.com/NpoolDevOps/fbc-devops-peer/fullnode"
+	miner "github.com/NpoolDevOps/fbc-devops-peer/miner"
+	storage "github.com/NpoolDevOps/fbc-devops-peer/storage"
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+	worker "github.com/NpoolDevOps/fbc-devops-peer/worker"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/xerrors"
+)
+
+type roleNode interface {
+	Collectors() []prometheus.Collector
+}
+
+// MultiNode runs several roles on one host, they share the basenode so the
+// device is registered once with all roles and exported by one exporter
+type MultiNode struct {
+	*basenode.Basenode
+	roles []roleNode
+}
+
+// ComposableRole tells whether the role can run together with other roles,
+// fullminer is already a combination and gateway has its own configuration
+func ComposableRole(role string) bool {
+	switch role {
+	case types.FullNode, types.MinerNode, types.WorkerNode, types.StorageNode,
+		types.ChiaMinerNode, types.ChiaPlotterNode:
+		return true
+	}
+	return false
+}
+
+func NewMultiNode(config *basenode.BasenodeConfig, devopsClient *devops.DevopsClient) (*MultiNode, error) {
+	log.Infof(log.Fields{}, "create %v node", config.NodeConfig.MainRole)
+
+	for _, role := range basenode.ParseRoles(config.NodeConfig.MainRole) {
+		if !ComposableRole(role) {
+			return nil, xerrors.Errorf("role %v cannot run with other roles", role)
+		}
+	}
+
+	n := &MultiNode{
+		Basenode: basenode.NewBasenode(config, devopsClient),
+	}
+
+	for _, role := range n.GetRoles() {
+		switch role {
+		case types.FullNode:
+			n.roles = append(n.roles, fullnode.NewFullNodeWithBase(n.Basenode))
+		case types.MinerNode:
+			n.roles = append(n.roles, miner.NewMinerNodeWithBase(n.Basenode))
+		case types.WorkerNode:
+			n.roles = append(n.roles, worker.NewWorkerNodeWithBase(n.Basenode))
+		case types.StorageNode:
+			n.roles = append(n.roles, storage.NewStorageNodeWithBase(n.Basenode))
+		case types.ChiaMinerNode:
+			n.roles = append(n.roles, chiaminer.NewChiaMinerNodeWithBase(n.Basenode))
+		case types.ChiaPlotterNode:
+			n.roles = append(n.roles, chiaplotter.NewPlotterNodeWithBase(n.Basenode))
+		}
+	}
+
+	return n, nil
+}
+
+func (n *MultiNode) Describe(ch chan<- *prometheus.Desc) {
+	for _, role := range n.roles {
+		for _, collector := range role.Collectors() {
+			collector.Describe(ch)
+		}
+	}
+	n.BaseMetrics.Describe(ch)
+}
+
+func (n *MultiNode) Collect(ch chan<- prometheus.Metric) {
+	for _, role := range n.roles {
+		for _, collector := range role.Collectors() {
+			collector.Collect(ch)
+		}
+	}
+	n.BaseMetrics.Collect(ch)
+}
+
+func (n *MultiNode) CreateExporter() *exporter.Exporter {
+	return exporter.NewExporter(n.Context(), n)
+}
+
+func (n *MultiNode) Banner() {
+	log.Infof(log.Fields{}, "IIIIIIIIIIIIIIIIIIIIIIIIIIIIIIIIIII")
+	log.Infof(log.Fields{}, "   MULTI %v", strings.ToUpper(n.GetMainRole()))
+	log.Infof(log.Fields{}, "IIIIIIIIIIIIIIIIIIIIIIIIIIIIIIIIIII")
+}
@@ -1,6 +1,8 @@
 package node
 
 import (
+	"context"
+
 	exporter "github.com/NpoolDevOps/fbc-devops-peer/exporter"
 	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,10 +14,14 @@ type Node interface {
 	NotifyParentSpec(string)
 	GetParentIP() (string, error)
 	GetChildsIPs() ([]string, error)
+	GetFullnodeApiHost(string) (string, error)
+	GetApiInfo(string) (string, error)
 	NotifyPeerId(uuid.UUID)
 	Banner()
 	SetPeer(interface{})
 	Describe(ch chan<- *prometheus.Desc)
 	Collect(ch chan<- prometheus.Metric)
 	CreateExporter() *exporter.Exporter
+	Context() context.Context
+	Shutdown(ctx context.Context) error
 }
@@ -1,6 +1,7 @@
 package operation
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	runtime "github.com/NpoolDevOps/fbc-devops-peer/runtime"
@@ -10,9 +11,30 @@ import (
 	"time"
 )
 
+var defaultKernelErrors = []string{
+	"CE memory read error",
+	"UE memory read error",
+	"EDAC",
+	"Machine check",
+	"mce:",
+	"Hardware Error",
+	"I/O error",
+	"timeout, aborting",
+	"timeout, reset controller",
+	"critical medium error",
+}
+
+var memoryKernelErrors = []string{
+	"memory read error",
+	"EDAC",
+	"Machine check",
+	"mce:",
+}
+
 type bondConfig struct {
 	Ethernets []string `json:"ethernets"`
 	Bond      string   `json:"bond"`
+	Mode      string   `json:"mode"`
 }
 
 type acceptanceParams struct {
@@ -31,6 +53,15 @@ type acceptanceParams struct {
 	EthernetSpeed      string     `json:"ethernet_speed"`
 	EthernetBondConfig bondConfig `json:"ethernet_bond_config"`
 	OsSpec             string     `json:"os_spec"`
+	KernelErrors       []string   `json:"kernel_errors"`
+	DiskTest           bool       `json:"disk_test"`
+	DiskTestDuration   int        `json:"disk_test_duration"`
+	DiskWrite          bool       `json:"disk_write"`
+	Force              bool       `json:"force"`
+	NvmeMinRead        float64    `json:"nvme_min_read"`
+	NvmeMinWrite       float64    `json:"nvme_min_write"`
+	HddMinRead         float64    `json:"hdd_min_read"`
+	HddMinWrite        float64    `json:"hdd_min_write"`
 }
 
 type acceptanceResult struct {
@@ -73,36 +104,63 @@ func newAcceptanceResult(name string, expect, result interface{}, err error) acc
 	}
 }
 
-func kernelError(specs []string) []acceptanceResult {
-	results := []acceptanceResult{}
+const maxKernelMessages = 100
+
+// watchKernelMessages collects kernel messages matching any of specs until stop
+func watchKernelMessages(specs []string, seekEnd bool, stop <-chan struct{}) ([]string, error) {
+	messages := []string{}
 
 	parser, err := kmsgparser.NewParser()
 	if err != nil {
-		results = append(results, newAcceptanceResult("Kernel Error", err.Error(), "", err))
-		return results
+		return messages, err
+	}
+
+	if seekEnd {
+		err = parser.SeekEnd()
+		if err != nil {
+			parser.Close()
+			return messages, err
+		}
 	}
 
 	msgCh := parser.Parse()
-	specMap := map[string]struct{}{}
 
 	go func() {
-		time.Sleep(10 * time.Second)
+		<-stop
 		parser.Close()
 	}()
 
-processDmesgLoop:
-	for {
-		select {
-		case msg, ok := <-msgCh:
-			if !ok {
-				break processDmesgLoop
+	for msg := range msgCh {
+		if maxKernelMessages <= len(messages) {
+			continue
+		}
+		for _, spec := range specs {
+			if strings.Contains(msg.Message, spec) {
+				messages = append(messages, msg.Message)
+				break
 			}
-			for _, spec := range specs {
-				_, ok := specMap[spec]
-				if ok && strings.Contains(msg.Message, spec) {
-					specMap[spec] = struct{}{}
-					results = append(results, newAcceptanceResult("Kernel Error", "", msg.Message, err))
-				}
+		}
+	}
+
+	return messages, nil
+}
+
+func watchKernelErrors(specs []string, seekEnd bool, stop <-chan struct{}) []acceptanceResult {
+	results := []acceptanceResult{}
+
+	messages, err := watchKernelMessages(specs, seekEnd, stop)
+	if err != nil {
+		results = append(results, newAcceptanceResult("Kernel Error", err.Error(), "", err))
+		return results
+	}
+
+	specMap := map[string]struct{}{}
+	for _, msg := range messages {
+		for _, spec := range specs {
+			_, ok := specMap[spec]
+			if !ok && strings.Contains(msg, spec) {
+				specMap[spec] = struct{}{}
+				results = append(results, newAcceptanceResult("Kernel Error", "", msg, err))
 			}
 		}
 	}
@@ -110,7 +168,19 @@ processDmesgLoop:
 	return results
 }
 
-func acceptanceExec(params string) (interface{}, error) {
+func kernelError(ctx context.Context, specs []string) []acceptanceResult {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-time.After(10 * time.Second):
+		case <-ctx.Done():
+		}
+		close(stop)
+	}()
+	return watchKernelErrors(specs, false, stop)
+}
+
+func acceptanceExec(ctx context.Context, job *Job, params string) (interface{}, error) {
 	p := acceptanceParams{}
 	err := json.Unmarshal([]byte(params), &p)
 	if err != nil {
@@ -128,13 +198,40 @@ func acceptanceExec(params string) (interface{}, error) {
 		cpuList := runtime.GetCpuList()
 		for i, cpu := range cpuList {
 			results.Results = append(results.Results, newAcceptanceResult(fmt.Sprintf("CPU %v Desc", i), p.CpuBrand, cpu.Model, err))
+			if 0 < p.CpuCores {
+				results.Results = append(results.Results, newAcceptanceResult(fmt.Sprintf("CPU %v Cores", i), p.CpuCores, int(cpu.NumCores), err))
+			}
 		}
 	}
 
-	memoryErr := kernelError([]string{
-		"CE memory read error",
-	})
-	results.Results = append(results.Results, memoryErr[0:]...)
+	if 0 < p.Memorys {
+		results.Results = append(results.Results, memoryAcceptance(&p)...)
+	}
+
+	if p.OsSpec != "" {
+		release, err := osRelease()
+		results.Results = append(results.Results, newAcceptanceResult("OS Spec", p.OsSpec, release, err))
+	}
+
+	specs := p.KernelErrors
+	if len(specs) == 0 {
+		specs = defaultKernelErrors
+	}
+
+	job.Logf("check kernel errors")
+	kernelErrs := kernelError(ctx, specs)
+	results.Results = append(results.Results, kernelErrs...)
+	job.SetProgress(50)
+
+	memoryErr := []acceptanceResult{}
+	for _, kernelErr := range kernelErrs {
+		for _, spec := range memoryKernelErrors {
+			if strings.Contains(kernelErr.Description, spec) {
+				memoryErr = append(memoryErr, kernelErr)
+				break
+			}
+		}
+	}
 
 	if 0 < p.Nvmes {
 		nvmes, err := runtime.GetNvmeCount()
@@ -189,12 +286,19 @@ func acceptanceExec(params string) (interface{}, error) {
 		results.Results = append(results.Results, newAcceptanceResult("Ethernet Count", p.Ethernets, eths, err))
 	}
 
-	// If no memory error, do simple NVME | HDD test to check IO error
-	// If nvme or hdd is mounted, notify to deployer to check, or pass force to umount and test them
-	// Simple test nvme and collect test result, and kernel error
-	// Simple test hdd and collect test result, and kernel error
+	if 0 < p.Ethernets || p.EthernetSpeed != "" || p.EthernetBondConfig.Bond != "" {
+		results.Results = append(results.Results, ethernetAcceptance(&p)...)
+	}
 
-	// Check Ethernet
+	// IO test on bad memory would blame the disks, so only run it with clean memory
+	if p.DiskTest {
+		if len(memoryErr) == 0 {
+			job.Logf("disk io test")
+			results.Results = append(results.Results, diskAcceptance(ctx, job, &p)...)
+		} else {
+			job.Logf("skip disk io test for memory error")
+		}
+	}
 
 	return results, nil
 }
@@ -0,0 +1,343 @@
+package operation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"sync"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	runtime "github.com/NpoolDevOps/fbc-devops-peer/runtime"
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+	"golang.org/x/xerrors"
+)
+
+const (
+	baselineDefaultDuration = 30
+	baselineProfileFile     = "baseline.json"
+)
+
+type baselineParams struct {
+	Duration        int    `json:"duration"`
+	IperfPeer       string `json:"iperf_peer"`
+	SkipDisks       bool   `json:"skip_disks"`
+	UpdateReference bool   `json:"update_reference"`
+}
+
+type baselineResult struct {
+	Device      string  `json:"device"`
+	TestName    string  `json:"test_name"`
+	Value       float64 `json:"value"`
+	Unit        string  `json:"unit"`
+	Reference   float64 `json:"reference"`
+	Deviation   float64 `json:"deviation"`
+	Description string  `json:"description"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type baselineResults struct {
+	Timestamp int64            `json:"timestamp"`
+	Results   []baselineResult `json:"baseline_results"`
+}
+
+func baselineProfilePath() string {
+	return filepath.Join(types.PeerStateDir(), baselineProfileFile)
+}
+
+func newBaselineResult(device, name string, value float64, unit string, err error) baselineResult {
+	result := baselineResult{
+		Device:      device,
+		TestName:    name,
+		Value:       value,
+		Unit:        unit,
+		Description: fmt.Sprintf("BASELINE %v %v [%.2f %v]", device, name, value, unit),
+	}
+	if err != nil {
+		result.Description = fmt.Sprintf("BASELINE %v %v FAIL (%v)", device, name, err)
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func cpuBaseline(ctx context.Context, duration time.Duration) (float64, error) {
+	cores := goruntime.NumCPU()
+	buf := make([]byte, 64*1024)
+	bytes := make([]uint64, cores)
+
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(duration)
+
+	for i := 0; i < cores; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			for time.Now().Before(deadline) && ctx.Err() == nil {
+				sha256.Sum256(buf)
+				bytes[idx] += uint64(len(buf))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	var total uint64
+	for _, b := range bytes {
+		total += b
+	}
+
+	return float64(total) / 1024 / 1024 / duration.Seconds(), nil
+}
+
+func memoryBaseline(ctx context.Context, duration time.Duration) (float64, error) {
+	src := make([]byte, 256*1024*1024)
+	dst := make([]byte, len(src))
+	for i := range src {
+		src[i] = byte(i)
+	}
+
+	var total uint64
+	start := time.Now()
+	for time.Since(start) < duration {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		copy(dst, src)
+		total += uint64(len(src))
+	}
+
+	return float64(total) / 1024 / 1024 / 1024 / time.Since(start).Seconds(), nil
+}
+
+type fioOutput struct {
+	Jobs []struct {
+		Read struct {
+			Bw   float64 `json:"bw"`
+			Iops float64 `json:"iops"`
+		} `json:"read"`
+		Write struct {
+			Bw   float64 `json:"bw"`
+			Iops float64 `json:"iops"`
+		} `json:"write"`
+	} `json:"jobs"`
+}
+
+func fioReadBaseline(ctx context.Context, dev string, rw string, bs string, duration time.Duration) (float64, float64, error) {
+	out, err := exec.CommandContext(ctx, "fio",
+		"--name=baseline", fmt.Sprintf("--filename=%v", dev),
+		fmt.Sprintf("--rw=%v", rw), fmt.Sprintf("--bs=%v", bs),
+		"--direct=1", "--ioengine=libaio", "--iodepth=32", "--readonly",
+		fmt.Sprintf("--runtime=%v", int(duration.Seconds())), "--time_based",
+		"--output-format=json",
+	).Output()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	output := fioOutput{}
+	err = json.Unmarshal(out, &output)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(output.Jobs) == 0 {
+		return 0, 0, xerrors.Errorf("fio output without job")
+	}
+
+	// fio reports bandwidth in KiB/s
+	return output.Jobs[0].Read.Bw / 1024, output.Jobs[0].Read.Iops, nil
+}
+
+func diskBaseline(ctx context.Context, disks []*runtime.DiskInfo, kind string, duration time.Duration) []baselineResult {
+	results := []baselineResult{}
+
+	for _, disk := range disks {
+		dev := fmt.Sprintf("/dev/%v", disk.Name)
+
+		bw, _, err := fioReadBaseline(ctx, dev, "read", "1M", duration)
+		results = append(results, newBaselineResult(dev, fmt.Sprintf("%v Sequential Read", kind), bw, "MiB/s", err))
+
+		_, iops, err := fioReadBaseline(ctx, dev, "randread", "4k", duration)
+		results = append(results, newBaselineResult(dev, fmt.Sprintf("%v Random Read", kind), iops, "IOPS", err))
+	}
+
+	return results
+}
+
+type iperfOutput struct {
+	End struct {
+		SumReceived struct {
+			BitsPerSecond float64 `json:"bits_per_second"`
+		} `json:"sum_received"`
+	} `json:"end"`
+}
+
+func nicBaseline(ctx context.Context, peer string, duration time.Duration) (float64, error) {
+	out, err := exec.CommandContext(ctx, "iperf3", "-c", peer,
+		"-t", fmt.Sprintf("%v", int(duration.Seconds())), "-J").Output()
+	if err != nil {
+		return 0, err
+	}
+
+	output := iperfOutput{}
+	err = json.Unmarshal(out, &output)
+	if err != nil {
+		return 0, err
+	}
+
+	return output.End.SumReceived.BitsPerSecond / 1000 / 1000, nil
+}
+
+func readBaselineProfile() (*baselineResults, error) {
+	b, err := ioutil.ReadFile(baselineProfilePath())
+	if err != nil {
+		return nil, err
+	}
+	profile := baselineResults{}
+	err = json.Unmarshal(b, &profile)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func writeBaselineProfile(results *baselineResults) error {
+	profilePath := baselineProfilePath()
+	os.MkdirAll(filepath.Dir(profilePath), 0755)
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(profilePath, b, 0644)
+}
+
+// compareBaseline sets the deviation of each measured result, failed ones
+// on either side have no value to compare
+func compareBaseline(results *baselineResults, reference *baselineResults) {
+	refs := map[string]float64{}
+	for _, ref := range reference.Results {
+		if ref.Error == "" && ref.Value != 0 {
+			refs[ref.Device+"|"+ref.TestName] = ref.Value
+		}
+	}
+
+	for i, result := range results.Results {
+		ref, ok := refs[result.Device+"|"+result.TestName]
+		if !ok || result.Error != "" {
+			continue
+		}
+		results.Results[i].Reference = ref
+		results.Results[i].Deviation = (result.Value - ref) / ref * 100
+	}
+}
+
+// baselineReference returns the reference after a run and whether it
+// changed, a measured result replaces its reference on update and is added
+// when it has none, failed results never become a reference
+func baselineReference(results *baselineResults, previous *baselineResults, update bool) (*baselineResults, bool) {
+	profile := &baselineResults{
+		Timestamp: results.Timestamp,
+		Results:   []baselineResult{},
+	}
+	index := map[string]int{}
+	if previous != nil {
+		profile.Timestamp = previous.Timestamp
+		for _, ref := range previous.Results {
+			if ref.Error != "" || ref.Value == 0 {
+				continue
+			}
+			index[ref.Device+"|"+ref.TestName] = len(profile.Results)
+			profile.Results = append(profile.Results, ref)
+		}
+	}
+
+	changed := previous == nil || len(profile.Results) != len(previous.Results)
+	for _, result := range results.Results {
+		if result.Error != "" {
+			continue
+		}
+		result.Reference = 0
+		result.Deviation = 0
+		i, ok := index[result.Device+"|"+result.TestName]
+		if !ok {
+			index[result.Device+"|"+result.TestName] = len(profile.Results)
+			profile.Results = append(profile.Results, result)
+			changed = true
+		} else if update {
+			profile.Results[i] = result
+			changed = true
+		}
+	}
+	if changed && update {
+		profile.Timestamp = results.Timestamp
+	}
+	return profile, changed
+}
+
+func baselineExec(ctx context.Context, job *Job, params string) (interface{}, error) {
+	p := baselineParams{}
+	err := json.Unmarshal([]byte(params), &p)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Duration <= 0 {
+		p.Duration = baselineDefaultDuration
+	}
+	duration := time.Duration(p.Duration) * time.Second
+
+	results := baselineResults{
+		Timestamp: time.Now().Unix(),
+		Results:   []baselineResult{},
+	}
+
+	job.Logf("baseline test cpu for %v", duration)
+	mbps, err := cpuBaseline(ctx, duration)
+	results.Results = append(results.Results, newBaselineResult("cpu", "CPU SHA256", mbps, "MiB/s", err))
+	job.SetProgress(20)
+
+	job.Logf("baseline test memory for %v", duration)
+	gbps, err := memoryBaseline(ctx, duration)
+	results.Results = append(results.Results, newBaselineResult("memory", "Memory Copy", gbps, "GiB/s", err))
+	job.SetProgress(40)
+
+	if !p.SkipDisks {
+		job.Logf("baseline test nvme and hdd for %v each", duration)
+		results.Results = append(results.Results, diskBaseline(ctx, runtime.GetNvmeList(), "NVME", duration)...)
+		results.Results = append(results.Results, diskBaseline(ctx, runtime.GetHddList(), "HDD", duration)...)
+	}
+	job.SetProgress(80)
+
+	if p.IperfPeer != "" {
+		job.Logf("baseline test ethernet to %v for %v", p.IperfPeer, duration)
+		mbits, err := nicBaseline(ctx, p.IperfPeer, duration)
+		results.Results = append(results.Results, newBaselineResult(p.IperfPeer, "Ethernet Throughput", mbits, "Mbit/s", err))
+	}
+
+	if ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+
+	reference, _ := readBaselineProfile()
+	if reference != nil && !p.UpdateReference {
+		compareBaseline(&results, reference)
+	}
+
+	profile, changed := baselineReference(&results, reference, reference == nil || p.UpdateReference)
+	if changed {
+		err = writeBaselineProfile(profile)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to save baseline profile: %v", err)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,144 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCompareBaseline(t *testing.T) {
+	reference := &baselineResults{
+		Results: []baselineResult{
+			{Device: "cpu", TestName: "CPU SHA256", Value: 1000},
+			{Device: "memory", TestName: "Memory Copy", Value: 10},
+			{Device: "/dev/nvme0n1", TestName: "NVME Random Read", Value: 0},
+			{Device: "/dev/sdb", TestName: "HDD Random Read", Value: 150, Error: "fio: timeout"},
+		},
+	}
+
+	tests := []struct {
+		device    string
+		name      string
+		value     float64
+		err       string
+		reference float64
+		deviation float64
+	}{
+		{"cpu", "CPU SHA256", 1100, "", 1000, 10},
+		{"memory", "Memory Copy", 7.5, "", 10, -25},
+		{"memory", "Memory Copy", 10, "", 10, 0},
+		// a failed test has no value to compare
+		{"cpu", "CPU SHA256", 0, "context canceled", 0, 0},
+		// no reference, or a failed one, is not compared
+		{"/dev/nvme0n1", "NVME Random Read", 50000, "", 0, 0},
+		{"/dev/sda", "HDD Random Read", 200, "", 0, 0},
+		{"/dev/sdb", "HDD Random Read", 200, "", 0, 0},
+		// the same test of another device is not its reference
+		{"/dev/nvme1n1", "NVME Random Read", 50000, "", 0, 0},
+	}
+
+	for _, test := range tests {
+		results := &baselineResults{
+			Results: []baselineResult{{Device: test.device, TestName: test.name, Value: test.value, Error: test.err}},
+		}
+		compareBaseline(results, reference)
+		result := results.Results[0]
+		if result.Reference != test.reference || math.Abs(result.Deviation-test.deviation) > 1e-9 {
+			t.Errorf("%v %v %v: reference %v deviation %v, expect %v %v",
+				test.device, test.name, test.value, result.Reference, result.Deviation, test.reference, test.deviation)
+		}
+	}
+}
+
+func TestBaselineReference(t *testing.T) {
+	previous := &baselineResults{
+		Timestamp: 1,
+		Results: []baselineResult{
+			{Device: "cpu", TestName: "CPU SHA256", Value: 1000},
+			{Device: "memory", TestName: "Memory Copy", Value: 0},
+		},
+	}
+	results := &baselineResults{
+		Timestamp: 2,
+		Results: []baselineResult{
+			{Device: "cpu", TestName: "CPU SHA256", Value: 0, Error: "context canceled"},
+			{Device: "memory", TestName: "Memory Copy", Value: 10},
+			{Device: "/dev/sda", TestName: "HDD Random Read", Value: 0, Error: "fio: not found"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		previous *baselineResults
+		update   bool
+		changed  bool
+		values   map[string]float64
+	}{
+		// the failed cpu keeps its reference, the failed memory one is measured now
+		{"update", previous, true, true, map[string]float64{"cpu": 1000, "memory": 10}},
+		{"compare", previous, false, true, map[string]float64{"cpu": 1000, "memory": 10}},
+		{"first", nil, true, true, map[string]float64{"memory": 10}},
+		{"complete", &baselineResults{Results: []baselineResult{{Device: "memory", TestName: "Memory Copy", Value: 8}}}, false, false, map[string]float64{"memory": 8}},
+	}
+
+	for _, test := range tests {
+		profile, changed := baselineReference(results, test.previous, test.update)
+		if changed != test.changed {
+			t.Errorf("%v: changed %v, expect %v", test.name, changed, test.changed)
+		}
+		values := map[string]float64{}
+		for _, ref := range profile.Results {
+			if ref.Error != "" {
+				t.Errorf("%v: failed result %v saved as reference", test.name, ref.TestName)
+			}
+			values[ref.Device] = ref.Value
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%v: reference %v, expect %v", test.name, values, test.values)
+		}
+	}
+}
+
+func TestBaselineProfileRoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	run := func(update bool) baselineResults {
+		params, _ := json.Marshal(baselineParams{Duration: 1, SkipDisks: true, UpdateReference: update})
+		out, err := baselineExec(context.Background(), &Job{}, string(params))
+		if err != nil {
+			t.Fatalf("cannot run baseline: %v", err)
+		}
+		return out.(baselineResults)
+	}
+
+	first := run(false)
+	for _, result := range first.Results {
+		if result.Reference != 0 {
+			t.Errorf("first run should have no reference: %+v", result)
+		}
+	}
+	profile, err := readBaselineProfile()
+	if err != nil {
+		t.Fatalf("first run should save the profile: %v", err)
+	}
+	if len(profile.Results) != len(first.Results) || profile.Results[0].Value != first.Results[0].Value {
+		t.Fatalf("saved profile %+v differs from first run %+v", profile, first)
+	}
+
+	second := run(false)
+	for i, result := range second.Results {
+		if result.Reference != first.Results[i].Value {
+			t.Errorf("%v reference %v, expect the first run %v", result.TestName, result.Reference, first.Results[i].Value)
+		}
+	}
+	if profile, _ := readBaselineProfile(); profile.Results[0].Value != first.Results[0].Value {
+		t.Errorf("compared run should not replace the profile")
+	}
+
+	third := run(true)
+	if profile, _ := readBaselineProfile(); profile.Results[0].Value != third.Results[0].Value {
+		t.Errorf("update reference should replace the profile")
+	}
+}
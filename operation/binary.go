@@ -0,0 +1,387 @@
+package operation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	parser "github.com/NpoolDevOps/fbc-devops-peer/parser"
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+	"golang.org/x/xerrors"
+)
+
+var binInstallDir = "/usr/local/bin"
+
+const (
+	maxBinHistory       = 3
+	unitActiveTimeout   = 60 * time.Second
+	unitActiveCheckStep = 5 * time.Second
+)
+
+// binServiceFiles is built on each call since parser paths are configurable
+func binServiceFiles() map[string]string {
+	return map[string]string{
+		"lotus":        parser.FullnodeServiceFile,
+		"lotus-miner":  parser.MinerServiceFile,
+		"lotus-worker": parser.WorkerServiceFile,
+	}
+}
+
+type installBinParams struct {
+	Application string `json:"application"`
+	Url         string `json:"url"`
+	Payload     string `json:"payload"`
+	Sha256      string `json:"sha256"`
+}
+
+type releaseBinParams struct {
+	Application string `json:"application"`
+	Sha256      string `json:"sha256"`
+	NoRestart   bool   `json:"no_restart"`
+}
+
+type binOutput struct {
+	Application string `json:"application"`
+	Sha256      string `json:"sha256"`
+	Version     string `json:"version"`
+	Path        string `json:"path"`
+}
+
+func binRootDir() string {
+	return filepath.Join(types.PeerStateDir(), "bins")
+}
+
+func binStagingFile(app string) string {
+	return filepath.Join(binRootDir(), "staging", app)
+}
+
+func binHistoryDir(app string) string {
+	return filepath.Join(binRootDir(), "history", app)
+}
+
+// binHistory returns the saved copies of app, newest first
+func binHistory(app string) ([]string, error) {
+	files, err := ioutil.ReadDir(binHistoryDir(app))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	history := []string{}
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		history = append(history, filepath.Join(binHistoryDir(app), file.Name()))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(history)))
+
+	return history, nil
+}
+
+// saveBinHistory copies the installed app into the history and drops the
+// oldest copies beyond maxBinHistory
+func saveBinHistory(app string) (string, error) {
+	installed := filepath.Join(binInstallDir, app)
+	sum, err := fileSha256(installed)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(binHistoryDir(app), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	saved := filepath.Join(binHistoryDir(app), fmt.Sprintf("%v-%v", time.Now().UnixNano(), sum[:16]))
+	err = copyFile(installed, saved, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	history, err := binHistory(app)
+	if err != nil {
+		return saved, nil
+	}
+	for i := maxBinHistory; i < len(history); i++ {
+		os.Remove(history[i])
+	}
+
+	return saved, nil
+}
+
+func checkBinApplication(app string) error {
+	if _, ok := binServiceFiles()[app]; !ok {
+		return xerrors.Errorf("unsupported application %v", app)
+	}
+	return nil
+}
+
+func fileSha256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyFile copies src to a temporary file beside dst and renames it over dst,
+// so readers of dst never see a partially written binary
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%v.new", filepath.Base(dst)))
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+func binVersion(ctx context.Context, file string) string {
+	out, err := exec.CommandContext(ctx, file, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func downloadBin(ctx context.Context, url string, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return xerrors.Errorf("fail to download %v: NON-200: %v", url, resp.StatusCode)
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func unitName(app string) string {
+	return filepath.Base(binServiceFiles()[app])
+}
+
+func restartUnit(ctx context.Context, job *Job, app string) error {
+	if _, err := os.Stat(binServiceFiles()[app]); err != nil {
+		job.Logf("no service file for %v, skip restart", app)
+		return nil
+	}
+
+	unit := unitName(app)
+	job.Logf("restart %v", unit)
+	out, err := exec.CommandContext(ctx, "systemctl", "restart", unit).CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("fail to restart %v: %v: %v", unit, err, string(out))
+	}
+
+	deadline := time.Now().Add(unitActiveTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(unitActiveCheckStep):
+		}
+		out, _ := exec.CommandContext(ctx, "systemctl", "is-active", unit).Output()
+		if strings.TrimSpace(string(out)) == "active" {
+			job.Logf("%v is active", unit)
+			return nil
+		}
+	}
+
+	return xerrors.Errorf("%v not active after %v", unit, unitActiveTimeout)
+}
+
+func installBinExec(ctx context.Context, job *Job, params string) (interface{}, error) {
+	p := installBinParams{}
+	err := json.Unmarshal([]byte(params), &p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBinApplication(p.Application)
+	if err != nil {
+		return nil, err
+	}
+	if p.Sha256 == "" {
+		return nil, xerrors.Errorf("sha256 is must")
+	}
+	if (p.Url == "") == (p.Payload == "") {
+		return nil, xerrors.Errorf("exactly one of url or payload is must")
+	}
+
+	staged := binStagingFile(p.Application)
+	err = os.MkdirAll(filepath.Dir(staged), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := staged + ".download"
+	defer os.Remove(tmp)
+
+	if p.Url != "" {
+		job.Logf("download %v from %v", p.Application, p.Url)
+		err = downloadBin(ctx, p.Url, tmp)
+	} else {
+		var b []byte
+		b, err = base64.StdEncoding.DecodeString(p.Payload)
+		if err == nil {
+			err = ioutil.WriteFile(tmp, b, 0755)
+		}
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("fail to fetch %v: %v", p.Application, err)
+	}
+	job.SetProgress(60)
+
+	sum, err := fileSha256(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(sum, p.Sha256) {
+		return nil, xerrors.Errorf("sha256 mismatch: %v != %v", sum, p.Sha256)
+	}
+
+	err = os.Chmod(tmp, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(tmp, staged)
+	if err != nil {
+		return nil, err
+	}
+
+	version := binVersion(ctx, staged)
+	job.Logf("staged %v %v sha256 %v", p.Application, version, sum)
+
+	return binOutput{
+		Application: p.Application,
+		Sha256:      sum,
+		Version:     version,
+		Path:        staged,
+	}, nil
+}
+
+func releaseBinExec(ctx context.Context, job *Job, params string) (interface{}, error) {
+	p := releaseBinParams{}
+	err := json.Unmarshal([]byte(params), &p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBinApplication(p.Application)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := binStagingFile(p.Application)
+	sum, err := fileSha256(staged)
+	if err != nil {
+		return nil, xerrors.Errorf("no staged %v: %v", p.Application, err)
+	}
+	if p.Sha256 != "" && !strings.EqualFold(sum, p.Sha256) {
+		return nil, xerrors.Errorf("staged sha256 mismatch: %v != %v", sum, p.Sha256)
+	}
+
+	installed := filepath.Join(binInstallDir, p.Application)
+	backup := ""
+
+	if _, err := os.Stat(installed); err == nil {
+		backup, err = saveBinHistory(p.Application)
+		if err != nil {
+			return nil, xerrors.Errorf("fail to backup %v: %v", installed, err)
+		}
+		job.Logf("backup %v to %v", installed, backup)
+	}
+
+	job.Logf("release %v sha256 %v", installed, sum)
+	err = copyFile(staged, installed, 0755)
+	if err != nil {
+		return nil, xerrors.Errorf("fail to release %v: %v", installed, err)
+	}
+	job.SetProgress(50)
+
+	if !p.NoRestart {
+		err = restartUnit(ctx, job, p.Application)
+		if err != nil {
+			job.Logf("restart fail: %v", err)
+			if backup == "" {
+				return nil, err
+			}
+			job.Logf("restore %v from %v", installed, backup)
+			restoreErr := copyFile(backup, installed, 0755)
+			if restoreErr != nil {
+				return nil, xerrors.Errorf("%v, and fail to restore: %v", err, restoreErr)
+			}
+			restoreErr = restartUnit(ctx, job, p.Application)
+			if restoreErr != nil {
+				return nil, xerrors.Errorf("%v, and fail to restart restored: %v", err, restoreErr)
+			}
+			return nil, xerrors.Errorf("%v, previous binary restored", err)
+		}
+	}
+
+	os.Remove(staged)
+
+	return binOutput{
+		Application: p.Application,
+		Sha256:      sum,
+		Version:     binVersion(ctx, installed),
+		Path:        installed,
+	}, nil
+}
@@ -0,0 +1,52 @@
+package operation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestInstallBinPayload(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	payload := []byte("#!/bin/sh\necho test\n")
+	h := sha256.Sum256(payload)
+	job := &Job{}
+
+	params, _ := json.Marshal(installBinParams{
+		Application: "lotus-miner",
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Sha256:      "00",
+	})
+	_, err := installBinExec(context.Background(), job, string(params))
+	if err == nil {
+		t.Fatalf("sha256 mismatch should be rejected")
+	}
+
+	params, _ = json.Marshal(installBinParams{
+		Application: "lotus-miner",
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Sha256:      hex.EncodeToString(h[:]),
+	})
+	_, err = installBinExec(context.Background(), job, string(params))
+	if err != nil {
+		t.Fatalf("cannot install bin: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(binStagingFile("lotus-miner"))
+	if err != nil || string(b) != string(payload) {
+		t.Errorf("unexpected staged bin %v: %v", string(b), err)
+	}
+}
+
+func TestInstallBinUnknownApplication(t *testing.T) {
+	params, _ := json.Marshal(installBinParams{Application: "bash"})
+	_, err := installBinExec(context.Background(), &Job{}, string(params))
+	if err == nil {
+		t.Errorf("unknown application should be rejected")
+	}
+}
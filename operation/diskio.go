@@ -0,0 +1,367 @@
+package operation
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	runtime "github.com/NpoolDevOps/fbc-devops-peer/runtime"
+	"golang.org/x/xerrors"
+)
+
+const diskTestDefaultDuration = 30
+
+var diskKernelErrors = []string{
+	"I/O error",
+	"critical medium error",
+	"Medium Error",
+	"timeout",
+	"reset controller",
+}
+
+var mountsFile = "/proc/self/mounts"
+
+var sysBlockDir = "/sys/block"
+
+type diskTestTarget struct {
+	disk     *runtime.DiskInfo
+	kind     string
+	minRead  float64
+	minWrite float64
+}
+
+// diskPartitions returns the kernel names of the partitions of the disk
+func diskPartitions(name string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(sysBlockDir, name))
+	if err != nil {
+		return nil, err
+	}
+	parts := []string{}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(sysBlockDir, name, entry.Name(), "partition")); err == nil {
+			parts = append(parts, entry.Name())
+		}
+	}
+	return parts, nil
+}
+
+// diskMounts returns the mount points of the kernel names, sources are
+// compared exactly after resolving /dev symlinks
+func diskMounts(names []string) ([]string, []string) {
+	sources := []string{}
+	points := []string{}
+
+	f, err := os.Open(mountsFile)
+	if err != nil {
+		return sources, points
+	}
+	defer f.Close()
+
+	devs := map[string]struct{}{}
+	for _, name := range names {
+		devs[fmt.Sprintf("/dev/%v", name)] = struct{}{}
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		source := fields[0]
+		if resolved, err := filepath.EvalSymlinks(source); err == nil {
+			source = resolved
+		}
+		if _, ok := devs[source]; ok {
+			sources = append(sources, fields[0])
+			points = append(points, fields[1])
+		}
+	}
+
+	return sources, points
+}
+
+// diskHolders returns the devices stacked on the kernel names, like lvm, md
+// or dm-crypt of a ceph osd
+func diskHolders(disk string, names []string) []string {
+	var holders []string
+	for _, name := range names {
+		dir := filepath.Join(sysBlockDir, disk, name, "holders")
+		if name == disk {
+			dir = filepath.Join(sysBlockDir, disk, "holders")
+		}
+		entries, _ := ioutil.ReadDir(dir)
+		for _, entry := range entries {
+			holders = append(holders, fmt.Sprintf("%v by %v", name, entry.Name()))
+		}
+	}
+	return holders
+}
+
+type diskUsage struct {
+	mounts  []string
+	holders []string
+}
+
+func (u *diskUsage) inUse() bool {
+	return 0 < len(u.mounts) || 0 < len(u.holders)
+}
+
+func (u *diskUsage) String() string {
+	return strings.Join(append(append([]string{}, u.mounts...), u.holders...), ",")
+}
+
+// diskInUse reports mounts and holders of the disk and its partitions, a
+// disk which cannot be inspected is an error since it may be in use
+func diskInUse(name string) (*diskUsage, error) {
+	parts, err := diskPartitions(name)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot inspect /dev/%v: %v", name, err)
+	}
+	names := append([]string{name}, parts...)
+
+	usage := &diskUsage{
+		holders: diskHolders(name, names),
+	}
+	sources, points := diskMounts(names)
+	for i, source := range sources {
+		usage.mounts = append(usage.mounts, fmt.Sprintf("%v on %v", source, points[i]))
+	}
+	return usage, nil
+}
+
+// isPartitionSuffix accepts sda1 and nvme0n1p1 style partition suffixes, disk
+// names ending with a digit separate the partition number with p
+func isPartitionSuffix(name string, suffix string) bool {
+	if c := name[len(name)-1]; '0' <= c && c <= '9' {
+		if !strings.HasPrefix(suffix, "p") {
+			return false
+		}
+		suffix = suffix[1:]
+	}
+	if suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || '9' < c {
+			return false
+		}
+	}
+	return true
+}
+
+// containsKernelName matches the disk or one of its partitions as a whole
+// word, so sda does not match sdaa
+func containsKernelName(msg string, disk string, kernelName string) bool {
+	for i := 0; i < len(msg); {
+		j := strings.Index(msg[i:], kernelName)
+		if j < 0 {
+			return false
+		}
+		start := i + j
+		end := start + len(kernelName)
+		i = start + 1
+		if 0 < start && isAlnum(msg[start-1]) {
+			continue
+		}
+		if !isAlnum(kernelName[len(kernelName)-1]) {
+			return true
+		}
+		suffixEnd := end
+		for suffixEnd < len(msg) && isAlnum(msg[suffixEnd]) {
+			suffixEnd++
+		}
+		if suffix := msg[end:suffixEnd]; suffix == "" || kernelName == disk && isPartitionSuffix(disk, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// diskKernelNames returns the names kernel messages use for the disk, nvme
+// timeouts are reported against the controller instead of the namespace
+func diskKernelNames(name string) []string {
+	names := []string{name}
+	if strings.HasPrefix(name, "nvme") {
+		if i := strings.Index(name[len("nvme"):], "n"); 0 < i {
+			names = append(names, name[:len("nvme")+i]+":")
+		}
+	}
+	return names
+}
+
+func newThresholdResult(name string, min float64, value float64, err error) acceptanceResult {
+	if err == nil && min <= value {
+		return acceptanceResult{
+			Result:      "OK",
+			TestName:    name,
+			Description: fmt.Sprintf("CHECK %v [%.1f MiB/s >= %.1f MiB/s]", name, value, min),
+		}
+	}
+	return acceptanceResult{
+		Result:      "ERROR",
+		TestName:    name,
+		Description: fmt.Sprintf("CHECK %v [%.1f MiB/s < %.1f MiB/s](%v)", name, value, min, err),
+	}
+}
+
+func fioDiskTest(ctx context.Context, dev string, write bool, duration time.Duration) (float64, error) {
+	args := []string{
+		"--name=acceptance", fmt.Sprintf("--filename=%v", dev),
+		"--bs=1M", "--direct=1", "--ioengine=libaio", "--iodepth=32",
+		fmt.Sprintf("--runtime=%v", int(duration.Seconds())), "--time_based",
+		"--output-format=json",
+	}
+	if write {
+		args = append(args, "--rw=write")
+	} else {
+		args = append(args, "--rw=read", "--readonly")
+	}
+
+	out, err := exec.CommandContext(ctx, "fio", args...).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	output := fioOutput{}
+	err = json.Unmarshal(out, &output)
+	if err != nil {
+		return 0, err
+	}
+	if len(output.Jobs) == 0 {
+		return 0, xerrors.Errorf("fio output without job")
+	}
+
+	if write {
+		return output.Jobs[0].Write.Bw / 1024, nil
+	}
+	return output.Jobs[0].Read.Bw / 1024, nil
+}
+
+func diskAcceptance(ctx context.Context, job *Job, p *acceptanceParams) []acceptanceResult {
+	results := []acceptanceResult{}
+
+	duration := time.Duration(p.DiskTestDuration) * time.Second
+	if duration <= 0 {
+		duration = diskTestDefaultDuration * time.Second
+	}
+
+	targets := []diskTestTarget{}
+	for _, disk := range runtime.GetNvmeList() {
+		targets = append(targets, diskTestTarget{disk: disk, kind: "NVME", minRead: p.NvmeMinRead, minWrite: p.NvmeMinWrite})
+	}
+	for _, disk := range runtime.GetHddList() {
+		targets = append(targets, diskTestTarget{disk: disk, kind: "HDD", minRead: p.HddMinRead, minWrite: p.HddMinWrite})
+	}
+
+	stop := make(chan struct{})
+	kernelDone := make(chan error)
+	var messages []string
+	go func() {
+		var err error
+		messages, err = watchKernelMessages(diskKernelErrors, true, stop)
+		if err != nil {
+			job.Logf("cannot watch kernel messages: %v", err)
+		}
+		kernelDone <- err
+	}()
+
+	tested := []diskTestTarget{}
+	for _, target := range targets {
+		dev := fmt.Sprintf("/dev/%v", target.disk.Name)
+
+		// force only allows reading a disk in use, it is never unmounted
+		usage, err := diskInUse(target.disk.Name)
+		if err == nil && usage.inUse() && !p.Force {
+			err = xerrors.Errorf("%v in use (%v), pass force to test read only", dev, usage)
+		}
+		if err != nil {
+			results = append(results, acceptanceResult{
+				Result:      "ERROR",
+				TestName:    fmt.Sprintf("%v %v In Use", target.kind, dev),
+				Description: err.Error(),
+			})
+			continue
+		}
+
+		job.Logf("read test %v", dev)
+		bw, err := fioDiskTest(ctx, dev, false, duration)
+		results = append(results, newThresholdResult(fmt.Sprintf("%v %v Read", target.kind, dev), target.minRead, bw, err))
+
+		if p.DiskWrite && usage.inUse() {
+			name := fmt.Sprintf("%v %v Write", target.kind, dev)
+			results = append(results, acceptanceResult{
+				Result:      "ERROR",
+				TestName:    name,
+				Description: fmt.Sprintf("CHECK %v [refuse destructive write](in use: %v)", name, usage),
+			})
+		} else if p.DiskWrite {
+			job.Logf("destructive write test %v", dev)
+			bw, err := fioDiskTest(ctx, dev, true, duration)
+			results = append(results, newThresholdResult(fmt.Sprintf("%v %v Write", target.kind, dev), target.minWrite, bw, err))
+		}
+
+		tested = append(tested, target)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	close(stop)
+	kernelErr := <-kernelDone
+
+	return append(results, diskKernelResults(tested, messages, kernelErr)...)
+}
+
+// diskKernelResults tells the kernel errors of each tested disk, a failed
+// watch tells nothing so every disk is reported unknown instead of OK
+func diskKernelResults(tested []diskTestTarget, messages []string, watchErr error) []acceptanceResult {
+	results := []acceptanceResult{}
+	for _, target := range tested {
+		dev := fmt.Sprintf("/dev/%v", target.disk.Name)
+		name := fmt.Sprintf("%v %v Kernel Error", target.kind, dev)
+		if watchErr != nil {
+			results = append(results, acceptanceResult{
+				Result:      "ERROR",
+				TestName:    name,
+				Description: fmt.Sprintf("CHECK %v [unknown](%v)", name, watchErr),
+			})
+			continue
+		}
+		errs := []string{}
+		for _, msg := range messages {
+			for _, kernelName := range diskKernelNames(target.disk.Name) {
+				if containsKernelName(msg, target.disk.Name, kernelName) {
+					errs = append(errs, msg)
+					break
+				}
+			}
+		}
+		if len(errs) == 0 {
+			results = append(results, acceptanceResult{
+				Result:      "OK",
+				TestName:    name,
+				Description: fmt.Sprintf("CHECK %v [no kernel error]", name),
+			})
+			continue
+		}
+		results = append(results, acceptanceResult{
+			Result:      "ERROR",
+			TestName:    name,
+			Description: fmt.Sprintf("CHECK %v [%v kernel errors](%v)", name, len(errs), errs[0]),
+		})
+	}
+
+	return results
+}
@@ -0,0 +1,135 @@
+package operation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	runtime "github.com/NpoolDevOps/fbc-devops-peer/runtime"
+	"golang.org/x/xerrors"
+)
+
+// useSysBlock builds a sysfs tree where each disk has the given partitions,
+// holders are paths like sdb or sdc/sdc1 under the tree which get a dm-0
+func useSysBlock(t *testing.T, disks map[string][]string, holders ...string) {
+	dir := filepath.Join(t.TempDir(), "block")
+	for disk, parts := range disks {
+		if err := os.MkdirAll(filepath.Join(dir, disk, "holders"), 0755); err != nil {
+			t.Fatalf("cannot create sysfs: %v", err)
+		}
+		for _, part := range parts {
+			if err := os.MkdirAll(filepath.Join(dir, disk, part, "holders"), 0755); err != nil {
+				t.Fatalf("cannot create sysfs: %v", err)
+			}
+			ioutil.WriteFile(filepath.Join(dir, disk, part, "partition"), []byte("1"), 0644)
+		}
+	}
+	for _, holder := range holders {
+		if err := os.MkdirAll(filepath.Join(dir, holder, "holders", "dm-0"), 0755); err != nil {
+			t.Fatalf("cannot create holder: %v", err)
+		}
+	}
+
+	oldSysBlockDir := sysBlockDir
+	sysBlockDir = dir
+	t.Cleanup(func() { sysBlockDir = oldSysBlockDir })
+}
+
+func TestDiskInUse(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mounts")
+	err := ioutil.WriteFile(file, []byte(`/dev/sda1 / ext4 rw 0 0
+/dev/sdaa /data ext4 rw 0 0
+/dev/nvme0n1p2 /opt/sharestorage xfs rw 0 0
+/dev/nvme0n10 /mnt xfs rw 0 0
+`), 0644)
+	if err != nil {
+		t.Fatalf("cannot write mounts: %v", err)
+	}
+	oldMountsFile := mountsFile
+	mountsFile = file
+	defer func() { mountsFile = oldMountsFile }()
+
+	useSysBlock(t, map[string][]string{
+		"sda":      {"sda1"},
+		"sdaa":     {},
+		"sdb":      {},
+		"sdc":      {"sdc1"},
+		"nvme0n1":  {"nvme0n1p1", "nvme0n1p2"},
+		"nvme0n10": {},
+	}, "sdb", "sdc/sdc1")
+
+	for name, expected := range map[string]diskUsage{
+		"sda":     {mounts: []string{"/dev/sda1 on /"}},
+		"nvme0n1": {mounts: []string{"/dev/nvme0n1p2 on /opt/sharestorage"}},
+		"sdb":     {holders: []string{"sdb by dm-0"}},
+		"sdc":     {holders: []string{"sdc1 by dm-0"}},
+	} {
+		usage, err := diskInUse(name)
+		if err != nil {
+			t.Errorf("cannot inspect %v: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(usage.mounts, expected.mounts) || !reflect.DeepEqual(usage.holders, expected.holders) {
+			t.Errorf("unexpected usage of %v: %+v", name, *usage)
+		}
+	}
+
+	if _, err := diskInUse("sdz"); err == nil {
+		t.Errorf("disk without sysfs should not pass as unused")
+	}
+}
+
+func TestDiskKernelNames(t *testing.T) {
+	names := diskKernelNames("nvme1n1")
+	if !reflect.DeepEqual(names, []string{"nvme1n1", "nvme1:"}) {
+		t.Errorf("unexpected kernel names %v", names)
+	}
+}
+
+func TestContainsKernelName(t *testing.T) {
+	for _, c := range []struct {
+		msg      string
+		disk     string
+		name     string
+		expected bool
+	}{
+		{"blk_update_request: I/O error, dev sda, sector 0", "sda", "sda", true},
+		{"Buffer I/O error on dev sda1, logical block 0", "sda", "sda", true},
+		{"blk_update_request: I/O error, dev sdaa, sector 0", "sda", "sda", false},
+		{"blk_update_request: I/O error, dev nvme0n10, sector 0", "nvme0n1", "nvme0n1", false},
+		{"blk_update_request: I/O error, dev nvme0n1p2, sector 0", "nvme0n1", "nvme0n1", true},
+		{"nvme nvme0: I/O 12 QID 3 timeout, reset controller", "nvme0n1", "nvme0:", true},
+		{"nvme nvme10: I/O 12 QID 3 timeout, reset controller", "nvme0n1", "nvme0:", false},
+	} {
+		if containsKernelName(c.msg, c.disk, c.name) != c.expected {
+			t.Errorf("%v in %q should be %v", c.name, c.msg, c.expected)
+		}
+	}
+}
+
+func TestDiskKernelResults(t *testing.T) {
+	tested := []diskTestTarget{
+		{disk: &runtime.DiskInfo{Name: "sda"}, kind: "HDD"},
+		{disk: &runtime.DiskInfo{Name: "nvme0n1"}, kind: "NVME"},
+	}
+	messages := []string{"blk_update_request: I/O error, dev sda, sector 0"}
+
+	results := diskKernelResults(tested, messages, nil)
+	if len(results) != 2 || results[0].Result != "ERROR" || results[1].Result != "OK" {
+		t.Errorf("unexpected kernel results %v", results)
+	}
+
+	// nothing was watched, no disk may pass
+	results = diskKernelResults(tested, nil, xerrors.Errorf("permission denied"))
+	for _, result := range results {
+		if result.Result != "ERROR" || !strings.Contains(result.Description, "unknown") {
+			t.Errorf("unexpected result %v without kernel watch", result)
+		}
+	}
+	if len(results) != 2 {
+		t.Errorf("every tested disk should have a kernel result, got %v", results)
+	}
+}
@@ -0,0 +1,204 @@
+package operation
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	sysfsNetDir    = "/sys/class/net"
+	procBondingDir = "/proc/net/bonding"
+)
+
+type bondingInfo struct {
+	Mode   string
+	Slaves map[string]string
+}
+
+func readSysfsNet(iface string, file string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(sysfsNetDir, iface, file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// physicalEthernets returns the interfaces backed by a device, which leaves
+// out loopback, bonds, bridges and other virtual interfaces
+func physicalEthernets() ([]string, error) {
+	files, err := ioutil.ReadDir(sysfsNetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	eths := []string{}
+	for _, file := range files {
+		_, err := filepath.EvalSymlinks(filepath.Join(sysfsNetDir, file.Name(), "device"))
+		if err == nil {
+			eths = append(eths, file.Name())
+		}
+	}
+	sort.Strings(eths)
+
+	return eths, nil
+}
+
+// parseSpeedMbps accepts 10000, 10000Mb/s, 10G and 10Gbit/s
+func parseSpeedMbps(speed string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(speed))
+	s = strings.TrimSuffix(s, "/S")
+	s = strings.TrimSuffix(s, "BIT")
+	s = strings.TrimSuffix(s, "B")
+
+	scale := 1
+	switch {
+	case strings.HasSuffix(s, "G"):
+		scale = 1000
+		s = strings.TrimSuffix(s, "G")
+	case strings.HasSuffix(s, "M"):
+		s = strings.TrimSuffix(s, "M")
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, xerrors.Errorf("invalid speed %v", speed)
+	}
+	return int(v * float64(scale)), nil
+}
+
+func parseBonding(content string) *bondingInfo {
+	info := &bondingInfo{
+		Slaves: map[string]string{},
+	}
+
+	slave := ""
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		s := strings.SplitN(scanner.Text(), ":", 2)
+		if len(s) < 2 {
+			continue
+		}
+		key := strings.TrimSpace(s[0])
+		val := strings.TrimSpace(s[1])
+
+		switch key {
+		case "Bonding Mode":
+			info.Mode = val
+		case "Slave Interface":
+			slave = val
+			info.Slaves[slave] = ""
+		case "MII Status":
+			if slave != "" {
+				info.Slaves[slave] = val
+			}
+		}
+	}
+
+	return info
+}
+
+// bondModeMatch compares the expected mode with sysfs mode like "802.3ad 4",
+// either the name or the number is accepted
+func bondModeMatch(expect string, mode string) bool {
+	for _, field := range strings.Fields(mode) {
+		if field == expect {
+			return true
+		}
+	}
+	return false
+}
+
+func newCheckResult(name string, ok bool, description string) acceptanceResult {
+	result := "ERROR"
+	if ok {
+		result = "OK"
+	}
+	return acceptanceResult{
+		Result:      result,
+		TestName:    name,
+		Description: fmt.Sprintf("CHECK %v [%v]", name, description),
+	}
+}
+
+func ethernetAcceptance(p *acceptanceParams) []acceptanceResult {
+	results := []acceptanceResult{}
+
+	eths, err := physicalEthernets()
+	if err != nil {
+		return append(results, newAcceptanceResult("Ethernet List", "", err.Error(), err))
+	}
+
+	expectSpeed := 0
+	if p.EthernetSpeed != "" {
+		expectSpeed, err = parseSpeedMbps(p.EthernetSpeed)
+		if err != nil {
+			results = append(results, newAcceptanceResult("Ethernet Speed", p.EthernetSpeed, "0", err))
+		}
+	}
+
+	for _, eth := range eths {
+		state, err := readSysfsNet(eth, "operstate")
+		results = append(results, newAcceptanceResult(fmt.Sprintf("Ethernet %v Link", eth), "up", state, err))
+
+		if 0 < expectSpeed {
+			name := fmt.Sprintf("Ethernet %v Speed", eth)
+			// Reading speed of a down link fails with EINVAL
+			speed, err := readSysfsNet(eth, "speed")
+			mbps, _ := strconv.Atoi(speed)
+			if err != nil {
+				results = append(results, newCheckResult(name, false, err.Error()))
+			} else {
+				results = append(results, newCheckResult(name, expectSpeed <= mbps,
+					fmt.Sprintf("%vMb/s >= %vMb/s", mbps, expectSpeed)))
+			}
+		}
+	}
+
+	bond := p.EthernetBondConfig.Bond
+	if bond == "" {
+		return results
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(procBondingDir, bond))
+	if err != nil {
+		return append(results, newCheckResult(fmt.Sprintf("Bond %v", bond), false, err.Error()))
+	}
+	info := parseBonding(string(b))
+	results = append(results, newCheckResult(fmt.Sprintf("Bond %v", bond), true, info.Mode))
+
+	if p.EthernetBondConfig.Mode != "" {
+		mode, err := readSysfsNet(bond, "bonding/mode")
+		if err != nil {
+			mode = err.Error()
+		}
+		results = append(results, newCheckResult(fmt.Sprintf("Bond %v Mode", bond),
+			err == nil && bondModeMatch(p.EthernetBondConfig.Mode, mode),
+			fmt.Sprintf("%v in %v", p.EthernetBondConfig.Mode, mode)))
+	}
+
+	slaves, _ := readSysfsNet(bond, "bonding/slaves")
+	for _, eth := range p.EthernetBondConfig.Ethernets {
+		name := fmt.Sprintf("Bond %v Slave %v", bond, eth)
+		enslaved := false
+		for _, slave := range strings.Fields(slaves) {
+			if slave == eth {
+				enslaved = true
+			}
+		}
+		status, ok := info.Slaves[eth]
+		if !enslaved || !ok {
+			results = append(results, newCheckResult(name, false, fmt.Sprintf("not enslaved in [%v]", slaves)))
+			continue
+		}
+		results = append(results, newCheckResult(name, status == "up", fmt.Sprintf("MII Status %v", status)))
+	}
+
+	return results
+}
@@ -0,0 +1,81 @@
+package operation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const bondingFixture = `Ethernet Channel Bonding Driver: v3.7.1 (April 27, 2011)
+
+Bonding Mode: IEEE 802.3ad Dynamic link aggregation
+Transmit Hash Policy: layer3+4 (1)
+MII Status: up
+
+Slave Interface: enp1s0f0
+MII Status: up
+Speed: 10000 Mbps
+
+Slave Interface: enp1s0f1
+MII Status: down
+Speed: Unknown
+`
+
+func TestParseSpeedMbps(t *testing.T) {
+	for speed, expect := range map[string]int{
+		"10000":    10000,
+		"1000Mb/s": 1000,
+		"10G":      10000,
+		"25Gbit/s": 25000,
+	} {
+		mbps, err := parseSpeedMbps(speed)
+		if err != nil || mbps != expect {
+			t.Errorf("parse %v: %v %v, expect %v", speed, mbps, err, expect)
+		}
+	}
+}
+
+func TestEthernetBondAcceptance(t *testing.T) {
+	root := t.TempDir()
+	oldSysfs, oldProc := sysfsNetDir, procBondingDir
+	sysfsNetDir = filepath.Join(root, "sys")
+	procBondingDir = filepath.Join(root, "proc")
+	defer func() { sysfsNetDir, procBondingDir = oldSysfs, oldProc }()
+
+	write := func(file, content string) {
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("cannot write %v: %v", file, err)
+		}
+	}
+	write(filepath.Join(procBondingDir, "bond0"), bondingFixture)
+	write(filepath.Join(sysfsNetDir, "bond0", "bonding", "mode"), "802.3ad 4\n")
+	write(filepath.Join(sysfsNetDir, "bond0", "bonding", "slaves"), "enp1s0f0 enp1s0f1\n")
+
+	p := &acceptanceParams{
+		EthernetBondConfig: bondConfig{
+			Bond:      "bond0",
+			Mode:      "802.3ad",
+			Ethernets: []string{"enp1s0f0", "enp1s0f1", "enp2s0"},
+		},
+	}
+
+	expects := map[string]string{
+		"Bond bond0":                "OK",
+		"Bond bond0 Mode":           "OK",
+		"Bond bond0 Slave enp1s0f0": "OK",
+		"Bond bond0 Slave enp1s0f1": "ERROR",
+		"Bond bond0 Slave enp2s0":   "ERROR",
+	}
+	results := ethernetAcceptance(p)
+	for _, result := range results {
+		if expect, ok := expects[result.TestName]; ok && expect != result.Result {
+			t.Errorf("%v: %v, expect %v", result.Description, result.Result, expect)
+		}
+		delete(expects, result.TestName)
+	}
+	if len(expects) != 0 {
+		t.Errorf("missing results %v", expects)
+	}
+}
@@ -0,0 +1,312 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	lifecycle "github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+const (
+	JobStatusRunning  = "running"
+	JobStatusDone     = "done"
+	JobStatusFailed   = "failed"
+	JobStatusCanceled = "canceled"
+)
+
+const (
+	maxJobRecords  = 200
+	maxJobLogLines = 1000
+)
+
+type JobRecord struct {
+	JobId    string      `json:"job_id"`
+	Action   string      `json:"action"`
+	Status   string      `json:"status"`
+	Progress int         `json:"progress"`
+	StartAt  int64       `json:"start_at"`
+	EndAt    int64       `json:"end_at"`
+	Result   interface{} `json:"result"`
+	Error    string      `json:"error"`
+	Logs     []string    `json:"logs"`
+}
+
+type Job struct {
+	record JobRecord
+	cancel context.CancelFunc
+	done   chan struct{}
+	mutex  sync.Mutex
+}
+
+type jobRunner func(ctx context.Context, job *Job) (interface{}, error)
+
+func (j *Job) Logf(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	log.Infof(log.Fields{}, "job %v: %v", j.record.JobId, line)
+
+	j.mutex.Lock()
+	j.record.Logs = append(j.record.Logs, fmt.Sprintf("%v %v", time.Now().Format(time.RFC3339), line))
+	if maxJobLogLines < len(j.record.Logs) {
+		j.record.Logs = j.record.Logs[len(j.record.Logs)-maxJobLogLines:]
+	}
+	j.mutex.Unlock()
+}
+
+func (j *Job) SetProgress(progress int) {
+	if progress < 0 {
+		progress = 0
+	}
+	if 100 < progress {
+		progress = 100
+	}
+	j.mutex.Lock()
+	j.record.Progress = progress
+	j.mutex.Unlock()
+}
+
+func (j *Job) Record() JobRecord {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	record := j.record
+	record.Logs = make([]string, len(j.record.Logs))
+	copy(record.Logs, j.record.Logs)
+	return record
+}
+
+// Done is closed when the job finished, nil for jobs loaded from disk
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+func (j *Job) finished() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.record.Status != JobStatusRunning
+}
+
+type JobManager struct {
+	ctx   context.Context
+	jobs  map[string]*Job
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewJobManager runs jobs until ctx is done, jobs still running then are
+// canceled and waited by lifecycle
+func NewJobManager(ctx context.Context, dir string) *JobManager {
+	m := &JobManager{
+		ctx:  ctx,
+		jobs: map[string]*Job{},
+		dir:  dir,
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot create job dir %v: %v", dir, err)
+	}
+
+	m.load()
+
+	return m
+}
+
+func (m *JobManager) recordFile(jobId string) string {
+	return filepath.Join(m.dir, fmt.Sprintf("%v.json", jobId))
+}
+
+func (m *JobManager) load() {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot read job dir %v: %v", m.dir, err)
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(m.dir, file.Name()))
+		if err != nil {
+			log.Errorf(log.Fields{}, "cannot read job record %v: %v", file.Name(), err)
+			continue
+		}
+
+		record := JobRecord{}
+		err = json.Unmarshal(b, &record)
+		if err != nil {
+			log.Errorf(log.Fields{}, "cannot parse job record %v: %v", file.Name(), err)
+			continue
+		}
+
+		job := &Job{record: record}
+		if record.Status == JobStatusRunning {
+			job.record.Status = JobStatusFailed
+			job.record.Error = "interrupted by peer restart"
+			m.persist(job)
+		}
+		m.jobs[record.JobId] = job
+	}
+
+	m.prune()
+}
+
+func (m *JobManager) persist(job *Job) {
+	m.writeRecord(job.Record())
+}
+
+func (m *JobManager) writeRecord(record JobRecord) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot marshal job %v: %v", record.JobId, err)
+		return
+	}
+	err = ioutil.WriteFile(m.recordFile(record.JobId), b, 0644)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot persist job %v: %v", record.JobId, err)
+	}
+}
+
+// prune drops the oldest finished jobs beyond maxJobRecords, caller holds the mutex
+func (m *JobManager) prune() {
+	if len(m.jobs) <= maxJobRecords {
+		return
+	}
+
+	records := []JobRecord{}
+	for _, job := range m.jobs {
+		if job.finished() {
+			records = append(records, job.Record())
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartAt < records[j].StartAt
+	})
+
+	for _, record := range records {
+		if len(m.jobs) <= maxJobRecords {
+			break
+		}
+		delete(m.jobs, record.JobId)
+		os.Remove(m.recordFile(record.JobId))
+	}
+}
+
+func (m *JobManager) Submit(action string, runner jobRunner) *Job {
+	ctx, cancel := context.WithCancel(m.ctx)
+
+	job := &Job{
+		record: JobRecord{
+			JobId:   uuid.New().String(),
+			Action:  action,
+			Status:  JobStatusRunning,
+			StartAt: time.Now().Unix(),
+			Logs:    []string{},
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	m.mutex.Lock()
+	m.jobs[job.record.JobId] = job
+	m.prune()
+	m.mutex.Unlock()
+
+	m.persist(job)
+	job.Logf("start %v", action)
+
+	lifecycle.Go(func() {
+		result, err := runner(ctx, job)
+		ctxErr := ctx.Err()
+		cancel()
+
+		status := JobStatusDone
+		switch {
+		case ctxErr != nil:
+			status = JobStatusCanceled
+			err = ctxErr
+		case err != nil:
+			status = JobStatusFailed
+		}
+		job.Logf("%v %v", action, status)
+
+		// Persist before the job becomes visible as finished
+		job.mutex.Lock()
+		job.record.Result = result
+		job.record.EndAt = time.Now().Unix()
+		job.record.Status = status
+		if err != nil {
+			job.record.Error = err.Error()
+		} else {
+			job.record.Progress = 100
+		}
+		m.writeRecord(job.record)
+		job.mutex.Unlock()
+		close(job.done)
+	})
+
+	return job
+}
+
+func (m *JobManager) job(jobId string) (*Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[jobId]
+	if !ok {
+		return nil, xerrors.Errorf("unknow job %v", jobId)
+	}
+	return job, nil
+}
+
+func (m *JobManager) Get(jobId string) (JobRecord, error) {
+	job, err := m.job(jobId)
+	if err != nil {
+		return JobRecord{}, err
+	}
+	return job.Record(), nil
+}
+
+// List returns job records without result and logs, newest first
+func (m *JobManager) List() []JobRecord {
+	m.mutex.Lock()
+	records := []JobRecord{}
+	for _, job := range m.jobs {
+		record := job.Record()
+		record.Result = nil
+		record.Logs = nil
+		records = append(records, record)
+	}
+	m.mutex.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartAt > records[j].StartAt
+	})
+
+	return records
+}
+
+func (m *JobManager) Cancel(jobId string) error {
+	job, err := m.job(jobId)
+	if err != nil {
+		return err
+	}
+	if job.finished() || job.cancel == nil {
+		return xerrors.Errorf("job %v already finished", jobId)
+	}
+	job.Logf("cancel requested")
+	job.cancel()
+	return nil
+}
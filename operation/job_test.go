@@ -0,0 +1,113 @@
+package operation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NpoolDevOps/fbc-devops-peer/types"
+)
+
+func waitJob(t *testing.T, m *JobManager, jobId string) JobRecord {
+	for i := 0; i < 100; i++ {
+		record, err := m.Get(jobId)
+		if err != nil {
+			t.Fatalf("cannot get job %v: %v", jobId, err)
+		}
+		if record.Status != JobStatusRunning {
+			return record
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %v not finished", jobId)
+	return JobRecord{}
+}
+
+func TestJobManagerPersist(t *testing.T) {
+	dir := t.TempDir()
+	m := NewJobManager(context.Background(), dir)
+
+	job := m.Submit("test", func(ctx context.Context, job *Job) (interface{}, error) {
+		job.Logf("working")
+		return "result", nil
+	})
+	record := waitJob(t, m, job.Record().JobId)
+	if record.Status != JobStatusDone || record.Result != "result" {
+		t.Fatalf("unexpected job record %v", record)
+	}
+
+	m = NewJobManager(context.Background(), dir)
+	record, err := m.Get(record.JobId)
+	if err != nil {
+		t.Fatalf("job record not persisted: %v", err)
+	}
+	if record.Status != JobStatusDone || len(record.Logs) == 0 {
+		t.Errorf("unexpected persisted record %v", record)
+	}
+}
+
+func TestJobManagerCancel(t *testing.T) {
+	m := NewJobManager(context.Background(), t.TempDir())
+
+	job := m.Submit("test", func(ctx context.Context, job *Job) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	jobId := job.Record().JobId
+
+	err := m.Cancel(jobId)
+	if err != nil {
+		t.Fatalf("cannot cancel job: %v", err)
+	}
+	record := waitJob(t, m, jobId)
+	if record.Status != JobStatusCanceled {
+		t.Errorf("job status %v, expect %v", record.Status, JobStatusCanceled)
+	}
+
+	if m.Cancel(jobId) == nil {
+		t.Errorf("finished job should not be canceled again")
+	}
+}
+
+func TestJobDone(t *testing.T) {
+	m := NewJobManager(context.Background(), t.TempDir())
+
+	job := m.Submit("test", func(ctx context.Context, job *Job) (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+		return "result", nil
+	})
+	<-job.Done()
+
+	record := job.Record()
+	if record.Status != JobStatusDone || record.Result != "result" {
+		t.Errorf("job record %v is not final when done", record)
+	}
+}
+
+func TestOperationExecUnknownAction(t *testing.T) {
+	op := &Operation{jobs: NewJobManager(context.Background(), t.TempDir())}
+
+	_, err := op.Exec(types.OperationAction{Action: "unknown"})
+	if err == nil {
+		t.Errorf("unknown action should fail")
+	}
+	if len(op.jobs.List()) != 0 {
+		t.Errorf("unknown action should not submit a job")
+	}
+}
+
+func TestJobManagerShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewJobManager(ctx, t.TempDir())
+
+	job := m.Submit("test", func(ctx context.Context, job *Job) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cancel()
+	<-job.Done()
+
+	if status := job.Record().Status; status != JobStatusCanceled {
+		t.Errorf("job status %v after shutdown, expect %v", status, JobStatusCanceled)
+	}
+}
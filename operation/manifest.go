@@ -0,0 +1,419 @@
+package operation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var manifestRoot = "/"
+
+const (
+	manifestSysctlDir  = "/etc/sysctl.d"
+	manifestLimitsDir  = "/etc/security/limits.d"
+	manifestProfileDir = "/etc/profile.d"
+	manifestUnitDir    = "/etc/systemd/system"
+)
+
+var (
+	envKeyPattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	sysctlKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+([./][A-Za-z0-9_-]+)*$`)
+	// domain of limits.conf: *, %, user, @group, %group, uid or gid range
+	ulimitDomainPattern = regexp.MustCompile(`^(\*|%|[@%]?[A-Za-z_][A-Za-z0-9_.-]*\$?|@?[0-9]*:[0-9]*)$`)
+	ulimitValuePattern  = regexp.MustCompile(`^(-?[0-9]+|unlimited|infinity)$`)
+	// debian package name, never an option of apt-get
+	packagePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+)
+
+var ulimitTypes = map[string]bool{"soft": true, "hard": true, "-": true}
+
+var ulimitItems = map[string]bool{
+	"core": true, "data": true, "fsize": true, "memlock": true, "nofile": true,
+	"rss": true, "stack": true, "cpu": true, "nproc": true, "as": true,
+	"maxlogins": true, "maxsyslogins": true, "nonewprivs": true, "priority": true,
+	"locks": true, "sigpending": true, "msgqueue": true, "nice": true,
+	"rtprio": true, "chroot": true,
+}
+
+type manifestUlimit struct {
+	Domain string `json:"domain"`
+	Type   string `json:"type"`
+	Item   string `json:"item"`
+	Value  string `json:"value"`
+}
+
+type manifestEnvFile struct {
+	Name string            `json:"name"`
+	Envs map[string]string `json:"envs"`
+}
+
+type manifestUnit struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Enable  bool   `json:"enable"`
+	Restart bool   `json:"restart"`
+}
+
+type roleManifest struct {
+	Role     string            `json:"role"`
+	Packages []string          `json:"packages"`
+	Sysctl   map[string]string `json:"sysctl"`
+	Ulimits  []manifestUlimit  `json:"ulimits"`
+	EnvFiles []manifestEnvFile `json:"env_files"`
+	Units    []manifestUnit    `json:"units"`
+}
+
+type manifestParams struct {
+	Manifest roleManifest `json:"manifest"`
+	DryRun   bool         `json:"dry_run"`
+}
+
+type manifestStep struct {
+	Kind    string `json:"kind"`
+	Target  string `json:"target"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Changed bool   `json:"changed"`
+	Applied bool   `json:"applied"`
+	Error   string `json:"error"`
+}
+
+type manifestResult struct {
+	Role   string         `json:"role"`
+	DryRun bool           `json:"dry_run"`
+	Steps  []manifestStep `json:"steps"`
+}
+
+func manifestPath(path string) string {
+	return filepath.Join(manifestRoot, path)
+}
+
+func readManifestFile(path string) string {
+	b, err := ioutil.ReadFile(manifestPath(path))
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func writeManifestFile(path string, content string, perm os.FileMode) error {
+	file := manifestPath(path)
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".new"
+	os.Remove(tmp)
+	err = ioutil.WriteFile(tmp, []byte(content), perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func checkManifestName(name string) error {
+	if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+		return xerrors.Errorf("invalid file name %v", name)
+	}
+	return nil
+}
+
+// shellQuote quotes val in single quotes so the profile never expands it
+func shellQuote(val string) string {
+	return "'" + strings.ReplaceAll(val, "'", `'\''`) + "'"
+}
+
+func hasControl(val string) bool {
+	return strings.IndexFunc(val, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0
+}
+
+func (l *manifestUlimit) validate() error {
+	if !ulimitDomainPattern.MatchString(l.Domain) {
+		return xerrors.Errorf("invalid ulimit domain %q", l.Domain)
+	}
+	if !ulimitTypes[l.Type] {
+		return xerrors.Errorf("invalid ulimit type %q", l.Type)
+	}
+	if !ulimitItems[l.Item] {
+		return xerrors.Errorf("invalid ulimit item %q", l.Item)
+	}
+	if !ulimitValuePattern.MatchString(l.Value) {
+		return xerrors.Errorf("invalid ulimit value %q of %v", l.Value, l.Item)
+	}
+	return nil
+}
+
+func (m *roleManifest) validate() error {
+	if m.Role == "" {
+		return xerrors.Errorf("role is must")
+	}
+	if err := checkManifestName(m.Role); err != nil {
+		return err
+	}
+	for _, pkg := range m.Packages {
+		if !packagePattern.MatchString(pkg) {
+			return xerrors.Errorf("invalid package %q", pkg)
+		}
+	}
+	for key, val := range m.Sysctl {
+		if !sysctlKeyPattern.MatchString(key) {
+			return xerrors.Errorf("invalid sysctl key %q", key)
+		}
+		if strings.TrimSpace(val) == "" || hasControl(val) {
+			return xerrors.Errorf("invalid sysctl value %q of %v", val, key)
+		}
+	}
+	for _, limit := range m.Ulimits {
+		if err := limit.validate(); err != nil {
+			return err
+		}
+	}
+	for _, envFile := range m.EnvFiles {
+		if err := checkManifestName(envFile.Name); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(envFile.Name, ".sh") {
+			return xerrors.Errorf("env file %v should be a .sh file", envFile.Name)
+		}
+		for key := range envFile.Envs {
+			if !envKeyPattern.MatchString(key) {
+				return xerrors.Errorf("invalid env key %q in %v", key, envFile.Name)
+			}
+		}
+	}
+	for _, unit := range m.Units {
+		if err := checkManifestName(unit.Name); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(unit.Name, ".service") {
+			return xerrors.Errorf("unit %v should be a .service file", unit.Name)
+		}
+	}
+	return nil
+}
+
+type manifestApplier struct {
+	ctx    context.Context
+	job    *Job
+	dryRun bool
+	result *manifestResult
+}
+
+func (a *manifestApplier) run(name string, args ...string) error {
+	return a.runEnv(nil, name, args...)
+}
+
+func (a *manifestApplier) runEnv(env []string, name string, args ...string) error {
+	cmd := exec.CommandContext(a.ctx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("%v %v: %v: %v", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// step records the diff of one target and applies it when changed
+func (a *manifestApplier) step(kind, target, before, after string, apply func() error) bool {
+	s := manifestStep{
+		Kind:    kind,
+		Target:  target,
+		Before:  before,
+		After:   after,
+		Changed: before != after,
+	}
+
+	if s.Changed && !a.dryRun {
+		err := apply()
+		if err != nil {
+			s.Error = err.Error()
+			a.job.Logf("%v %v fail: %v", kind, target, err)
+		} else {
+			s.Applied = true
+			a.job.Logf("%v %v applied", kind, target)
+		}
+	}
+
+	a.result.Steps = append(a.result.Steps, s)
+	return s.Changed
+}
+
+func (a *manifestApplier) packages(packages []string) {
+	for _, pkg := range packages {
+		before := "absent"
+		out, err := exec.CommandContext(a.ctx, "dpkg-query", "-W", "-f=${Status}", pkg).Output()
+		if err == nil && strings.Contains(string(out), "install ok installed") {
+			before = "installed"
+		}
+		a.step("package", pkg, before, "installed", func() error {
+			return a.runEnv([]string{"DEBIAN_FRONTEND=noninteractive"}, "apt-get", "install", "-y", "--", pkg)
+		})
+	}
+}
+
+func (a *manifestApplier) sysctl(role string, sysctl map[string]string) {
+	if len(sysctl) == 0 {
+		return
+	}
+
+	var content bytes.Buffer
+	for _, key := range sortedKeys(sysctl) {
+		val := strings.Join(strings.Fields(sysctl[key]), " ")
+		fmt.Fprintf(&content, "%v = %v\n", key, val)
+
+		b, _ := ioutil.ReadFile(manifestPath(filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))))
+		before := strings.Join(strings.Fields(string(b)), " ")
+		a.step("sysctl", key, before, val, func() error {
+			return a.run("sysctl", "-w", fmt.Sprintf("%v=%v", key, val))
+		})
+	}
+
+	file := filepath.Join(manifestSysctlDir, fmt.Sprintf("99-fbc-devops-%v.conf", role))
+	a.step("file", file, readManifestFile(file), content.String(), func() error {
+		return writeManifestFile(file, content.String(), 0644)
+	})
+}
+
+func (a *manifestApplier) ulimits(role string, ulimits []manifestUlimit) {
+	if len(ulimits) == 0 {
+		return
+	}
+
+	var content bytes.Buffer
+	for _, limit := range ulimits {
+		fmt.Fprintf(&content, "%v %v %v %v\n", limit.Domain, limit.Type, limit.Item, limit.Value)
+	}
+
+	file := filepath.Join(manifestLimitsDir, fmt.Sprintf("fbc-devops-%v.conf", role))
+	a.step("file", file, readManifestFile(file), content.String(), func() error {
+		return writeManifestFile(file, content.String(), 0644)
+	})
+}
+
+func (a *manifestApplier) envFiles(envFiles []manifestEnvFile) {
+	for _, envFile := range envFiles {
+		var content bytes.Buffer
+		for _, key := range sortedKeys(envFile.Envs) {
+			fmt.Fprintf(&content, "export %v=%v\n", key, shellQuote(envFile.Envs[key]))
+		}
+
+		// env files may hold api tokens, only root reads them
+		file := filepath.Join(manifestProfileDir, envFile.Name)
+		changed := a.step("file", file, readManifestFile(file), content.String(), func() error {
+			return writeManifestFile(file, content.String(), 0600)
+		})
+		if fi, err := os.Stat(manifestPath(file)); err == nil && !changed && fi.Mode().Perm() != 0600 {
+			a.step("mode", file, fmt.Sprintf("%#o", fi.Mode().Perm()), "0600", func() error {
+				return os.Chmod(manifestPath(file), 0600)
+			})
+		}
+	}
+}
+
+func (a *manifestApplier) units(units []manifestUnit) {
+	changed := map[string]bool{}
+	for _, unit := range units {
+		file := filepath.Join(manifestUnitDir, unit.Name)
+		changed[unit.Name] = a.step("file", file, readManifestFile(file), unit.Content, func() error {
+			return writeManifestFile(file, unit.Content, 0644)
+		})
+	}
+
+	reload := false
+	for _, c := range changed {
+		reload = reload || c
+	}
+	if reload {
+		a.step("systemd", "daemon-reload", "", "reloaded", func() error {
+			return a.run("systemctl", "daemon-reload")
+		})
+	}
+
+	for _, unit := range units {
+		if unit.Enable {
+			before := "disabled"
+			out, _ := exec.CommandContext(a.ctx, "systemctl", "is-enabled", unit.Name).Output()
+			if strings.TrimSpace(string(out)) == "enabled" {
+				before = "enabled"
+			}
+			a.step("systemd", unit.Name, before, "enabled", func() error {
+				return a.run("systemctl", "enable", unit.Name)
+			})
+		}
+		if unit.Restart && changed[unit.Name] {
+			a.step("systemd", unit.Name, "", "restarted", func() error {
+				return a.run("systemctl", "restart", unit.Name)
+			})
+		}
+	}
+}
+
+func manifestExec(ctx context.Context, job *Job, params string, install bool) (interface{}, error) {
+	p := manifestParams{}
+	err := json.Unmarshal([]byte(params), &p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.Manifest.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &manifestResult{
+		Role:   p.Manifest.Role,
+		DryRun: p.DryRun,
+		Steps:  []manifestStep{},
+	}
+	a := &manifestApplier{
+		ctx:    ctx,
+		job:    job,
+		dryRun: p.DryRun,
+		result: result,
+	}
+
+	// Preinstall prepares the host, install deploys what the role runs
+	if install {
+		a.envFiles(p.Manifest.EnvFiles)
+		job.SetProgress(50)
+		a.units(p.Manifest.Units)
+	} else {
+		a.packages(p.Manifest.Packages)
+		job.SetProgress(50)
+		a.sysctl(p.Manifest.Role, p.Manifest.Sysctl)
+		a.ulimits(p.Manifest.Role, p.Manifest.Ulimits)
+	}
+
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+
+	for _, s := range result.Steps {
+		if s.Error != "" {
+			return result, xerrors.Errorf("%v %v fail: %v", s.Kind, s.Target, s.Error)
+		}
+	}
+
+	return result, nil
+}
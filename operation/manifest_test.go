@@ -0,0 +1,119 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestInstallEnvFiles(t *testing.T) {
+	root := t.TempDir()
+	oldRoot := manifestRoot
+	manifestRoot = root
+	defer func() { manifestRoot = oldRoot }()
+
+	p := manifestParams{
+		Manifest: roleManifest{
+			Role: "fullnode",
+			EnvFiles: []manifestEnvFile{{
+				Name: "fullnode-api-info.sh",
+				Envs: map[string]string{"FULLNODE_API_INFO": "token:/ip4/10.0.0.1/tcp/1234/http"},
+			}},
+		},
+		DryRun: true,
+	}
+	file := filepath.Join(root, "etc/profile.d/fullnode-api-info.sh")
+
+	run := func() manifestResult {
+		params, _ := json.Marshal(p)
+		result, err := manifestExec(context.Background(), &Job{}, string(params), true)
+		if err != nil {
+			t.Fatalf("cannot apply manifest: %v", err)
+		}
+		return *result.(*manifestResult)
+	}
+
+	result := run()
+	if len(result.Steps) != 1 || !result.Steps[0].Changed || result.Steps[0].Applied {
+		t.Fatalf("unexpected dry run steps %v", result.Steps)
+	}
+	if _, err := ioutil.ReadFile(file); err == nil {
+		t.Fatalf("dry run should not write %v", file)
+	}
+
+	p.DryRun = false
+	result = run()
+	if !result.Steps[0].Applied {
+		t.Fatalf("env file not applied: %v", result.Steps)
+	}
+	b, _ := ioutil.ReadFile(file)
+	if string(b) != "export FULLNODE_API_INFO='token:/ip4/10.0.0.1/tcp/1234/http'\n" {
+		t.Errorf("unexpected env file %q", string(b))
+	}
+	if fi, _ := os.Stat(file); fi.Mode().Perm() != 0600 {
+		t.Errorf("env file mode %#o, expect 0600", fi.Mode().Perm())
+	}
+
+	result = run()
+	if len(result.Steps) != 1 || result.Steps[0].Changed {
+		t.Errorf("second apply should not change anything: %v", result.Steps)
+	}
+
+	// an env file left readable by an older peer is tightened
+	os.Chmod(file, 0644)
+	result = run()
+	if len(result.Steps) != 2 || result.Steps[1].Kind != "mode" || !result.Steps[1].Applied {
+		t.Fatalf("unexpected mode steps %v", result.Steps)
+	}
+	if fi, _ := os.Stat(file); fi.Mode().Perm() != 0600 {
+		t.Errorf("env file mode %#o after apply, expect 0600", fi.Mode().Perm())
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	m := roleManifest{
+		Role:     "miner",
+		EnvFiles: []manifestEnvFile{{Name: "../passwd"}},
+	}
+	if m.validate() == nil {
+		t.Errorf("env file outside profile.d should be rejected")
+	}
+
+	tests := []struct {
+		name     string
+		manifest roleManifest
+		valid    bool
+	}{
+		{"env", roleManifest{EnvFiles: []manifestEnvFile{{Name: "a.sh", Envs: map[string]string{"LOTUS_PATH": "$(rm -rf /)"}}}}, true},
+		{"env key", roleManifest{EnvFiles: []manifestEnvFile{{Name: "a.sh", Envs: map[string]string{"A;rm -rf /;B": "1"}}}}, false},
+		{"env key digit", roleManifest{EnvFiles: []manifestEnvFile{{Name: "a.sh", Envs: map[string]string{"1A": "1"}}}}, false},
+		{"sysctl", roleManifest{Sysctl: map[string]string{"vm.swappiness": "10", "net/ipv4/tcp_rmem": "4096 87380 6291456"}}, true},
+		{"sysctl key", roleManifest{Sysctl: map[string]string{"vm/../../etc": "1"}}, false},
+		{"sysctl value newline", roleManifest{Sysctl: map[string]string{"vm.swappiness": "10\nkernel.panic = 1"}}, false},
+		{"ulimit", roleManifest{Ulimits: []manifestUlimit{{"*", "-", "nofile", "1048576"}, {"@lotus", "soft", "memlock", "unlimited"}}}, true},
+		{"ulimit domain", roleManifest{Ulimits: []manifestUlimit{{"* hard core 0\nroot", "-", "nofile", "1"}}}, false},
+		{"ulimit type", roleManifest{Ulimits: []manifestUlimit{{"*", "both", "nofile", "1"}}}, false},
+		{"ulimit item", roleManifest{Ulimits: []manifestUlimit{{"*", "-", "files", "1"}}}, false},
+		{"ulimit value", roleManifest{Ulimits: []manifestUlimit{{"*", "-", "nofile", "1 2"}}}, false},
+		{"package", roleManifest{Packages: []string{"nfs-common", "libhwloc15", "g++", "python3.8"}}, true},
+		{"package option", roleManifest{Packages: []string{"-oAPT::Update::Pre-Invoke::=id"}}, false},
+		{"package space", roleManifest{Packages: []string{"curl --allow-unauthenticated"}}, false},
+		{"package upper", roleManifest{Packages: []string{"Curl"}}, false},
+	}
+	for _, test := range tests {
+		test.manifest.Role = "miner"
+		err := test.manifest.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%v: validate got %v, expect valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if q := shellQuote("it's $HOME"); q != `'it'\''s $HOME'` {
+		t.Errorf("unexpected quoted value %v", q)
+	}
+}
@@ -1,16 +1,32 @@
 package operation
 
 import (
+	"context"
 	"encoding/json"
+	"path/filepath"
+
 	types "github.com/NpoolDevOps/fbc-devops-peer/types"
 	"golang.org/x/xerrors"
 )
 
+type OperationConfig struct {
+	Context         context.Context
+	FullnodeApiHost func() (string, error)
+	ApiInfo         func(role string) (string, error)
+}
+
 type Operation struct {
+	jobs            *JobManager
+	fullnodeApiHost func() (string, error)
+	apiInfo         func(role string) (string, error)
 }
 
-func NewOperation() *Operation {
-	op := &Operation{}
+func NewOperation(config *OperationConfig) *Operation {
+	op := &Operation{
+		jobs:            NewJobManager(config.Context, filepath.Join(types.PeerStateDir(), "jobs")),
+		fullnodeApiHost: config.FullnodeApiHost,
+		apiInfo:         config.ApiInfo,
+	}
 	return op
 }
 
@@ -22,27 +38,117 @@ const (
 	ActionInstall      = "install"
 	ActionInstallBin   = "installbin"
 	ActionReleaseBin   = "releasebin"
+	ActionRollbackBin  = "rollbackbin"
 )
 
-func (op *Operation) onAcceptance(params string) (interface{}, error) {
-	return acceptanceExec(params)
+func (op *Operation) onAcceptance(ctx context.Context, job *Job, params string) (interface{}, error) {
+	return acceptanceExec(ctx, job, params)
 }
 
-func (op *Operation) Exec(action types.OperationAction) (interface{}, error) {
+func (op *Operation) onBaselineTest(ctx context.Context, job *Job, params string) (interface{}, error) {
+	return baselineExec(ctx, job, params)
+}
+
+func (op *Operation) onPresureTest(ctx context.Context, job *Job, params string) (interface{}, error) {
+	return presureExec(ctx, job, params)
+}
+
+func (op *Operation) onPreinstall(ctx context.Context, job *Job, params string) (interface{}, error) {
+	return manifestExec(ctx, job, params, false)
+}
+
+func (op *Operation) onInstall(ctx context.Context, job *Job, params string) (interface{}, error) {
+	return manifestExec(ctx, job, params, true)
+}
+
+func (op *Operation) onInstallBin(ctx context.Context, job *Job, params string) (interface{}, error) {
+	return installBinExec(ctx, job, params)
+}
+
+func (op *Operation) onReleaseBin(ctx context.Context, job *Job, params string) (interface{}, error) {
+	return releaseBinExec(ctx, job, params)
+}
+
+func (op *Operation) onRollbackBin(ctx context.Context, job *Job, params string) (interface{}, error) {
+	return op.rollbackBinExec(ctx, job, params)
+}
+
+func (op *Operation) Jobs() *JobManager {
+	return op.jobs
+}
+
+func (op *Operation) runner(action types.OperationAction) (jobRunner, error) {
 	b, _ := json.Marshal(action.Params)
+	params := string(b)
+
+	var runner jobRunner
 
 	switch action.Action {
 	case ActionAcceptance:
-		return op.onAcceptance(string(b))
+		runner = func(ctx context.Context, job *Job) (interface{}, error) {
+			return op.onAcceptance(ctx, job, params)
+		}
 	case ActionBaselineTest:
+		runner = func(ctx context.Context, job *Job) (interface{}, error) {
+			return op.onBaselineTest(ctx, job, params)
+		}
 	case ActionPresureTest:
+		runner = func(ctx context.Context, job *Job) (interface{}, error) {
+			return op.onPresureTest(ctx, job, params)
+		}
 	case ActionPreinstall:
+		runner = func(ctx context.Context, job *Job) (interface{}, error) {
+			return op.onPreinstall(ctx, job, params)
+		}
 	case ActionInstall:
+		runner = func(ctx context.Context, job *Job) (interface{}, error) {
+			return op.onInstall(ctx, job, params)
+		}
 	case ActionInstallBin:
+		runner = func(ctx context.Context, job *Job) (interface{}, error) {
+			return op.onInstallBin(ctx, job, params)
+		}
 	case ActionReleaseBin:
+		runner = func(ctx context.Context, job *Job) (interface{}, error) {
+			return op.onReleaseBin(ctx, job, params)
+		}
+	case ActionRollbackBin:
+		runner = func(ctx context.Context, job *Job) (interface{}, error) {
+			return op.onRollbackBin(ctx, job, params)
+		}
 	default:
 		return nil, xerrors.Errorf("unknow action %v", action.Action)
 	}
 
-	return nil, xerrors.Errorf("unknow action %v", action.Action)
+	return runner, nil
+}
+
+// Exec runs the action and returns its result like the v0 operation api
+// always did, the run is still recorded by the job manager
+func (op *Operation) Exec(action types.OperationAction) (interface{}, error) {
+	runner, err := op.runner(action)
+	if err != nil {
+		return nil, err
+	}
+
+	job := op.jobs.Submit(action.Action, runner)
+	<-job.Done()
+
+	record := job.Record()
+	if record.Status != JobStatusDone {
+		return nil, xerrors.Errorf("%v %v: %v", action.Action, record.Status, record.Error)
+	}
+	return record.Result, nil
+}
+
+// Submit submits the action to the job manager and returns the job record
+// immediately, the result is fetched later by job id
+func (op *Operation) Submit(action types.OperationAction) (*JobRecord, error) {
+	runner, err := op.runner(action)
+	if err != nil {
+		return nil, err
+	}
+
+	record := op.jobs.Submit(action.Action, runner).Record()
+	return &record, nil
 }
@@ -0,0 +1,172 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	runtime "github.com/NpoolDevOps/fbc-devops-peer/runtime"
+	"golang.org/x/xerrors"
+)
+
+const (
+	presureDefaultDuration      = 3600
+	presureDefaultMemoryPercent = 80
+)
+
+type presureParams struct {
+	Duration      int      `json:"duration"`
+	Cpu           bool     `json:"cpu"`
+	Memory        bool     `json:"memory"`
+	MemoryPercent int      `json:"memory_percent"`
+	Disks         bool     `json:"disks"`
+	KernelErrors  []string `json:"kernel_errors"`
+}
+
+func stressCommand(ctx context.Context, name string, duration time.Duration, args ...string) acceptanceResult {
+	args = append(args, "--timeout", fmt.Sprintf("%vs", int(duration.Seconds())), "--metrics-brief")
+	out, err := exec.CommandContext(ctx, "stress-ng", args...).CombinedOutput()
+	if err != nil {
+		log.Errorf(log.Fields{}, "stress %v fail: %v: %v", name, err, string(out))
+		return newAcceptanceResult(name, "passed", err.Error(), err)
+	}
+	return newAcceptanceResult(name, "passed", "passed", nil)
+}
+
+func stressDisk(ctx context.Context, disk *runtime.DiskInfo, duration time.Duration) acceptanceResult {
+	dev := fmt.Sprintf("/dev/%v", disk.Name)
+	name := fmt.Sprintf("Stress Disk %v", dev)
+
+	out, err := exec.CommandContext(ctx, "fio",
+		"--name=presure", fmt.Sprintf("--filename=%v", dev),
+		"--rw=randread", "--bs=128k", "--direct=1", "--ioengine=libaio",
+		"--iodepth=32", "--readonly",
+		fmt.Sprintf("--runtime=%v", int(duration.Seconds())), "--time_based",
+	).CombinedOutput()
+	if err != nil {
+		log.Errorf(log.Fields{}, "stress %v fail: %v: %v", dev, err, string(out))
+		return newAcceptanceResult(name, "passed", err.Error(), err)
+	}
+	return newAcceptanceResult(name, "passed", "passed", nil)
+}
+
+func presureExec(ctx context.Context, job *Job, params string) (interface{}, error) {
+	p := presureParams{}
+	err := json.Unmarshal([]byte(params), &p)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Duration <= 0 {
+		p.Duration = presureDefaultDuration
+	}
+	if p.MemoryPercent <= 0 || 95 < p.MemoryPercent {
+		p.MemoryPercent = presureDefaultMemoryPercent
+	}
+	if !p.Cpu && !p.Memory && !p.Disks {
+		p.Cpu = true
+		p.Memory = true
+		p.Disks = true
+	}
+
+	duration := time.Duration(p.Duration) * time.Second
+
+	specs := p.KernelErrors
+	if len(specs) == 0 {
+		specs = defaultKernelErrors
+	}
+
+	results := acceptanceResults{
+		Results: []acceptanceResult{},
+	}
+	var mutex sync.Mutex
+	addResult := func(result acceptanceResult) {
+		mutex.Lock()
+		results.Results = append(results.Results, result)
+		mutex.Unlock()
+	}
+
+	stop := make(chan struct{})
+	kernelDone := make(chan []acceptanceResult)
+	go func() {
+		kernelDone <- watchKernelErrors(specs, true, stop)
+	}()
+
+	job.Logf("presure test cpu %v memory %v disks %v for %v", p.Cpu, p.Memory, p.Disks, duration)
+
+	var wg sync.WaitGroup
+
+	if p.Cpu {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addResult(stressCommand(ctx, "Stress CPU", duration, "--cpu", "0"))
+		}()
+	}
+
+	if p.Memory {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addResult(stressCommand(ctx, "Stress Memory", duration,
+				"--vm", "0", "--vm-bytes", fmt.Sprintf("%v%%", p.MemoryPercent), "--verify"))
+		}()
+	}
+
+	if p.Disks {
+		disks := append(runtime.GetNvmeList(), runtime.GetHddList()...)
+		for _, disk := range disks {
+			wg.Add(1)
+			go func(disk *runtime.DiskInfo) {
+				defer wg.Done()
+				addResult(stressDisk(ctx, disk, duration))
+			}(disk)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+waitLoop:
+	for {
+		select {
+		case <-done:
+			break waitLoop
+		case <-ticker.C:
+			progress := int(time.Since(start) * 100 / duration)
+			if 99 < progress {
+				progress = 99
+			}
+			job.SetProgress(progress)
+		}
+	}
+
+	close(stop)
+	for _, kernelErr := range <-kernelDone {
+		job.Logf("kernel error: %v", kernelErr.Description)
+		addResult(kernelErr)
+	}
+
+	if ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+
+	for _, result := range results.Results {
+		if result.Result != "OK" {
+			return results, xerrors.Errorf("presure test fail: %v", result.Description)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,211 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	lotusapi "github.com/NpoolDevOps/fbc-devops-peer/api/lotusapi"
+	"github.com/NpoolDevOps/fbc-devops-peer/api/lotusbase"
+	"github.com/NpoolDevOps/fbc-devops-peer/types"
+	"github.com/filecoin-project/lotus/api"
+	"golang.org/x/xerrors"
+)
+
+type rollbackBinParams struct {
+	Application string `json:"application"`
+	NoRestart   bool   `json:"no_restart"`
+}
+
+// apiRequest is replaced by tests to answer without a node
+var apiRequest = lotusbase.RequestWithToken
+
+// apiVersionTimeout is how long a restarted app gets to serve its api
+var apiVersionTimeout = unitActiveTimeout
+
+func apiInfoCall(info string, method string, result interface{}) error {
+	ep, err := lotusbase.ParseApiInfo(info, "v0")
+	if err != nil {
+		return err
+	}
+	b, err := apiRequest(ep.Url, []interface{}{}, "Filecoin."+method, ep.Token)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, result)
+	if err != nil {
+		return xerrors.Errorf("fail to parse %v from %v: %v", method, ep.Url, err)
+	}
+	return nil
+}
+
+// appApiVersion asks the api of app for its version, lotus through the
+// fullnode host, lotus-miner and lotus-worker through their api info.
+// lotus-worker answers the bare number of its api, not the node version
+func (op *Operation) appApiVersion(app string) (string, error) {
+	switch app {
+	case "lotus":
+		host, err := op.fullnodeApiHost()
+		if err != nil {
+			return "", xerrors.Errorf("cannot get fullnode api host: %v", err)
+		}
+		ver, err := lotusapi.ClientVersion(host)
+		if err != nil {
+			return "", xerrors.Errorf("cannot get lotus version from %v: %v", host, err)
+		}
+		return ver.Version, nil
+	case "lotus-miner":
+		info, err := op.apiInfo(types.MinerNode)
+		if err != nil {
+			return "", xerrors.Errorf("cannot get %v api info: %v", types.MinerNode, err)
+		}
+		ver := api.APIVersion{}
+		err = apiInfoCall(info, "Version", &ver)
+		if err != nil {
+			return "", xerrors.Errorf("cannot get %v version: %v", app, err)
+		}
+		return ver.Version, nil
+	case "lotus-worker":
+		info, err := op.apiInfo(types.WorkerNode)
+		if err != nil {
+			return "", xerrors.Errorf("cannot get %v api info: %v", types.WorkerNode, err)
+		}
+		var ver api.Version
+		err = apiInfoCall(info, "Version", &ver)
+		if err != nil {
+			return "", xerrors.Errorf("cannot get %v api version: %v", app, err)
+		}
+		return ver.String(), nil
+	}
+	return "", xerrors.Errorf("%v has no api", app)
+}
+
+// verifyBinVersion confirms the running app reports the version of the
+// installed binary, lotus apps are asked through their API, the others only
+// expose their version from the binary itself
+func (op *Operation) verifyBinVersion(ctx context.Context, job *Job, app string) (string, error) {
+	installed := filepath.Join(binInstallDir, app)
+	binVer := binVersion(ctx, installed)
+	if binVer == "" {
+		return "", xerrors.Errorf("cannot get version of %v", installed)
+	}
+
+	switch {
+	case app == "lotus" && op.fullnodeApiHost != nil:
+	case (app == "lotus-miner" || app == "lotus-worker") && op.apiInfo != nil:
+	default:
+		return binVer, nil
+	}
+
+	// the unit is active before its api is served
+	deadline := time.Now().Add(apiVersionTimeout)
+	ver, err := op.appApiVersion(app)
+	for err != nil && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(unitActiveCheckStep):
+		}
+		ver, err = op.appApiVersion(app)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// the worker api has no node version, serving it after the restart is
+	// what is checked and the version comes from the binary
+	if app == "lotus-worker" {
+		job.Logf("%v serves api %v, binary version %v", app, ver, binVer)
+		return binVer, nil
+	}
+
+	job.Logf("%v api version %v, binary version %v", app, ver, binVer)
+	if ver == "" || !strings.Contains(binVer, ver) {
+		return "", xerrors.Errorf("%v api version %v mismatch binary version %v", app, ver, binVer)
+	}
+
+	return ver, nil
+}
+
+func (op *Operation) rollbackBinExec(ctx context.Context, job *Job, params string) (interface{}, error) {
+	p := rollbackBinParams{}
+	err := json.Unmarshal([]byte(params), &p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBinApplication(p.Application)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := binHistory(p.Application)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, xerrors.Errorf("no history for %v", p.Application)
+	}
+	previous := history[0]
+
+	installed := filepath.Join(binInstallDir, p.Application)
+	undo := filepath.Join(binHistoryDir(p.Application), ".undo")
+	hasUndo := false
+	if _, err := os.Stat(installed); err == nil {
+		err = copyFile(installed, undo, 0755)
+		if err != nil {
+			return nil, xerrors.Errorf("fail to save %v: %v", installed, err)
+		}
+		hasUndo = true
+	}
+	defer os.Remove(undo)
+
+	job.Logf("rollback %v to %v", installed, previous)
+	err = copyFile(previous, installed, 0755)
+	if err != nil {
+		return nil, xerrors.Errorf("fail to rollback %v: %v", installed, err)
+	}
+	job.SetProgress(30)
+
+	version := ""
+	if !p.NoRestart {
+		err = restartUnit(ctx, job, p.Application)
+		if err == nil {
+			version, err = op.verifyBinVersion(ctx, job, p.Application)
+		}
+		if err != nil {
+			job.Logf("rollback verify fail: %v", err)
+			if !hasUndo {
+				return nil, err
+			}
+			job.Logf("undo rollback of %v", installed)
+			undoErr := copyFile(undo, installed, 0755)
+			if undoErr != nil {
+				return nil, xerrors.Errorf("%v, and fail to undo: %v", err, undoErr)
+			}
+			undoErr = restartUnit(ctx, job, p.Application)
+			if undoErr != nil {
+				return nil, xerrors.Errorf("%v, and fail to restart after undo: %v", err, undoErr)
+			}
+			return nil, xerrors.Errorf("%v, rollback undone", err)
+		}
+	} else {
+		version = binVersion(ctx, installed)
+	}
+
+	// The restored copy is consumed so the next rollback goes further back
+	os.Remove(previous)
+
+	sum, _ := fileSha256(installed)
+	job.Logf("rollback %v to %v done", p.Application, version)
+
+	return binOutput{
+		Application: p.Application,
+		Sha256:      sum,
+		Version:     version,
+		Path:        installed,
+	}, nil
+}
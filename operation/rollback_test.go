@@ -0,0 +1,165 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/NpoolDevOps/fbc-devops-peer/parser"
+	"github.com/NpoolDevOps/fbc-devops-peer/types"
+	"golang.org/x/xerrors"
+)
+
+func TestRollbackBin(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	installDir := t.TempDir()
+	oldInstallDir := binInstallDir
+	binInstallDir = installDir
+	defer func() { binInstallDir = oldInstallDir }()
+
+	installed := filepath.Join(installDir, "lotus-worker")
+	for _, ver := range []string{"v1", "v2", "v3"} {
+		_, err := saveBinHistory("lotus-worker")
+		if err != nil && ver != "v1" {
+			t.Fatalf("cannot save history: %v", err)
+		}
+		err = ioutil.WriteFile(installed, []byte("#!/bin/sh\necho "+ver+"\n"), 0755)
+		if err != nil {
+			t.Fatalf("cannot write bin: %v", err)
+		}
+	}
+
+	history, _ := binHistory("lotus-worker")
+	if len(history) != 2 {
+		t.Fatalf("history length %v, expect 2", len(history))
+	}
+
+	op := &Operation{}
+	params, _ := json.Marshal(rollbackBinParams{Application: "lotus-worker", NoRestart: true})
+
+	for _, expect := range []string{"v2", "v1"} {
+		out, err := op.rollbackBinExec(context.Background(), &Job{}, string(params))
+		if err != nil {
+			t.Fatalf("cannot rollback: %v", err)
+		}
+		if ver := out.(binOutput).Version; ver != expect {
+			t.Errorf("rollback version %v, expect %v", ver, expect)
+		}
+	}
+
+	_, err := op.rollbackBinExec(context.Background(), &Job{}, string(params))
+	if err == nil {
+		t.Errorf("rollback without history should fail")
+	}
+}
+
+func TestRollbackBinUndoOnApiVersionMismatch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	installDir := t.TempDir()
+	oldInstallDir, oldServiceFile, oldApiRequest, oldTimeout := binInstallDir, parser.MinerServiceFile, apiRequest, apiVersionTimeout
+	binInstallDir = installDir
+	parser.MinerServiceFile = filepath.Join(installDir, "lotus-miner.service")
+	apiVersionTimeout = 0
+	defer func() {
+		binInstallDir, parser.MinerServiceFile, apiRequest, apiVersionTimeout = oldInstallDir, oldServiceFile, oldApiRequest, oldTimeout
+	}()
+
+	installed := filepath.Join(installDir, "lotus-miner")
+	for _, ver := range []string{"v1", "v2"} {
+		saveBinHistory("lotus-miner")
+		err := ioutil.WriteFile(installed, []byte("#!/bin/sh\necho "+ver+"\n"), 0755)
+		if err != nil {
+			t.Fatalf("cannot write bin: %v", err)
+		}
+	}
+
+	// the miner keeps serving v2 after the rollback to v1
+	apiRequest = func(url string, params interface{}, method string, token string) ([]byte, error) {
+		if url != "http://127.0.0.1:2345/rpc/v0" || method != "Filecoin.Version" || token != "token" {
+			t.Errorf("unexpected request %v %v %v", url, method, token)
+		}
+		return []byte(`{"Version":"v2","APIVersion":66816,"BlockDelay":30}`), nil
+	}
+	op := &Operation{
+		apiInfo: func(role string) (string, error) {
+			if role != types.MinerNode {
+				return "", xerrors.Errorf("no api info of %v", role)
+			}
+			return "token:/ip4/127.0.0.1/tcp/2345/http", nil
+		},
+	}
+	params, _ := json.Marshal(rollbackBinParams{Application: "lotus-miner"})
+
+	_, err := op.rollbackBinExec(context.Background(), &Job{}, string(params))
+	if err == nil {
+		t.Fatalf("rollback should fail when the api version mismatches")
+	}
+	if ver := binVersion(context.Background(), installed); ver != "v2" {
+		t.Errorf("installed version %v after undo, expect v2", ver)
+	}
+	if history, _ := binHistory("lotus-miner"); len(history) != 1 {
+		t.Errorf("history length %v after undo, expect 1", len(history))
+	}
+}
+
+func TestRollbackBinWorkerApiVersion(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	installDir := t.TempDir()
+	oldInstallDir, oldServiceFile, oldApiRequest, oldTimeout := binInstallDir, parser.WorkerServiceFile, apiRequest, apiVersionTimeout
+	binInstallDir = installDir
+	parser.WorkerServiceFile = filepath.Join(installDir, "lotus-worker.service")
+	apiVersionTimeout = 0
+	defer func() {
+		binInstallDir, parser.WorkerServiceFile, apiRequest, apiVersionTimeout = oldInstallDir, oldServiceFile, oldApiRequest, oldTimeout
+	}()
+
+	installed := filepath.Join(installDir, "lotus-worker")
+	for _, ver := range []string{"v1", "v2"} {
+		saveBinHistory("lotus-worker")
+		err := ioutil.WriteFile(installed, []byte("#!/bin/sh\necho "+ver+"\n"), 0755)
+		if err != nil {
+			t.Fatalf("cannot write bin: %v", err)
+		}
+	}
+
+	// the worker answers the bare number of its api
+	apiRequest = func(url string, params interface{}, method string, token string) ([]byte, error) {
+		if url != "http://127.0.0.1:3456/rpc/v0" || method != "Filecoin.Version" {
+			t.Errorf("unexpected request %v %v", url, method)
+		}
+		return []byte("65536"), nil
+	}
+	op := &Operation{
+		apiInfo: func(role string) (string, error) {
+			if role != types.WorkerNode {
+				return "", xerrors.Errorf("no api info of %v", role)
+			}
+			return "token:/ip4/127.0.0.1/tcp/3456/http", nil
+		},
+	}
+	params, _ := json.Marshal(rollbackBinParams{Application: "lotus-worker"})
+
+	ret, err := op.rollbackBinExec(context.Background(), &Job{}, string(params))
+	if err != nil {
+		t.Fatalf("cannot rollback worker: %v", err)
+	}
+	if ver := ret.(binOutput).Version; ver != "v1" {
+		t.Errorf("rollback version %v, expect v1", ver)
+	}
+
+	// a worker not serving its api after the restart is undone
+	apiRequest = func(url string, params interface{}, method string, token string) ([]byte, error) {
+		return nil, xerrors.Errorf("connection refused")
+	}
+	saveBinHistory("lotus-worker")
+	ioutil.WriteFile(installed, []byte("#!/bin/sh\necho v3\n"), 0755)
+	_, err = op.rollbackBinExec(context.Background(), &Job{}, string(params))
+	if err == nil {
+		t.Fatalf("rollback should fail when the worker api is not served")
+	}
+	if ver := binVersion(context.Background(), installed); ver != "v3" {
+		t.Errorf("installed version %v after undo, expect v3", ver)
+	}
+}
@@ -0,0 +1,70 @@
+package operation
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	machspec "github.com/EntropyPool/machine-spec"
+	runtime "github.com/NpoolDevOps/fbc-devops-peer/runtime"
+	"github.com/docker/go-units"
+)
+
+var (
+	osReleaseFile     = "/etc/os-release"
+	kernelReleaseFile = "/proc/sys/kernel/osrelease"
+)
+
+// osRelease returns the distribution name with the kernel release, such as
+// "Ubuntu 18.04.5 LTS 5.4.0-42-generic", so OsSpec can match either part
+func osRelease() (string, error) {
+	f, err := os.Open(osReleaseFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	name := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := strings.SplitN(scanner.Text(), "=", 2)
+		if len(s) == 2 && s[0] == "PRETTY_NAME" {
+			name = strings.Trim(s[1], "\"")
+		}
+	}
+
+	b, err := ioutil.ReadFile(kernelReleaseFile)
+	if err != nil {
+		return name, err
+	}
+
+	return fmt.Sprintf("%v %v", name, strings.TrimSpace(string(b))), nil
+}
+
+func checkMemorys(p *acceptanceParams, mems []machspec.Memory) []acceptanceResult {
+	results := []acceptanceResult{}
+
+	results = append(results, newAcceptanceResult("Memory Count", p.Memorys, len(mems), nil))
+
+	if p.MemoryUnitSize == "" {
+		return results
+	}
+
+	unitBytes, err := units.RAMInBytes(p.MemoryUnitSize)
+	if err != nil {
+		return append(results, newAcceptanceResult("Memory Unit Bytes", p.MemoryUnitSize, "0", err))
+	}
+	unitGB := int(unitBytes / 1024 / 1024 / 1024)
+
+	for i, mem := range mems {
+		results = append(results, newAcceptanceResult(fmt.Sprintf("Memory %v %v Desc %v", i, mem.Dimm, p.MemoryUnitSize), unitGB, mem.SizeGB, nil))
+	}
+
+	return results
+}
+
+func memoryAcceptance(p *acceptanceParams) []acceptanceResult {
+	return checkMemorys(p, runtime.GetMemoryList())
+}
@@ -0,0 +1,47 @@
+package operation
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	machspec "github.com/EntropyPool/machine-spec"
+)
+
+func TestCheckMemorys(t *testing.T) {
+	p := &acceptanceParams{
+		Memorys:        2,
+		MemoryUnitSize: "32G",
+	}
+	mems := []machspec.Memory{
+		{Dimm: "DIMM_A1", SizeGB: 32},
+		{Dimm: "DIMM_B1", SizeGB: 16},
+	}
+
+	expects := []string{"OK", "OK", "ERROR"}
+	results := checkMemorys(p, mems)
+	if len(results) != len(expects) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	for i, result := range results {
+		if result.Result != expects[i] {
+			t.Errorf("%v: %v, expect %v", result.Description, result.Result, expects[i])
+		}
+	}
+}
+
+func TestOsRelease(t *testing.T) {
+	dir := t.TempDir()
+	oldOs, oldKernel := osReleaseFile, kernelReleaseFile
+	osReleaseFile = filepath.Join(dir, "os-release")
+	kernelReleaseFile = filepath.Join(dir, "osrelease")
+	defer func() { osReleaseFile, kernelReleaseFile = oldOs, oldKernel }()
+
+	ioutil.WriteFile(osReleaseFile, []byte("NAME=\"Ubuntu\"\nPRETTY_NAME=\"Ubuntu 18.04.5 LTS\"\n"), 0644)
+	ioutil.WriteFile(kernelReleaseFile, []byte("5.4.0-42-generic\n"), 0644)
+
+	release, err := osRelease()
+	if err != nil || release != "Ubuntu 18.04.5 LTS 5.4.0-42-generic" {
+		t.Errorf("unexpected os release %v: %v", release, err)
+	}
+}
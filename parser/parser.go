@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -18,19 +19,26 @@ import (
 )
 
 const (
-	FullnodeAPIFile     = "/etc/profile.d/fullnode-api-info.sh"
 	FullnodeEnvKey      = "FULLNODE_API_INFO"
+	MinerEnvKey         = "MINER_API_INFO"
+	StorageListDescFile = "storage.json"
+	StorageMetaFile     = "sectorstore.json"
+	ProcSelfMounts      = "/proc/self/mounts"
+)
+
+// Paths of the deployment files, overridden by the parser section of the
+// configuration file before the parser is created
+var (
+	FullnodeAPIFile     = "/etc/profile.d/fullnode-api-info.sh"
 	FullnodeServiceFile = "/etc/systemd/system/lotus-daemon.service"
 	MinerAPIFile        = "/etc/profile.d/miner-api-info.sh"
-	MinerEnvKey         = "MINER_API_INFO"
 	MinerServiceFile    = "/etc/systemd/system/lotus-miner.service"
 	WorkerServiceFile   = "/etc/systemd/system/lotus-worker.service"
 	CommonSetupFile     = "/etc/profile.d/lotus-setup.sh"
-	StorageListDescFile = "storage.json"
-	StorageMetaFile     = "sectorstore.json"
-	ProcSelfMounts      = "/proc/self/mounts"
 	HostsFile           = "/etc/hosts"
 	CephConfigFile      = "/etc/ceph/ceph.conf"
+	ChiaMinerLogFile    = "/var/log/chia/miner.log"
+	ChiaPlotterLogFile  = "/var/log/chia-plotter.log"
 )
 
 type nodeDesc struct {
@@ -46,7 +54,9 @@ type Parser struct {
 	storageConfig          StorageConfig
 	validStorageConfig     bool
 	storageMetas           []LocalStorageMeta
-	cephEntries            map[string]struct{}
+	mounts                 [][]string
+	storageMounts          []StorageMount
+	storageBackends        map[string]string
 	localAddr              string
 	cephStoragePeers       map[string]string
 	storageSubRole         string
@@ -62,6 +72,33 @@ type Parser struct {
 	fullnodeRepoDirApiFile string
 	minerRepoDir           string
 	fullnodeRepoDir        string
+	workerRepoDir          string
+	minerApiInfo           string
+	fullnodeApiInfo        string
+	workerApiInfo          string
+	sums                   map[string][sha256.Size]byte
+	err                    error
+	config                 *ParserConfig
+}
+
+// ParserConfig lets tests parse a fixture tree instead of the host
+type ParserConfig struct {
+	// Root is prepended to every path read, empty for the host filesystem
+	Root string
+	// LocalAddr skips the detection of local address when set
+	LocalAddr string
+	// Probe tells whether url answers with 200, it detects the storage role
+	Probe func(url string) bool
+	// Resolve looks up the addresses of storage servers mounted by name
+	Resolve func(host string) ([]string, error)
+}
+
+// StorageMount is a network filesystem mounted on this host
+type StorageMount struct {
+	Backend    string
+	Source     string
+	MountPoint string
+	Servers    []string
 }
 
 type OSSInfo struct {
@@ -94,27 +131,47 @@ type LocalPath struct {
 
 var parser *Parser
 
-func NewParser() *Parser {
+func httpProbe(url string) bool {
+	resp, err := httpdaemon.R().Get(url)
+	return err == nil && resp.StatusCode() == 200
+}
+
+func NewParser(config *ParserConfig) *Parser {
+	if config.Probe == nil {
+		config.Probe = httpProbe
+	}
+	if config.Resolve == nil {
+		config.Resolve = net.LookupHost
+	}
 	parser = &Parser{
+		config:                config,
 		fileAPIInfo:           map[string]nodeDesc{},
-		cephEntries:           map[string]struct{}{},
+		storageBackends:       map[string]string{},
 		cephStoragePeers:      map[string]string{},
 		minerShareStorageRoot: "/opt/sharestorage",
-		chiaMinerNodeLogFile:  "/var/log/chia/miner.log",
-		chiaPlotterLogFile:    "/var/log/chia-plotter.log",
+		chiaMinerNodeLogFile:  ChiaMinerLogFile,
+		chiaPlotterLogFile:    ChiaPlotterLogFile,
 	}
 	err := parser.parse()
 	if err != nil {
 		log.Errorf(log.Fields{}, "cannot parse node: %v", err)
 	}
-
-	parser.dump()
+	parser.err = err
+	parser.sums = fingerprint(parser.WatchedFiles())
 
 	return parser
 }
 
+// path maps an absolute path of the host into the root of the parser
+func (p *Parser) path(file string) string {
+	if p.config.Root == "" {
+		return file
+	}
+	return filepath.Join(p.config.Root, file)
+}
+
 func (p *Parser) readEnvFromAPIFile(filename string) error {
-	b, err := ioutil.ReadFile(filename)
+	b, err := ioutil.ReadFile(p.path(filename))
 	if err != nil {
 		log.Errorf(log.Fields{}, "cannot read %v: %v", filename, err)
 		return err
@@ -158,7 +215,7 @@ func (p *Parser) parseEnvs() {
 }
 
 func (p *Parser) getStoragePath() {
-	f, err := os.Open(CommonSetupFile)
+	f, err := os.Open(p.path(CommonSetupFile))
 	if err != nil {
 		log.Errorf(log.Fields{}, "cannot open %v: %v", CommonSetupFile, err)
 		return
@@ -178,7 +235,7 @@ func (p *Parser) getStoragePath() {
 				break
 			}
 			stoPath := s[1]
-			info, err := os.Stat(stoPath)
+			info, err := os.Stat(p.path(stoPath))
 			if err != nil {
 				log.Errorf(log.Fields{}, "storage path %v: %v", stoPath, err)
 				break
@@ -198,7 +255,7 @@ func (p *Parser) parseStoragePaths() {
 		return
 	}
 	storageCfg := filepath.Join(p.storagePath, StorageListDescFile)
-	b, err := ioutil.ReadFile(storageCfg)
+	b, err := ioutil.ReadFile(p.path(storageCfg))
 	if err != nil {
 		log.Errorf(log.Fields{}, "cannot read %v: %v", storageCfg, err)
 		return
@@ -231,7 +288,7 @@ func (p *Parser) parseLocalStorages() {
 	}
 	for _, path := range p.storageConfig.StoragePaths {
 		storeCfg := filepath.Join(path.Path, StorageMetaFile)
-		b, err := ioutil.ReadFile(storeCfg)
+		b, err := ioutil.ReadFile(p.path(storeCfg))
 		if err != nil {
 			log.Errorf(log.Fields{}, "fail to read %v: %v", storeCfg, err)
 			continue
@@ -243,53 +300,171 @@ func (p *Parser) parseLocalStorages() {
 			continue
 		}
 		p.storageMetas = append(p.storageMetas, meta)
+		if meta.Oss {
+			p.storageBackends[path.Path] = types.StorageBackendOss
+		}
 	}
 }
 
-func (p *Parser) getMountedCeph() {
-	f, err := os.Open(ProcSelfMounts)
+func (p *Parser) readMounts() {
+	f, err := os.Open(p.path(ProcSelfMounts))
 	if err != nil {
 		log.Errorf(log.Fields{}, "fail to open %v: %v", ProcSelfMounts, err)
 		return
 	}
+	defer f.Close()
+
 	bio := bufio.NewReader(f)
 	for {
 		line, _, err := bio.ReadLine()
 		if err != nil {
-			log.Errorf(log.Fields{}, "fail to read %v: %v", ProcSelfMounts, err)
 			break
 		}
-		if !strings.Contains(string(line), " ceph ") {
+		fields := strings.Fields(string(line))
+		if len(fields) < 4 {
+			continue
+		}
+		p.mounts = append(p.mounts, fields)
+	}
+}
+
+// serverOf returns the host of a server:path mount source
+func serverOf(source string) string {
+	if strings.HasPrefix(source, "[") {
+		return strings.Split(strings.TrimPrefix(source, "["), "]")[0]
+	}
+	return strings.Split(source, ":")[0]
+}
+
+func (p *Parser) getMountedCeph() {
+	for _, fields := range p.mounts {
+		if fields[2] != "ceph" {
 			continue
 		}
-		cephEntry := strings.Split(string(line), " ")[0]
-		cephEntry = strings.Split(cephEntry, ":/")[0]
-		p.cephEntries[cephEntry] = struct{}{}
+		mount := StorageMount{
+			Backend:    types.StorageBackendCeph,
+			Source:     fields[0],
+			MountPoint: fields[1],
+		}
+		monitors := strings.Split(fields[0], ":/")[0]
+		for _, monitor := range strings.Split(monitors, ",") {
+			mount.Servers = append(mount.Servers, serverOf(monitor))
+		}
+		p.storageMounts = append(p.storageMounts, mount)
 	}
 }
 
 func (p *Parser) getMountedGluster() {
-	log.Infof(log.Fields{}, "NOT IMPLEMENTED NOW")
+	for _, fields := range p.mounts {
+		if fields[2] != "fuse.glusterfs" && fields[2] != "glusterfs" {
+			continue
+		}
+		p.storageMounts = append(p.storageMounts, StorageMount{
+			Backend:    types.StorageBackendGluster,
+			Source:     fields[0],
+			MountPoint: fields[1],
+			Servers:    []string{serverOf(fields[0])},
+		})
+	}
+}
+
+func (p *Parser) getMountedNfs() {
+	for _, fields := range p.mounts {
+		if fields[2] != "nfs" && fields[2] != "nfs4" {
+			continue
+		}
+		server := serverOf(fields[0])
+		// kernel reports the address it connected to, prefer it to the name
+		for _, opt := range strings.Split(fields[3], ",") {
+			if strings.HasPrefix(opt, "addr=") {
+				server = strings.TrimPrefix(opt, "addr=")
+			}
+		}
+		p.storageMounts = append(p.storageMounts, StorageMount{
+			Backend:    types.StorageBackendNfs,
+			Source:     fields[0],
+			MountPoint: fields[1],
+			Servers:    []string{server},
+		})
+	}
+}
+
+func (p *Parser) resolveServer(server string) (string, error) {
+	if net.ParseIP(server) != nil {
+		return server, nil
+	}
+	addrs, err := p.config.Resolve(server)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			return addr, nil
+		}
+	}
+	if 0 < len(addrs) {
+		return addrs[0], nil
+	}
+	return "", xerrors.Errorf("no address for %v", server)
 }
 
 func (p *Parser) parseMinerStorageChilds() {
-	for entry := range p.cephEntries {
-		s := strings.Split(entry, ",")
-		for _, ss := range s {
-			sss := strings.Split(ss, ":")[0]
-			p.minerStorageChilds = append(p.minerStorageChilds, sss)
+	found := map[string]struct{}{}
+	addChild := func(ip string) {
+		if _, ok := found[ip]; ok {
+			return
+		}
+		found[ip] = struct{}{}
+		p.minerStorageChilds = append(p.minerStorageChilds, ip)
+	}
+
+	for _, mount := range p.storageMounts {
+		for _, server := range mount.Servers {
+			ip, err := p.resolveServer(server)
+			if err != nil {
+				log.Errorf(log.Fields{}, "cannot resolve %v server %v: %v", mount.Backend, server, err)
+				continue
+			}
+			addChild(ip)
 		}
 	}
 	for _, meta := range p.storageMetas {
 		if meta.Oss {
 			s := strings.Split(meta.OssInfo.URL, "://")[1]
 			s = strings.Split(s, ":")[0]
-			p.minerStorageChilds = append(p.minerStorageChilds, s)
+			addChild(s)
 		}
 	}
 }
 
+// parseStorageBackends classifies the miner storage paths by the mount they
+// are on, the longest mount point wins
+func (p *Parser) parseStorageBackends() {
+	for _, path := range p.storageConfig.StoragePaths {
+		if _, ok := p.storageBackends[path.Path]; ok || path.Path == "" {
+			continue
+		}
+		backend := types.StorageBackendLocal
+		longest := 0
+		for _, mount := range p.storageMounts {
+			point := strings.TrimSuffix(mount.MountPoint, "/")
+			if path.Path != point && !strings.HasPrefix(path.Path, point+"/") {
+				continue
+			}
+			if longest < len(point) {
+				longest = len(point)
+				backend = mount.Backend
+			}
+		}
+		p.storageBackends[path.Path] = backend
+	}
+}
+
 func (p *Parser) parseLocalAddress() {
+	if p.config.LocalAddr != "" {
+		p.localAddr = p.config.LocalAddr
+		return
+	}
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err == nil {
 		p.localAddr = strings.Split(conn.LocalAddr().String(), ":")[0]
@@ -298,7 +473,7 @@ func (p *Parser) parseLocalAddress() {
 }
 
 func (p *Parser) parseStorageHosts() {
-	f, _ := os.Open(HostsFile)
+	f, _ := os.Open(p.path(HostsFile))
 	bio := bufio.NewReader(f)
 	for {
 		line, _, err := bio.ReadLine()
@@ -321,31 +496,19 @@ func (p *Parser) parseStorageHosts() {
 }
 
 func (p *Parser) parseMyStorageRole() {
-	resp, err := httpdaemon.R().
-		Get(fmt.Sprintf("http://%v:9283", p.localAddr))
-	if err == nil {
-		if resp.StatusCode() == 200 {
-			p.storageSubRole = types.StorageRoleMgr
-			return
-		}
+	if p.config.Probe(fmt.Sprintf("http://%v:9283", p.localAddr)) {
+		p.storageSubRole = types.StorageRoleMgr
+		return
 	}
 
-	resp, err = httpdaemon.R().
-		Get(fmt.Sprintf("http://%v:9090", p.localAddr))
-	if err == nil {
-		if resp.StatusCode() == 200 {
-			p.storageSubRole = types.StorageRoleMgr
-			return
-		}
+	if p.config.Probe(fmt.Sprintf("http://%v:9090", p.localAddr)) {
+		p.storageSubRole = types.StorageRoleMgr
+		return
 	}
 
-	resp, err = httpdaemon.R().
-		Get(fmt.Sprintf("http://%v:7000", p.localAddr))
-	if err == nil {
-		if resp.StatusCode() == 200 {
-			p.storageSubRole = types.StorageRoleAPI
-			return
-		}
+	if p.config.Probe(fmt.Sprintf("http://%v:7000", p.localAddr)) {
+		p.storageSubRole = types.StorageRoleAPI
+		return
 	}
 
 	p.storageSubRole = types.StorageRoleOsd
@@ -360,7 +523,7 @@ func (p *Parser) parseStorageChilds() {
 }
 
 func (p *Parser) parseLogFileFromService(file string) string {
-	f, _ := os.Open(file)
+	f, _ := os.Open(p.path(file))
 	bio := bufio.NewReader(f)
 	for {
 		line, _, err := bio.ReadLine()
@@ -396,7 +559,7 @@ func (p *Parser) setEnvFromRepo(file string) {
 		return
 	}
 	apiPath := dir + "/api"
-	b, err := ioutil.ReadFile(apiPath)
+	b, err := ioutil.ReadFile(p.path(apiPath))
 	if err != nil {
 		log.Errorf(log.Fields{}, "read %v error %v", apiPath, err)
 		return
@@ -405,7 +568,7 @@ func (p *Parser) setEnvFromRepo(file string) {
 	apiS := strings.TrimSpace(string(b))
 
 	dirPath := dir + "/token"
-	b, err = ioutil.ReadFile(dirPath)
+	b, err = ioutil.ReadFile(p.path(dirPath))
 	if err != nil {
 		log.Errorf(log.Fields{}, "read %v error %v", dirPath, err)
 		return
@@ -416,16 +579,40 @@ func (p *Parser) setEnvFromRepo(file string) {
 
 	switch file {
 	case MinerServiceFile:
+		p.minerApiInfo = env
 		os.Setenv(MinerEnvKey, env)
 		log.Infof(log.Fields{}, "set environment %v -> %v", MinerEnvKey, env)
 	case FullnodeServiceFile:
+		p.fullnodeApiInfo = env
 		os.Setenv(FullnodeEnvKey, env)
 		log.Infof(log.Fields{}, "set environment %v -> %v", FullnodeEnvKey, env)
 	}
 }
 
+// setWorkerApiInfo builds the api info of the local worker, the worker repo
+// only has the api address, the worker accepts the token of its miner
+func (p *Parser) setWorkerApiInfo() {
+	dir, err := p.parseRepoDirFromService(WorkerServiceFile)
+	if err != nil || dir == "" {
+		return
+	}
+	p.workerRepoDir = dir
+	b, err := ioutil.ReadFile(p.path(dir + "/api"))
+	if err != nil {
+		log.Errorf(log.Fields{}, "read %v error %v", dir+"/api", err)
+		return
+	}
+	minerApiInfo, err := p.GetApiInfo(types.MinerNode)
+	if err != nil {
+		log.Errorf(log.Fields{}, "no token for worker api: %v", err)
+		return
+	}
+	token := strings.SplitN(minerApiInfo, ":", 2)[0]
+	p.workerApiInfo = token + ":" + strings.TrimSpace(string(b))
+}
+
 func (p *Parser) parseRepoDirFromService(file string) (string, error) {
-	f, err := os.Open(file)
+	f, err := os.Open(p.path(file))
 	if err != nil {
 		log.Errorf(log.Fields{}, "open file %v err: %v", file, err)
 		return "", err
@@ -466,7 +653,7 @@ func (p *Parser) parseRepoFile() {
 }
 
 func (p *Parser) parseApiHostFromRepoDirApiFile(file string) (string, error) {
-	repoDirApiFile, err := os.Open(file)
+	repoDirApiFile, err := os.Open(p.path(file))
 	if err != nil {
 		log.Errorf(log.Fields{}, "open file %v err: %v", file, err)
 		return "", err
@@ -487,7 +674,7 @@ func (p *Parser) parseApiHostFromRepoDirApiFile(file string) (string, error) {
 }
 
 func (p *Parser) parseApiHostFromApiFile(file string) (string, error) {
-	apiFile, err := os.Open(file)
+	apiFile, err := os.Open(p.path(file))
 	if err != nil {
 		log.Errorf(log.Fields{}, "open file %v err: %v", file, err)
 		return "", err
@@ -527,13 +714,17 @@ func (p *Parser) parse() error {
 	p.setEnvFromRepo(FullnodeServiceFile)
 	p.readEnvFromAPIFile(FullnodeAPIFile)
 	p.readEnvFromAPIFile(MinerAPIFile)
+	p.setWorkerApiInfo()
 	p.parseEnvs()
 	p.getStoragePath()
 	p.parseStoragePaths()
 	p.parseLocalStorages()
+	p.readMounts()
 	p.getMountedCeph()
 	p.getMountedGluster()
+	p.getMountedNfs()
 	p.parseMinerStorageChilds()
+	p.parseStorageBackends()
 	p.parseLocalAddress()
 	p.parseStorageHosts()
 	p.parseMyStorageRole()
@@ -541,35 +732,36 @@ func (p *Parser) parse() error {
 	p.parseLogFiles()
 
 	p.parseApiHosts()
-	return nil
-}
-
-func (p *Parser) dump() {
-	fmt.Printf("PARSER ---\n")
-	fmt.Printf("  API INFOS ---\n")
-	for key, val := range p.fileAPIInfo {
-		fmt.Printf("    %v ---\n", key)
-		fmt.Printf("      env: %v\n", val.apiInfo)
-		fmt.Printf("      ip:  %v\n", val.ip)
-	}
-	fmt.Printf("  Storage Path --- %v\n", p.storagePath)
-	fmt.Printf("  Ceph Entries --\n")
-	for entry := range p.cephEntries {
-		fmt.Printf("    %v\n", entry)
-	}
-	fmt.Printf("  Ceph IPs --\n")
-	for _, child := range p.minerStorageChilds {
-		fmt.Printf("    %v\n", child)
-	}
-	fmt.Printf("  Ceph Childs ---\n")
-	for k, v := range p.cephStoragePeers {
-		fmt.Printf("    %v: %v\n", k, v)
-	}
-	fmt.Printf("  Storage Role --- %v\n", p.storageSubRole)
-	fmt.Printf("  Storage Childs ---\n")
-	for _, child := range p.storageChilds {
-		fmt.Printf("    %v\n", child)
+	return p.checkApiInfos()
+}
+
+// checkApiInfos fails when a node has its service or api file but no api
+// info can be taken from it, the files are likely rewritten by a restart
+func (p *Parser) checkApiInfos() error {
+	for _, node := range []struct {
+		role        string
+		serviceFile string
+		apiFile     string
+	}{
+		{types.FullNode, FullnodeServiceFile, FullnodeAPIFile},
+		{types.MinerNode, MinerServiceFile, MinerAPIFile},
+		{types.WorkerNode, WorkerServiceFile, ""},
+	} {
+		if node.apiFile != "" {
+			if _, err := os.Stat(p.path(node.apiFile)); err == nil {
+				if _, ok := p.fileAPIInfo[node.apiFile]; !ok {
+					return xerrors.Errorf("invalid api info in %v", node.apiFile)
+				}
+			}
+		}
+		if _, err := os.Stat(p.path(node.serviceFile)); err != nil {
+			continue
+		}
+		if _, err := p.GetApiInfo(node.role); err != nil {
+			return xerrors.Errorf("%v exists but %v", node.serviceFile, err)
+		}
 	}
+	return nil
 }
 
 func (p *Parser) GetParentIP(myRole string) (string, error) {
@@ -660,6 +852,35 @@ func (p *Parser) GetApiHostByHostRole(myRole string) (string, error) {
 
 }
 
+// GetApiInfo returns TOKEN:MULTIADDR of the role, the token in the repo
+// dir is preferred since the exported one may lack admin permission
+func (p *Parser) GetApiInfo(myRole string) (string, error) {
+	info := ""
+	file := ""
+	switch myRole {
+	case types.MinerNode:
+		info = p.minerApiInfo
+		file = MinerAPIFile
+	case types.FullNode:
+		info = p.fullnodeApiInfo
+		file = FullnodeAPIFile
+	case types.WorkerNode:
+		if p.workerApiInfo == "" {
+			return "", xerrors.Errorf("no api info of %v", myRole)
+		}
+		return p.workerApiInfo, nil
+	default:
+		return "", xerrors.Errorf("no api info for role: %v", myRole)
+	}
+	if info != "" {
+		return info, nil
+	}
+	if desc, ok := p.fileAPIInfo[file]; ok {
+		return strings.TrimSpace(desc.apiInfo), nil
+	}
+	return "", xerrors.Errorf("no api info of %v", myRole)
+}
+
 func (p *Parser) GetMinerStoragePath() []string {
 	var paths []string
 	for _, path := range p.storageConfig.StoragePaths {
@@ -668,6 +889,16 @@ func (p *Parser) GetMinerStoragePath() []string {
 	return paths
 }
 
+// GetStorageMounts returns the network filesystems mounted on this host
+func (p *Parser) GetStorageMounts() []StorageMount {
+	return p.storageMounts
+}
+
+// GetMinerStorageBackends returns the backend of each miner storage path
+func (p *Parser) GetMinerStorageBackends() map[string]string {
+	return p.storageBackends
+}
+
 func (p *Parser) GetFullnodeStoragePath() string {
 	return p.fullnodeRepoDir
 }
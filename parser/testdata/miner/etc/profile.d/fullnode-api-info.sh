@@ -0,0 +1 @@
+export FULLNODE_API_INFO=eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJBbGxvdyI6WyJyZWFkIl19.token:/ip4/10.0.0.1/tcp/1234/http
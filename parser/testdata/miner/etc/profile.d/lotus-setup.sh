@@ -0,0 +1 @@
+export ENV_LOTUS_STORAGE_PATH=/opt/lotusstorage
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+)
+
+// ReloadInterval is how often the parsed files are checked for changes,
+// overridden by the parser section of the configuration file
+var ReloadInterval = 30 * time.Second
+
+// WatchedFiles returns the files the topology is parsed from, mounts do not
+// report modification time so files are compared by content taken at parse
+func (p *Parser) WatchedFiles() []string {
+	files := []string{}
+	for _, file := range []string{
+		FullnodeServiceFile,
+		MinerServiceFile,
+		WorkerServiceFile,
+		FullnodeAPIFile,
+		MinerAPIFile,
+		CommonSetupFile,
+		HostsFile,
+		ProcSelfMounts,
+	} {
+		files = append(files, p.path(file))
+	}
+	if p.minerRepoDir != "" {
+		files = append(files, p.path(p.minerRepoDirApiFile), p.path(p.minerRepoDir+"/token"))
+	}
+	if p.fullnodeRepoDir != "" {
+		files = append(files, p.path(p.fullnodeRepoDirApiFile), p.path(p.fullnodeRepoDir+"/token"))
+	}
+	if p.workerRepoDir != "" {
+		files = append(files, p.path(p.workerRepoDir+"/api"))
+	}
+	if p.validStoragePath {
+		files = append(files, p.path(filepath.Join(p.storagePath, StorageListDescFile)))
+	}
+	for _, path := range p.storageConfig.StoragePaths {
+		files = append(files, p.path(filepath.Join(path.Path, StorageMetaFile)))
+	}
+	return files
+}
+
+func fingerprint(files []string) map[string][sha256.Size]byte {
+	sums := map[string][sha256.Size]byte{}
+	for _, file := range files {
+		b, _ := ioutil.ReadFile(file)
+		sums[file] = sha256.Sum256(b)
+	}
+	return sums
+}
+
+func changedFile(old, cur map[string][sha256.Size]byte) (string, bool) {
+	for file, sum := range cur {
+		if oldSum, ok := old[file]; !ok || oldSum != sum {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// Watch parses again when one of the watched files changes and passes the
+// new parser to onChange, it returns when ctx is done. Changed files are
+// parsed once they stay the same for an interval, and the old parser is
+// kept when parsing fails
+func (p *Parser) Watch(ctx context.Context, interval time.Duration, onChange func(*Parser)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	cur := p
+	var pending map[string][sha256.Size]byte
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		sums := fingerprint(cur.WatchedFiles())
+		file, changed := changedFile(cur.sums, sums)
+		if !changed {
+			pending = nil
+			continue
+		}
+		if _, writing := changedFile(pending, sums); writing {
+			pending = sums
+			continue
+		}
+		pending = nil
+
+		log.Infof(log.Fields{}, "%v changed, parse topology again", file)
+		next := NewParser(cur.config)
+		if next.err != nil {
+			log.Errorf(log.Fields{}, "keep the current topology: %v", next.err)
+			continue
+		}
+		cur = next
+		onChange(cur)
+	}
+}
@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+)
+
+func TestWatchReparseOnChange(t *testing.T) {
+	root := t.TempDir()
+	apiFile := filepath.Join(root, MinerAPIFile)
+	os.MkdirAll(filepath.Dir(apiFile), 0755)
+	writeAPIInfo := func(ip string) {
+		err := ioutil.WriteFile(apiFile, []byte("export MINER_API_INFO=token:/ip4/"+ip+"/tcp/2345/http"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeAPIInfo("10.0.0.1")
+
+	p := NewParser(&ParserConfig{
+		Root:      root,
+		LocalAddr: "10.0.0.30",
+		Probe:     func(string) bool { return false },
+	})
+	if host, _ := p.GetApiHostByHostRole(types.MinerNode); host != "10.0.0.1" {
+		t.Fatalf("miner api host should be 10.0.0.1, got %v", host)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan *Parser, 1)
+	done := make(chan struct{})
+	go func() {
+		p.Watch(ctx, 10*time.Millisecond, func(np *Parser) { changed <- np })
+		close(done)
+	}()
+
+	writeAPIInfo("10.0.0.2")
+
+	select {
+	case np := <-changed:
+		if host, _ := np.GetApiHostByHostRole(types.MinerNode); host != "10.0.0.2" {
+			t.Errorf("miner api host should be 10.0.0.2, got %v", host)
+		}
+		if ip, _ := np.GetParentIP(types.WorkerNode); ip != "10.0.0.2" {
+			t.Errorf("worker parent should be 10.0.0.2, got %v", ip)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("parser is not reloaded after %v changed", apiFile)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestWatchWaitsForFilesToSettle(t *testing.T) {
+	root := t.TempDir()
+	apiFile := filepath.Join(root, MinerAPIFile)
+	os.MkdirAll(filepath.Dir(apiFile), 0755)
+	writeAPIInfo := func(ip string) {
+		ioutil.WriteFile(apiFile, []byte("export MINER_API_INFO=token:/ip4/"+ip+"/tcp/2345/http"), 0644)
+	}
+	writeAPIInfo("10.0.0.1")
+
+	p := NewParser(&ParserConfig{
+		Root:      root,
+		LocalAddr: "10.0.0.30",
+		Probe:     func(string) bool { return false },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan *Parser, 10)
+	done := make(chan struct{})
+	go func() {
+		p.Watch(ctx, 20*time.Millisecond, func(np *Parser) { changed <- np })
+		close(done)
+	}()
+
+	// the file is rewritten faster than the watch interval
+	for i := 0; i < 50; i++ {
+		writeAPIInfo(fmt.Sprintf("10.0.1.%v", i))
+		time.Sleep(2 * time.Millisecond)
+	}
+	select {
+	case np := <-changed:
+		host, _ := np.GetApiHostByHostRole(types.MinerNode)
+		t.Fatalf("parser reloaded while the file is written, miner api host %v", host)
+	default:
+	}
+
+	select {
+	case np := <-changed:
+		if host, _ := np.GetApiHostByHostRole(types.MinerNode); host != "10.0.1.49" {
+			t.Errorf("miner api host should be 10.0.1.49, got %v", host)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("parser is not reloaded after %v settled", apiFile)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestWatchKeepsTopologyOnParseError(t *testing.T) {
+	root := t.TempDir()
+	apiFile := filepath.Join(root, MinerAPIFile)
+	os.MkdirAll(filepath.Dir(apiFile), 0755)
+	ioutil.WriteFile(apiFile, []byte("export MINER_API_INFO=token:/ip4/10.0.0.1/tcp/2345/http"), 0644)
+
+	p := NewParser(&ParserConfig{
+		Root:      root,
+		LocalAddr: "10.0.0.30",
+		Probe:     func(string) bool { return false },
+	})
+	if p.err != nil {
+		t.Fatalf("cannot parse %v: %v", root, p.err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan *Parser, 10)
+	done := make(chan struct{})
+	go func() {
+		p.Watch(ctx, 10*time.Millisecond, func(np *Parser) { changed <- np })
+		close(done)
+	}()
+
+	// the api file is left truncated by the miner
+	ioutil.WriteFile(apiFile, []byte("export MINER_API_INFO"), 0644)
+	select {
+	case np := <-changed:
+		host, _ := np.GetApiHostByHostRole(types.MinerNode)
+		t.Fatalf("topology replaced by a broken parse, miner api host %v", host)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	ioutil.WriteFile(apiFile, []byte("export MINER_API_INFO=token:/ip4/10.0.0.2/tcp/2345/http"), 0644)
+	select {
+	case np := <-changed:
+		if host, _ := np.GetApiHostByHostRole(types.MinerNode); host != "10.0.0.2" {
+			t.Errorf("miner api host should be 10.0.0.2, got %v", host)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("parser is not reloaded after %v is fixed", apiFile)
+	}
+
+	cancel()
+	<-done
+}
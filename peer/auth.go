@@ -0,0 +1,208 @@
+package peer
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// AnyAction grants permission to every operation action
+	AnyAction = "*"
+
+	maxOperationClockSkew = 5 * time.Minute
+	maxOperationNonceLen  = 64
+)
+
+// OperationKey is one entry of the trusted key set, identified either by an
+// ed25519 public key or by a username / bcrypt password hash pair
+type OperationKey struct {
+	PublicKey    string   `json:"public_key"`
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"password_hash"`
+	Actions      []string `json:"actions"`
+}
+
+type OperationAuth struct {
+	keys []OperationKey
+	// nonces seen of signed inputs until their timestamp leaves the skew window
+	nonces map[string]time.Time
+	mutex  sync.Mutex
+}
+
+func NewOperationAuth(keyFile string) *OperationAuth {
+	auth := &OperationAuth{
+		keys:   []OperationKey{},
+		nonces: map[string]time.Time{},
+	}
+
+	if keyFile == "" {
+		log.Infof(log.Fields{}, "no operation key file, all operations will be rejected")
+		return auth
+	}
+
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot read operation keys %v: %v", keyFile, err)
+		return auth
+	}
+
+	keys := []OperationKey{}
+	err = json.Unmarshal(b, &keys)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot parse operation keys %v: %v", keyFile, err)
+		return auth
+	}
+
+	for _, key := range keys {
+		if key.PublicKey != "" {
+			pub, err := hex.DecodeString(key.PublicKey)
+			if err != nil || len(pub) != ed25519.PublicKeySize {
+				log.Errorf(log.Fields{}, "invalid operation public key %v", key.PublicKey)
+				continue
+			}
+		} else if key.Username == "" || key.PasswordHash == "" {
+			log.Errorf(log.Fields{}, "operation key without public key or credentials")
+			continue
+		} else if _, err := bcrypt.Cost([]byte(key.PasswordHash)); err != nil {
+			log.Errorf(log.Fields{}, "invalid bcrypt password hash of %v: %v", key.Username, err)
+			continue
+		}
+		auth.keys = append(auth.keys, key)
+	}
+
+	return auth
+}
+
+// OperationSignPayload returns the bytes an operator signs with its ed25519
+// private key, one field per line: the http method and path of the request,
+// the compact JSON of the action (object keys sorted), the job id of the job
+// routes (empty elsewhere), the unix timestamp and a nonce never used before
+// by the key
+func OperationSignPayload(method string, path string, action types.OperationAction, jobId string, timestamp int64, nonce string) ([]byte, error) {
+	b, err := json.Marshal(action)
+	if err != nil {
+		return nil, err
+	}
+
+	var canonical interface{}
+	err = json.Unmarshal(b, &canonical)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = json.Marshal(canonical)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf("%v %v\n%s\n%v\n%v\n%v", method, path, b, jobId, timestamp, nonce)), nil
+}
+
+// useNonce remembers the nonce of pub and fails if it was seen, entries are
+// dropped once their timestamp is out of the skew window so the cache stays
+// bounded by the requests of one window
+func (a *OperationAuth) useNonce(pub string, nonce string, timestamp int64) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	for key, expire := range a.nonces {
+		if expire.Before(now) {
+			delete(a.nonces, key)
+		}
+	}
+
+	key := pub + ":" + nonce
+	if _, ok := a.nonces[key]; ok {
+		return xerrors.Errorf("nonce %v already used", nonce)
+	}
+	a.nonces[key] = time.Unix(timestamp, 0).Add(maxOperationClockSkew)
+	return nil
+}
+
+func (a *OperationAuth) verifySignature(input *types.OperationInput, method string, path string, jobId string) (*OperationKey, error) {
+	skew := time.Since(time.Unix(input.Timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if maxOperationClockSkew < skew {
+		return nil, xerrors.Errorf("operation timestamp %v out of range", input.Timestamp)
+	}
+
+	if input.Nonce == "" || maxOperationNonceLen < len(input.Nonce) {
+		return nil, xerrors.Errorf("invalid nonce %q", input.Nonce)
+	}
+
+	sig, err := hex.DecodeString(input.Signature)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid signature: %v", err)
+	}
+
+	payload, err := OperationSignPayload(method, path, input.Action, jobId, input.Timestamp, input.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range a.keys {
+		if key.PublicKey == "" || key.PublicKey != input.PublicKey {
+			continue
+		}
+		pub, _ := hex.DecodeString(key.PublicKey)
+		if !ed25519.Verify(ed25519.PublicKey(pub), payload, sig) {
+			return nil, xerrors.Errorf("signature mismatch for %v", input.PublicKey)
+		}
+		err = a.useNonce(key.PublicKey, input.Nonce, input.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		return &a.keys[i], nil
+	}
+
+	return nil, xerrors.Errorf("untrusted public key %v", input.PublicKey)
+}
+
+func (a *OperationAuth) verifyCredential(input *types.OperationInput) (*OperationKey, error) {
+	for i, key := range a.keys {
+		if key.Username == "" || key.Username != input.Username {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(key.PasswordHash), []byte(input.Password)) != nil {
+			return nil, xerrors.Errorf("invalid password for %v", input.Username)
+		}
+		return &a.keys[i], nil
+	}
+
+	return nil, xerrors.Errorf("untrusted user %v", input.Username)
+}
+
+// Authenticate finds the trusted key matching the input of the request to
+// method and path about jobId, preferring the signature over the plain
+// credentials
+func (a *OperationAuth) Authenticate(input *types.OperationInput, method string, path string, jobId string) (*OperationKey, error) {
+	if input.PublicKey != "" && input.Signature != "" {
+		return a.verifySignature(input, method, path, jobId)
+	}
+	if input.Username != "" && input.Password != "" {
+		return a.verifyCredential(input)
+	}
+	return nil, xerrors.Errorf("no signature or credential provided")
+}
+
+func (k *OperationKey) Permit(action string) bool {
+	for _, act := range k.Actions {
+		if act == AnyAction || act == action {
+			return true
+		}
+	}
+	return false
+}
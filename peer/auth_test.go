@@ -0,0 +1,147 @@
+package peer
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	types "github.com/NpoolDevOps/fbc-devops-peer/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeOperationKeys(t *testing.T, keys []OperationKey) string {
+	b, _ := json.Marshal(keys)
+	file := filepath.Join(t.TempDir(), "operation-keys.json")
+	err := ioutil.WriteFile(file, b, 0600)
+	if err != nil {
+		t.Fatalf("cannot write operation keys: %v", err)
+	}
+	return file
+}
+
+func TestOperationAuthSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	file := writeOperationKeys(t, []OperationKey{
+		{PublicKey: hex.EncodeToString(pub), Actions: []string{"acceptance"}},
+	})
+	auth := NewOperationAuth(file)
+
+	action := types.OperationAction{
+		Action: "acceptance",
+		Params: map[string]interface{}{"nvmes": 2, "cpus": 1},
+	}
+	now := time.Now().Unix()
+	payload, _ := OperationSignPayload("POST", types.OperationSubmitAPI, action, "", now, "n1")
+
+	input := &types.OperationInput{
+		PublicKey: hex.EncodeToString(pub),
+		Signature: hex.EncodeToString(ed25519.Sign(priv, payload)),
+		Timestamp: now,
+		Nonce:     "n1",
+		Action:    action,
+	}
+
+	key, err := auth.Authenticate(input, "POST", types.OperationSubmitAPI, "")
+	if err != nil {
+		t.Fatalf("cannot authenticate signed input: %v", err)
+	}
+	if !key.Permit("acceptance") || key.Permit("installbin") {
+		t.Errorf("unexpected permissions %v", key.Actions)
+	}
+	if _, err := auth.Authenticate(input, "POST", types.OperationSubmitAPI, ""); err == nil {
+		t.Errorf("replayed input should be rejected")
+	}
+
+	input.Nonce = "n2"
+	if _, err := auth.Authenticate(input, "POST", types.OperationSubmitAPI, ""); err == nil {
+		t.Errorf("nonce not covered by the signature should be rejected")
+	}
+	input.Nonce = ""
+	if _, err := auth.Authenticate(input, "POST", types.OperationSubmitAPI, ""); err == nil {
+		t.Errorf("input without nonce should be rejected")
+	}
+	input.Nonce = "n1"
+
+	input.Action.Action = "installbin"
+	if _, err := auth.Authenticate(input, "POST", types.OperationSubmitAPI, ""); err == nil {
+		t.Errorf("tampered action should be rejected")
+	}
+
+	input.Action.Action = "acceptance"
+	if _, err := auth.Authenticate(input, "POST", types.OperationAPI, ""); err == nil {
+		t.Errorf("signature replayed to another path should be rejected")
+	}
+	if _, err := auth.Authenticate(input, "POST", types.OperationSubmitAPI, "job-1"); err == nil {
+		t.Errorf("signature replayed for a job should be rejected")
+	}
+
+	input.Timestamp = now - 3600
+	if _, err := auth.Authenticate(input, "POST", types.OperationSubmitAPI, ""); err == nil {
+		t.Errorf("stale timestamp should be rejected")
+	}
+}
+
+func TestOperationAuthJobSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	file := writeOperationKeys(t, []OperationKey{
+		{PublicKey: hex.EncodeToString(pub), Actions: []string{AnyAction}},
+	})
+	auth := NewOperationAuth(file)
+
+	now := time.Now().Unix()
+	payload, _ := OperationSignPayload("POST", types.OperationJobAPI, types.OperationAction{}, "job-1", now, "n1")
+	input := &types.OperationInput{
+		PublicKey: hex.EncodeToString(pub),
+		Signature: hex.EncodeToString(ed25519.Sign(priv, payload)),
+		Timestamp: now,
+		Nonce:     "n1",
+	}
+
+	if _, err := auth.Authenticate(input, "POST", types.OperationJobAPI, "job-2"); err == nil {
+		t.Errorf("signature of job-1 should not fit job-2")
+	}
+	if _, err := auth.Authenticate(input, "POST", types.OperationJobAPI, "job-1"); err != nil {
+		t.Fatalf("cannot authenticate signed job input: %v", err)
+	}
+	if _, err := auth.Authenticate(input, "POST", types.OperationJobCancelAPI, "job-1"); err == nil {
+		t.Errorf("signature to get job-1 should not cancel it")
+	}
+}
+
+func TestOperationAuthCredential(t *testing.T) {
+	passHash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	shaHash := sha256.Sum256([]byte("secret"))
+	file := writeOperationKeys(t, []OperationKey{
+		{Username: "ops", PasswordHash: string(passHash), Actions: []string{AnyAction}},
+		{Username: "legacy", PasswordHash: hex.EncodeToString(shaHash[0:]), Actions: []string{AnyAction}},
+	})
+	auth := NewOperationAuth(file)
+
+	key, err := auth.Authenticate(&types.OperationInput{Username: "ops", Password: "secret"}, "POST", types.OperationSubmitAPI, "")
+	if err != nil {
+		t.Fatalf("cannot authenticate credential: %v", err)
+	}
+	if !key.Permit("installbin") {
+		t.Errorf("wildcard key should permit installbin")
+	}
+
+	if _, err := auth.Authenticate(&types.OperationInput{Username: "ops", Password: "wrong"}, "POST", types.OperationSubmitAPI, ""); err == nil {
+		t.Errorf("wrong password should be rejected")
+	}
+	if _, err := auth.Authenticate(&types.OperationInput{Username: "legacy", Password: "secret"}, "POST", types.OperationSubmitAPI, ""); err == nil {
+		t.Errorf("unsalted sha256 password hash should be rejected")
+	}
+}
+
+func TestOperationAuthNoKeys(t *testing.T) {
+	auth := NewOperationAuth("")
+	_, err := auth.Authenticate(&types.OperationInput{Username: "ops", Password: "secret"}, "POST", types.OperationSubmitAPI, "")
+	if err == nil {
+		t.Errorf("operation should be rejected without trusted keys")
+	}
+}
@@ -1,10 +1,12 @@
 package peer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/EntropyPool/entropy-logger"
 	machspec "github.com/EntropyPool/machine-spec"
+	lifecycle "github.com/NpoolDevOps/fbc-devops-peer/lifecycle"
 	"github.com/NpoolDevOps/fbc-devops-peer/node"
 	"github.com/NpoolDevOps/fbc-devops-peer/operation"
 	types "github.com/NpoolDevOps/fbc-devops-peer/types"
@@ -15,24 +17,62 @@ import (
 	"time"
 )
 
-const peerHttpPort = 52375
+const (
+	defaultPeerHttpPort       = 52375
+	defaultParentSpecInterval = 2 * time.Minute
+	httpShutdownTimeout       = 5 * time.Second
+)
+
+const (
+	ErrCodeUnauthorized = -3
+	ErrCodeForbidden    = -4
+)
+
+type PeerConfig struct {
+	OperationKeyFile   string
+	HttpPort           int
+	ParentSpecInterval time.Duration
+}
 
 type Peer struct {
 	Node             node.Node
 	parentSpecTicker *time.Ticker
+	refresh          chan struct{}
+	httpPort         int
 	spec             string
 	operation        *operation.Operation
+	operationAuth    *OperationAuth
 }
 
-func NewPeer(node node.Node) *Peer {
+func NewPeer(node node.Node, config *PeerConfig) *Peer {
 	spec := machspec.NewMachineSpec()
 	spec.PrepareLowLevel()
 
+	opConfig := &operation.OperationConfig{
+		Context: node.Context(),
+		FullnodeApiHost: func() (string, error) {
+			return node.GetFullnodeApiHost(types.FullNode)
+		},
+		ApiInfo: node.GetApiInfo,
+	}
+
+	httpPort := config.HttpPort
+	if httpPort <= 0 {
+		httpPort = defaultPeerHttpPort
+	}
+	interval := config.ParentSpecInterval
+	if interval <= 0 {
+		interval = defaultParentSpecInterval
+	}
+
 	conn := &Peer{
 		Node:             node,
-		parentSpecTicker: time.NewTicker(2 * time.Minute),
+		parentSpecTicker: time.NewTicker(interval),
+		refresh:          make(chan struct{}, 1),
+		httpPort:         httpPort,
 		spec:             spec.SN(),
-		operation:        operation.NewOperation(),
+		operation:        operation.NewOperation(opConfig),
+		operationAuth:    NewOperationAuth(config.OperationKeyFile),
 	}
 
 	return conn
@@ -60,7 +100,22 @@ func (p *Peer) handler() {
 		} else {
 			log.Infof(log.Fields{}, "cannot get childs for %v : %v", p.Node.GetMainRole(), err)
 		}
-		<-p.parentSpecTicker.C
+		select {
+		case <-p.parentSpecTicker.C:
+		case <-p.refresh:
+		case <-p.Node.Context().Done():
+			p.parentSpecTicker.Stop()
+			return
+		}
+	}
+}
+
+// TopologyChanged exchanges parent spec with the new parent and childs
+// without waiting for the ticker
+func (p *Peer) TopologyChanged() {
+	select {
+	case p.refresh <- struct{}{}:
+	default:
 	}
 }
 
@@ -87,15 +142,50 @@ func (p *Peer) HeartbeatRequest(w http.ResponseWriter, req *http.Request) (inter
 	return nil, "", 0
 }
 
-func (p *Peer) OperationRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+func (p *Peer) authenticate(input *types.OperationInput, jobId string, req *http.Request) (*OperationKey, string, int) {
+	key, err := p.operationAuth.Authenticate(input, req.Method, req.URL.Path, jobId)
+	if err != nil {
+		log.Errorf(log.Fields{}, "reject operation %v from %v: %v", input.Action.Action, req.RemoteAddr, err)
+		return nil, fmt.Sprintf("unauthorized: %v", err), ErrCodeUnauthorized
+	}
+	return key, "", 0
+}
+
+func (p *Peer) authorize(key *OperationKey, action string, req *http.Request) (string, int) {
+	if !key.Permit(action) {
+		log.Errorf(log.Fields{}, "reject operation %v from %v: permission denied", action, req.RemoteAddr)
+		return fmt.Sprintf("forbidden: action %v not permitted", action), ErrCodeForbidden
+	}
+	return "", 0
+}
+
+func (p *Peer) readOperationInput(req *http.Request) (*types.OperationInput, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		return nil, err.Error(), -1
 	}
 	input := types.OperationInput{}
-	json.Unmarshal(b, &input)
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	key, msg, code := p.authenticate(&input, "", req)
+	if code != 0 {
+		return nil, msg, code
+	}
+	msg, code = p.authorize(key, input.Action.Action, req)
+	if code != 0 {
+		return nil, msg, code
+	}
+	return &input, "", 0
+}
 
-	// TODO: verify public key or username / password
+func (p *Peer) OperationRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	input, msg, code := p.readOperationInput(req)
+	if code != 0 {
+		return nil, msg, code
+	}
 
 	resp, err := p.operation.Exec(input.Action)
 	if err != nil {
@@ -105,6 +195,109 @@ func (p *Peer) OperationRequest(w http.ResponseWriter, req *http.Request) (inter
 	return resp, "", 0
 }
 
+func (p *Peer) OperationSubmitRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	input, msg, code := p.readOperationInput(req)
+	if code != 0 {
+		return nil, msg, code
+	}
+
+	record, err := p.operation.Submit(input.Action)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	return record, "", 0
+}
+
+func (p *Peer) readOperationJobInput(req *http.Request) (*types.OperationJobInput, error) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	input := types.OperationJobInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err
+	}
+	return &input, nil
+}
+
+func (p *Peer) OperationJobRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	input, err := p.readOperationJobInput(req)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	// nothing about the job is told before the caller is authenticated
+	key, msg, code := p.authenticate(&input.OperationInput, input.JobId, req)
+	if code != 0 {
+		return nil, msg, code
+	}
+
+	record, err := p.operation.Jobs().Get(input.JobId)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	msg, code = p.authorize(key, record.Action, req)
+	if code != 0 {
+		return nil, msg, code
+	}
+
+	return record, "", 0
+}
+
+func (p *Peer) OperationJobListRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	input, err := p.readOperationJobInput(req)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	key, msg, code := p.authenticate(&input.OperationInput, "", req)
+	if code != 0 {
+		return nil, msg, code
+	}
+
+	records := []operation.JobRecord{}
+	for _, record := range p.operation.Jobs().List() {
+		if key.Permit(record.Action) {
+			records = append(records, record)
+		}
+	}
+
+	return records, "", 0
+}
+
+func (p *Peer) OperationJobCancelRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	input, err := p.readOperationJobInput(req)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	// nothing about the job is told before the caller is authenticated
+	key, msg, code := p.authenticate(&input.OperationInput, input.JobId, req)
+	if code != 0 {
+		return nil, msg, code
+	}
+
+	record, err := p.operation.Jobs().Get(input.JobId)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	msg, code = p.authorize(key, record.Action, req)
+	if code != 0 {
+		return nil, msg, code
+	}
+
+	err = p.operation.Jobs().Cancel(input.JobId)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	return nil, "", 0
+}
+
 func (p *Peer) Run() {
 	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
 		Location: types.ParentSpecAPI,
@@ -126,14 +319,60 @@ func (p *Peer) Run() {
 		Method:   "POST",
 		Handler:  p.OperationRequest,
 	})
-	httpdaemon.Run(peerHttpPort)
-	go p.handler()
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.OperationSubmitAPI,
+		Method:   "POST",
+		Handler:  p.OperationSubmitRequest,
+	})
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.OperationJobAPI,
+		Method:   "POST",
+		Handler:  p.OperationJobRequest,
+	})
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.OperationJobListAPI,
+		Method:   "POST",
+		Handler:  p.OperationJobListRequest,
+	})
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.OperationJobCancelAPI,
+		Method:   "POST",
+		Handler:  p.OperationJobCancelRequest,
+	})
+	p.serve()
+	lifecycle.Go(p.handler)
+}
+
+// serve listens on the mux httpdaemon registers the routes to, httpdaemon.Run
+// cannot be stopped so the peer owns the server and closes it on shutdown
+func (p *Peer) serve() {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", p.httpPort),
+		Handler: http.DefaultServeMux,
+	}
+
+	lifecycle.Go(func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf(log.Fields{}, "fail to serve peer api on %v: %v", server.Addr, err)
+		}
+	})
+	lifecycle.Go(func() {
+		<-p.Node.Context().Done()
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to shutdown peer api: %v", err)
+			server.Close()
+		}
+	})
 }
 
 func (p *Peer) GetParentSpec(parentPeer string) (string, error) {
 	resp, err := httpdaemon.R().
 		SetHeader("Content-Type", "application/json").
-		Get(fmt.Sprintf("http://%v:%v%v", parentPeer, peerHttpPort, types.ParentSpecAPI))
+		Get(fmt.Sprintf("http://%v:%v%v", parentPeer, p.httpPort, types.ParentSpecAPI))
 	if err != nil {
 		log.Errorf(log.Fields{}, "fail to get parent spec: %v", err)
 		return "", err
@@ -168,7 +407,7 @@ func (p *Peer) NotifyParentSpec(childPeer string) error {
 		SetBody(types.NotifyParentSpecInput{
 			ParentSpec: spec.SN(),
 		}).
-		Post(fmt.Sprintf("http://%v:%v%v", childPeer, peerHttpPort, types.ParentSpecAPI))
+		Post(fmt.Sprintf("http://%v:%v%v", childPeer, p.httpPort, types.ParentSpecAPI))
 	if err != nil {
 		return err
 	}
@@ -179,9 +418,9 @@ func (p *Peer) NotifyParentSpec(childPeer string) error {
 }
 
 func (p *Peer) Heartbeat(childPeer string) error {
-	resp, err := httpdaemon.Cli().SetTimeout(1 * time.Second).R().
+	resp, err := httpdaemon.Cli().SetTimeout(1*time.Second).R().
 		SetHeader("Content-Type", "application/json").
-		Get(fmt.Sprintf("http://%v:%v%v", childPeer, peerHttpPort, types.HeartbeatAPI))
+		Get(fmt.Sprintf("http://%v:%v%v", childPeer, p.httpPort, types.HeartbeatAPI))
 	if err != nil {
 		return err
 	}
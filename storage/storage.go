@@ -5,29 +5,44 @@ import (
 	"github.com/NpoolDevOps/fbc-devops-peer/basenode"
 	devops "github.com/NpoolDevOps/fbc-devops-peer/devops"
 	exporter "github.com/NpoolDevOps/fbc-devops-peer/exporter"
+	cephmetrics "github.com/NpoolDevOps/fbc-devops-peer/metrics/cephmetrics"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type StorageNode struct {
 	*basenode.Basenode
+	cephMetrics *cephmetrics.CephMetrics
 }
 
 func NewStorageNode(config *basenode.BasenodeConfig, devopsClient *devops.DevopsClient) *StorageNode {
 	log.Infof(log.Fields{}, "create %v ndoe", config.NodeConfig.MainRole)
+	return NewStorageNodeWithBase(basenode.NewBasenode(config, devopsClient))
+}
+
+// NewStorageNodeWithBase creates the role on a basenode shared with other roles
+func NewStorageNodeWithBase(base *basenode.Basenode) *StorageNode {
 	storage := &StorageNode{
-		basenode.NewBasenode(config, devopsClient),
+		base,
+		nil,
 	}
+	storage.cephMetrics = cephmetrics.NewCephMetrics(storage.Context(), storage.GetSubRole, storage.Username, storage.NetworkType)
 	return storage
 }
 
+func (n *StorageNode) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{n.cephMetrics}
+}
+
 func (n *StorageNode) Describe(ch chan<- *prometheus.Desc) {
+	n.cephMetrics.Describe(ch)
 	n.BaseMetrics.Describe(ch)
 }
 
 func (n *StorageNode) Collect(ch chan<- prometheus.Metric) {
+	n.cephMetrics.Collect(ch)
 	n.BaseMetrics.Collect(ch)
 }
 
 func (n *StorageNode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(n)
+	return exporter.NewExporter(n.Context(), n)
 }
@@ -1,9 +1,22 @@
 package types
 
+import (
+	"os"
+	"path/filepath"
+)
+
 const (
 	ParentSpecAPI = "/api/v0/peer/parentspec"
 	HeartbeatAPI  = "/api/v0/peer/heartbeat"
 	OperationAPI  = "/api/v0/peer/operation"
+
+	// OperationSubmitAPI runs the operation as a job and answers with the
+	// job record at once, OperationAPI answers with the result when done
+	OperationSubmitAPI = "/api/v1/peer/operation"
+
+	OperationJobAPI       = "/api/v0/peer/operation/job"
+	OperationJobListAPI   = "/api/v0/peer/operation/jobs"
+	OperationJobCancelAPI = "/api/v0/peer/operation/job/cancel"
 )
 
 const (
@@ -24,5 +37,25 @@ const (
 )
 
 const (
+	StorageBackendLocal   = "local"
+	StorageBackendCeph    = "ceph"
+	StorageBackendGluster = "glusterfs"
+	StorageBackendNfs     = "nfs"
+	StorageBackendOss     = "oss"
+)
+
+// ExporterPort is configurable, every peer scraped by the gateway must use
+// the same port
+var (
 	ExporterPort = 52379
+	// StateDir keeps jobs, binary history, baseline profile and report
+	// outbox, empty for $HOME/.fbc-devops-peer
+	StateDir = ""
 )
+
+func PeerStateDir() string {
+	if StateDir != "" {
+		return StateDir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".fbc-devops-peer")
+}
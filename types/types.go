@@ -17,5 +17,31 @@ type OperationInput struct {
 	PublicKey string          `json:"public_key"`
 	Username  string          `json:"username"`
 	Password  string          `json:"password"`
+	Signature string          `json:"signature"`
+	Timestamp int64           `json:"timestamp"`
+	Nonce     string          `json:"nonce"`
 	Action    OperationAction `json:"action"`
 }
+
+type OperationJobInput struct {
+	OperationInput
+	JobId string `json:"job_id"`
+}
+
+type SubsystemHealth struct {
+	Name        string `json:"name"`
+	Healthy     bool   `json:"healthy"`
+	LastError   string `json:"last_error"`
+	LastErrorAt int64  `json:"last_error_at"`
+	UpdateAt    int64  `json:"update_at"`
+}
+
+type PeerStatus struct {
+	Id         string            `json:"id"`
+	Spec       string            `json:"spec"`
+	Role       string            `json:"role"`
+	SubRole    string            `json:"sub_role"`
+	Version    string            `json:"version"`
+	Uptime     int64             `json:"uptime"`
+	Subsystems []SubsystemHealth `json:"subsystems"`
+}
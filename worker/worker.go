@@ -16,14 +16,23 @@ type WorkerNode struct {
 
 func NewWorkerNode(config *basenode.BasenodeConfig, devopsClient *devops.DevopsClient) *WorkerNode {
 	log.Infof(log.Fields{}, "create %v ndoe", config.NodeConfig.MainRole)
+	return NewWorkerNodeWithBase(basenode.NewBasenode(config, devopsClient))
+}
+
+// NewWorkerNodeWithBase creates the role on a basenode shared with other roles
+func NewWorkerNodeWithBase(base *basenode.Basenode) *WorkerNode {
 	worker := &WorkerNode{
-		basenode.NewBasenode(config, devopsClient),
+		base,
 		nil,
 	}
 	worker.workermetrics = workermetrics.NewWorkerMetrics(worker.Username, worker.NetworkType)
 	return worker
 }
 
+func (n *WorkerNode) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{n.workermetrics}
+}
+
 func (n *WorkerNode) Describe(ch chan<- *prometheus.Desc) {
 	n.BaseMetrics.Describe(ch)
 	n.workermetrics.Describe(ch)
@@ -35,7 +44,7 @@ func (n *WorkerNode) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (n *WorkerNode) CreateExporter() *exporter.Exporter {
-	return exporter.NewExporter(n)
+	return exporter.NewExporter(n.Context(), n)
 }
 
 func (n *WorkerNode) Banner() {